@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"interpreter/ast"
+	"interpreter/format"
+	"interpreter/lexer"
+	"interpreter/parser"
+	"io"
+	"os"
+)
+
+func runAST(args []string) int {
+	flags := flag.NewFlagSet("ast", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: monkey ast [file%s]\n", sourceExt)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return 2
+	}
+
+	filename := "<standard input>"
+	var src []byte
+	var err error
+	if flags.NArg() == 0 {
+		src, err = io.ReadAll(os.Stdin)
+	} else {
+		filename = flags.Arg(0)
+		src, err = os.ReadFile(filename)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "monkey ast: %s\n", err)
+		return 2
+	}
+	if err := dumpAST(filename, src); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// dumpAST prints the syntax tree of src as indented JSON.
+func dumpAST(filename string, src []byte) error {
+	p := parser.New(lexer.NewWithFilename(filename, string(src)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return &format.ParseError{Source: string(src), Diagnostics: p.Errors()}
+	}
+	data, err := ast.ToJSON(program)
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, data, "", "  "); err != nil {
+		return err
+	}
+	out.WriteByte('\n')
+	_, err = os.Stdout.Write(out.Bytes())
+	return err
+}
@@ -4,12 +4,18 @@ import (
 	"bytes"
 	"fmt"
 	"interpreter/token"
+	"math/big"
+	"sort"
 	"strings"
 )
 
+// Node is implemented by every AST node. Pos returns the position of the
+// first character of the node and End the position immediately after it.
 type Node interface {
 	TokenLiteral() string
 	String() string
+	Pos() token.Position
+	End() token.Position
 }
 
 type Statement interface {
@@ -24,6 +30,8 @@ type Expression interface {
 
 type Program struct {
 	Statements []Statement
+	// Comments holds all comments of the source in order of appearance.
+	Comments []token.Comment
 }
 
 func (p *Program) String() string {
@@ -41,6 +49,20 @@ func (p *Program) TokenLiteral() string {
 	return ""
 }
 
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+
+func (p *Program) End() token.Position {
+	if n := len(p.Statements); n > 0 {
+		return p.Statements[n-1].End()
+	}
+	return token.Position{}
+}
+
 type LetStatement struct {
 	Token token.Token
 	Name  *Identifier
@@ -66,6 +88,18 @@ func (s *LetStatement) TokenLiteral() string {
 	return s.Token.Literal
 }
 
+func (s *LetStatement) Pos() token.Position { return s.Token.Pos }
+
+func (s *LetStatement) End() token.Position {
+	if s.Value != nil {
+		return s.Value.End()
+	}
+	if s.Name != nil {
+		return s.Name.End()
+	}
+	return s.Token.End
+}
+
 type Identifier struct {
 	Token token.Token
 	Value string
@@ -81,6 +115,10 @@ func (i *Identifier) TokenLiteral() string {
 	return i.Value
 }
 
+func (i *Identifier) Pos() token.Position { return i.Token.Pos }
+
+func (i *Identifier) End() token.Position { return i.Token.End }
+
 type ReturnStatement struct {
 	Token       token.Token
 	ReturnValue Expression
@@ -102,6 +140,213 @@ func (rs *ReturnStatement) TokenLiteral() string {
 	return rs.Token.Literal
 }
 
+func (rs *ReturnStatement) Pos() token.Position { return rs.Token.Pos }
+
+func (rs *ReturnStatement) End() token.Position {
+	if rs.ReturnValue != nil {
+		return rs.ReturnValue.End()
+	}
+	return rs.Token.End
+}
+
+type AssignStatement struct {
+	Token    token.Token // The assignment operator token, e.g. '=' or '+='
+	Target   Expression  // an *Identifier or an *IndexExpression
+	Operator string
+	Value    Expression
+}
+
+func (as *AssignStatement) statementNode() {}
+
+func (as *AssignStatement) TokenLiteral() string { return as.Token.Literal }
+
+func (as *AssignStatement) Pos() token.Position { return as.Target.Pos() }
+
+func (as *AssignStatement) End() token.Position {
+	if as.Value != nil {
+		return as.Value.End()
+	}
+	return as.Token.End
+}
+
+func (as *AssignStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString(as.Target.String())
+	out.WriteString(" " + as.Operator + " ")
+	if as.Value != nil {
+		out.WriteString(as.Value.String())
+	}
+	out.WriteString(";")
+	return out.String()
+}
+
+type WhileStatement struct {
+	Token     token.Token // The 'while' token
+	Condition Expression
+	Body      *BlockStatement
+}
+
+func (ws *WhileStatement) statementNode() {}
+
+func (ws *WhileStatement) TokenLiteral() string { return ws.Token.Literal }
+
+func (ws *WhileStatement) Pos() token.Position { return ws.Token.Pos }
+
+func (ws *WhileStatement) End() token.Position {
+	if ws.Body != nil {
+		return ws.Body.End()
+	}
+	return ws.Token.End
+}
+
+func (ws *WhileStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString("while")
+	out.WriteString(ws.Condition.String())
+	out.WriteString(" ")
+	out.WriteString(ws.Body.String())
+	return out.String()
+}
+
+type ForStatement struct {
+	Token    token.Token // The 'for' token
+	Key      *Identifier // nil unless two loop variables are given
+	Value    *Identifier
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+func (fs *ForStatement) statementNode() {}
+
+func (fs *ForStatement) TokenLiteral() string { return fs.Token.Literal }
+
+func (fs *ForStatement) Pos() token.Position { return fs.Token.Pos }
+
+func (fs *ForStatement) End() token.Position {
+	if fs.Body != nil {
+		return fs.Body.End()
+	}
+	return fs.Token.End
+}
+
+func (fs *ForStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString("for(")
+	if fs.Key != nil {
+		out.WriteString(fs.Key.String())
+		out.WriteString(", ")
+	}
+	out.WriteString(fs.Value.String())
+	out.WriteString(" in ")
+	out.WriteString(fs.Iterable.String())
+	out.WriteString(") ")
+	out.WriteString(fs.Body.String())
+	return out.String()
+}
+
+type BreakStatement struct {
+	Token token.Token // The 'break' token
+}
+
+func (bs *BreakStatement) statementNode() {}
+
+func (bs *BreakStatement) TokenLiteral() string { return bs.Token.Literal }
+
+func (bs *BreakStatement) Pos() token.Position { return bs.Token.Pos }
+
+func (bs *BreakStatement) End() token.Position { return bs.Token.End }
+
+func (bs *BreakStatement) String() string { return bs.Token.Literal + ";" }
+
+type ContinueStatement struct {
+	Token token.Token // The 'continue' token
+}
+
+func (cs *ContinueStatement) statementNode() {}
+
+func (cs *ContinueStatement) TokenLiteral() string { return cs.Token.Literal }
+
+func (cs *ContinueStatement) Pos() token.Position { return cs.Token.Pos }
+
+func (cs *ContinueStatement) End() token.Position { return cs.Token.End }
+
+func (cs *ContinueStatement) String() string { return cs.Token.Literal + ";" }
+
+type ThrowStatement struct {
+	Token token.Token // The 'throw' token
+	Value Expression
+}
+
+func (ts *ThrowStatement) statementNode() {}
+
+func (ts *ThrowStatement) TokenLiteral() string { return ts.Token.Literal }
+
+func (ts *ThrowStatement) Pos() token.Position { return ts.Token.Pos }
+
+func (ts *ThrowStatement) End() token.Position {
+	if ts.Value != nil {
+		return ts.Value.End()
+	}
+	return ts.Token.End
+}
+
+func (ts *ThrowStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString(ts.TokenLiteral() + " ")
+	if ts.Value != nil {
+		out.WriteString(ts.Value.String())
+	}
+	out.WriteString(";")
+	return out.String()
+}
+
+// TryStatement runs Body and, if it throws, Catch with the exception bound to
+// Param. Finally runs in any case. Either Catch or Finally may be nil, not
+// both.
+type TryStatement struct {
+	Token   token.Token // The 'try' token
+	Body    *BlockStatement
+	Param   *Identifier // nil if Catch is nil
+	Catch   *BlockStatement
+	Finally *BlockStatement
+}
+
+func (ts *TryStatement) statementNode() {}
+
+func (ts *TryStatement) TokenLiteral() string { return ts.Token.Literal }
+
+func (ts *TryStatement) Pos() token.Position { return ts.Token.Pos }
+
+func (ts *TryStatement) End() token.Position {
+	if ts.Finally != nil {
+		return ts.Finally.End()
+	}
+	if ts.Catch != nil {
+		return ts.Catch.End()
+	}
+	if ts.Body != nil {
+		return ts.Body.End()
+	}
+	return ts.Token.End
+}
+
+func (ts *TryStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString("try ")
+	out.WriteString(ts.Body.String())
+	if ts.Catch != nil {
+		out.WriteString("catch(")
+		out.WriteString(ts.Param.String())
+		out.WriteString(") ")
+		out.WriteString(ts.Catch.String())
+	}
+	if ts.Finally != nil {
+		out.WriteString("finally ")
+		out.WriteString(ts.Finally.String())
+	}
+	return out.String()
+}
+
 type ExpressionStatement struct {
 	Token      token.Token
 	Expression Expression
@@ -113,9 +358,26 @@ func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
 
 func (es *ExpressionStatement) String() string { return es.Expression.String() }
 
+func (es *ExpressionStatement) Pos() token.Position {
+	if es.Expression != nil {
+		return es.Expression.Pos()
+	}
+	return es.Token.Pos
+}
+
+func (es *ExpressionStatement) End() token.Position {
+	if es.Expression != nil {
+		return es.Expression.End()
+	}
+	return es.Token.End
+}
+
 type IntegerLiteral struct {
 	Token token.Token
 	Value int64
+	// Big holds the value of literals that do not fit into Value, which is
+	// 0 then, and is nil otherwise
+	Big *big.Int
 }
 
 func (es *IntegerLiteral) expressionNode() {}
@@ -124,6 +386,25 @@ func (es *IntegerLiteral) TokenLiteral() string { return es.Token.Literal }
 
 func (es *IntegerLiteral) String() string { return es.Token.Literal }
 
+func (es *IntegerLiteral) Pos() token.Position { return es.Token.Pos }
+
+func (es *IntegerLiteral) End() token.Position { return es.Token.End }
+
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
+func (fl *FloatLiteral) expressionNode() {}
+
+func (fl *FloatLiteral) TokenLiteral() string { return fl.Token.Literal }
+
+func (fl *FloatLiteral) String() string { return fl.Token.Literal }
+
+func (fl *FloatLiteral) Pos() token.Position { return fl.Token.Pos }
+
+func (fl *FloatLiteral) End() token.Position { return fl.Token.End }
+
 type PrefixExpression struct {
 	Token    token.Token
 	Operator string
@@ -134,6 +415,15 @@ func (es *PrefixExpression) expressionNode() {}
 
 func (es *PrefixExpression) TokenLiteral() string { return es.Token.Literal }
 
+func (es *PrefixExpression) Pos() token.Position { return es.Token.Pos }
+
+func (es *PrefixExpression) End() token.Position {
+	if es.Right != nil {
+		return es.Right.End()
+	}
+	return es.Token.End
+}
+
 func (pe *PrefixExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("(")
@@ -154,6 +444,20 @@ func (es *InfixExpression) expressionNode() {}
 
 func (es *InfixExpression) TokenLiteral() string { return es.Token.Literal }
 
+func (es *InfixExpression) Pos() token.Position {
+	if es.Left != nil {
+		return es.Left.Pos()
+	}
+	return es.Token.Pos
+}
+
+func (es *InfixExpression) End() token.Position {
+	if es.Right != nil {
+		return es.Right.End()
+	}
+	return es.Token.End
+}
+
 func (pe *InfixExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("(")
@@ -177,15 +481,24 @@ func (es *Boolean) TokenLiteral() string { return es.Token.Literal }
 
 func (es *Boolean) String() string { return es.Token.Literal }
 
+func (es *Boolean) Pos() token.Position { return es.Token.Pos }
+
+func (es *Boolean) End() token.Position { return es.Token.End }
+
 type BlockStatement struct {
-	Token      token.Token
+	Token      token.Token // The '{' token
 	Statements []Statement
+	Rbrace     token.Token
 }
 
 func (bs *BlockStatement) statementNode() {}
 
 func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
 
+func (bs *BlockStatement) Pos() token.Position { return bs.Token.Pos }
+
+func (bs *BlockStatement) End() token.Position { return bs.Rbrace.End }
+
 func (bs *BlockStatement) String() string {
 	var out bytes.Buffer
 	for _, s := range bs.Statements {
@@ -205,6 +518,18 @@ func (ie *IfExpression) expressionNode() {}
 
 func (ie *IfExpression) TokenLiteral() string { return ie.Token.Literal }
 
+func (ie *IfExpression) Pos() token.Position { return ie.Token.Pos }
+
+func (ie *IfExpression) End() token.Position {
+	if ie.Alternative != nil {
+		return ie.Alternative.End()
+	}
+	if ie.Consequence != nil {
+		return ie.Consequence.End()
+	}
+	return ie.Token.End
+}
+
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("if")
@@ -219,7 +544,7 @@ func (ie *IfExpression) String() string {
 }
 
 type FunctionLiteral struct {
-	Token      token.Token // The 'if' token
+	Token      token.Token // The 'fn' token
 	Parameters []*Identifier
 	Body       *BlockStatement
 	Name string
@@ -229,6 +554,15 @@ func (fl *FunctionLiteral) expressionNode() {}
 
 func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
 
+func (fl *FunctionLiteral) Pos() token.Position { return fl.Token.Pos }
+
+func (fl *FunctionLiteral) End() token.Position {
+	if fl.Body != nil {
+		return fl.Body.End()
+	}
+	return fl.Token.End
+}
+
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 	params := []string{}
@@ -246,16 +580,59 @@ func (fl *FunctionLiteral) String() string {
 	return out.String()
 }
 
+type MacroLiteral struct {
+	Token      token.Token // The 'macro' token
+	Parameters []*Identifier
+	Body       *BlockStatement
+}
+
+func (ml *MacroLiteral) expressionNode() {}
+
+func (ml *MacroLiteral) TokenLiteral() string { return ml.Token.Literal }
+
+func (ml *MacroLiteral) Pos() token.Position { return ml.Token.Pos }
+
+func (ml *MacroLiteral) End() token.Position {
+	if ml.Body != nil {
+		return ml.Body.End()
+	}
+	return ml.Token.End
+}
+
+func (ml *MacroLiteral) String() string {
+	var out bytes.Buffer
+	params := []string{}
+	for _, p := range ml.Parameters {
+		params = append(params, p.String())
+	}
+	out.WriteString(ml.TokenLiteral())
+	out.WriteString("(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(") ")
+	out.WriteString(ml.Body.String())
+	return out.String()
+}
+
 type CallExpression struct {
-	Token     token.Token // The 'if' token
+	Token     token.Token // The '(' token
 	Function  Expression
 	Arguments []Expression
+	Rparen    token.Token
 }
 
 func (ce *CallExpression) expressionNode() {}
 
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
 
+func (ce *CallExpression) Pos() token.Position {
+	if ce.Function != nil {
+		return ce.Function.Pos()
+	}
+	return ce.Token.Pos
+}
+
+func (ce *CallExpression) End() token.Position { return ce.Rparen.End }
+
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 	params := []string{}
@@ -277,14 +654,19 @@ type StringLiteral struct {
 func (sl *StringLiteral) expressionNode()      {}
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
 func (sl *StringLiteral) String() string       { return sl.Token.Literal }
+func (sl *StringLiteral) Pos() token.Position  { return sl.Token.Pos }
+func (sl *StringLiteral) End() token.Position  { return sl.Token.End }
 
 type ArrayLiteral struct {
-	Token    token.Token
+	Token    token.Token // The '[' token
 	Elements []Expression
+	Rbracket token.Token
 }
 
 func (al *ArrayLiteral) expressionNode()      {}
 func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+func (al *ArrayLiteral) Pos() token.Position  { return al.Token.Pos }
+func (al *ArrayLiteral) End() token.Position  { return al.Rbracket.End }
 func (al *ArrayLiteral) String() string {
 	var out bytes.Buffer
 	elements := []string{}
@@ -298,15 +680,25 @@ func (al *ArrayLiteral) String() string {
 }
 
 type IndexExpression struct {
-	Token token.Token
-	Left  Expression
-	Index Expression
+	Token    token.Token // The '[' token
+	Left     Expression
+	Index    Expression
+	Rbracket token.Token
 }
 
 func (ie *IndexExpression) expressionNode() {}
 
 func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
 
+func (ie *IndexExpression) Pos() token.Position {
+	if ie.Left != nil {
+		return ie.Left.Pos()
+	}
+	return ie.Token.Pos
+}
+
+func (ie *IndexExpression) End() token.Position { return ie.Rbracket.End }
+
 func (ie *IndexExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("(")
@@ -318,18 +710,40 @@ func (ie *IndexExpression) String() string {
 }
 
 type HashLiteral struct {
-	Token token.Token
-	Pairs map[Expression]Expression
+	Token  token.Token // The '{' token
+	Pairs  map[Expression]Expression
+	Rbrace token.Token
 }
 
 func (ml *HashLiteral) expressionNode() {}
 
 func (ml *HashLiteral) TokenLiteral() string { return ml.Token.Literal }
 
+func (ml *HashLiteral) Pos() token.Position { return ml.Token.Pos }
+
+func (ml *HashLiteral) End() token.Position { return ml.Rbrace.End }
+
+// Keys returns the keys of the literal in source order. Keys without a
+// position, e.g. from quoted macro arguments, are ordered by their String.
+func (ml *HashLiteral) Keys() []Expression {
+	keys := make([]Expression, 0, len(ml.Pairs))
+	for key := range ml.Pairs {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		pi, pj := keys[i].Pos(), keys[j].Pos()
+		if pi.Offset != pj.Offset {
+			return pi.Offset < pj.Offset
+		}
+		return keys[i].String() < keys[j].String()
+	})
+	return keys
+}
+
 func (ml *HashLiteral) String() string {
 	var out bytes.Buffer
 	out.WriteString("{")
-	for key := range ml.Pairs {
+	for _, key := range ml.Keys() {
 		out.WriteString(key.String())
 		out.WriteString(": ")
 		out.WriteString(ml.Pairs[key].String())
@@ -0,0 +1,654 @@
+package ast
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"interpreter/token"
+	"math/big"
+)
+
+// The JSON encoding of a node is an object with a "kind" member holding the
+// name of the node type, e.g. "InfixExpression", a "span" member with the
+// positions returned by Pos and End, unless they are unknown, and one member
+// per child or attribute of the node:
+//
+//	{"kind": "InfixExpression", "operator": "+",
+//	 "left": {...}, "right": {...},
+//	 "span": {"start": {"offset": 0, "line": 1, "column": 1},
+//	          "end": {"offset": 5, "line": 1, "column": 6}}}
+//
+// Missing children are encoded as null. The pairs of a HashLiteral are
+// encoded as an array of {"key": ..., "value": ...} objects in the order of
+// Keys. The filename of the positions is stored once, on the Program.
+
+// ToJSON returns the JSON encoding of node.
+func ToJSON(node Node) ([]byte, error) {
+	return json.Marshal(encodeNode(node))
+}
+
+// FromJSON decodes a node encoded by ToJSON.
+func FromJSON(data []byte) (Node, error) {
+	d := &jsonDecoder{}
+	return d.node(data)
+}
+
+func (p *Program) MarshalJSON() ([]byte, error) {
+	return ToJSON(p)
+}
+
+func (p *Program) UnmarshalJSON(data []byte) error {
+	node, err := FromJSON(data)
+	if err != nil {
+		return err
+	}
+	program, ok := node.(*Program)
+	if !ok {
+		return fmt.Errorf("ast: expected Program, got %s", nodeKind(node))
+	}
+	*p = *program
+	return nil
+}
+
+// jsonObject is a JSON object that keeps its members in insertion order,
+// so that the encoding is stable and starts with the kind of the node.
+type jsonObject []jsonMember
+
+type jsonMember struct {
+	key   string
+	value interface{}
+}
+
+func (o *jsonObject) set(key string, value interface{}) {
+	*o = append(*o, jsonMember{key, value})
+}
+
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	var out bytes.Buffer
+	out.WriteByte('{')
+	for i, m := range o {
+		if i > 0 {
+			out.WriteByte(',')
+		}
+		key, _ := json.Marshal(m.key)
+		out.Write(key)
+		out.WriteByte(':')
+		value, err := json.Marshal(m.value)
+		if err != nil {
+			return nil, err
+		}
+		out.Write(value)
+	}
+	out.WriteByte('}')
+	return out.Bytes(), nil
+}
+
+type jsonPosition struct {
+	Offset int `json:"offset"`
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+type jsonSpan struct {
+	Start jsonPosition `json:"start"`
+	End   jsonPosition `json:"end"`
+}
+
+type jsonComment struct {
+	Text string   `json:"text"`
+	Span jsonSpan `json:"span"`
+}
+
+type jsonPair struct {
+	Key   interface{} `json:"key"`
+	Value interface{} `json:"value"`
+}
+
+func nodeKind(node Node) string {
+	return fmt.Sprintf("%T", node)[len("*ast."):]
+}
+
+func encodePosition(p token.Position) jsonPosition {
+	return jsonPosition{Offset: p.Offset, Line: p.Line, Column: p.Column}
+}
+
+func encodeNode(node Node) interface{} {
+	if node == nil {
+		return nil
+	}
+	obj := jsonObject{}
+	obj.set("kind", nodeKind(node))
+	if start := node.Pos(); start.IsValid() {
+		obj.set("span", jsonSpan{Start: encodePosition(start), End: encodePosition(node.End())})
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		obj.set("statements", encodeStatements(n.Statements))
+		comments := []jsonComment{}
+		for _, c := range n.Comments {
+			comments = append(comments, jsonComment{
+				Text: c.Text,
+				Span: jsonSpan{Start: encodePosition(c.Pos), End: encodePosition(c.End)},
+			})
+		}
+		obj.set("comments", comments)
+		if filename := n.Pos().Filename; filename != "" {
+			obj.set("filename", filename)
+		}
+	case *BlockStatement:
+		obj.set("statements", encodeStatements(n.Statements))
+	case *LetStatement:
+		obj.set("name", encodeIdentifier(n.Name))
+		obj.set("value", encodeExpression(n.Value))
+	case *ReturnStatement:
+		obj.set("value", encodeExpression(n.ReturnValue))
+	case *ThrowStatement:
+		obj.set("value", encodeExpression(n.Value))
+	case *TryStatement:
+		obj.set("body", encodeBlock(n.Body))
+		obj.set("param", encodeIdentifier(n.Param))
+		obj.set("catch", encodeBlock(n.Catch))
+		obj.set("finally", encodeBlock(n.Finally))
+	case *ExpressionStatement:
+		obj.set("expression", encodeExpression(n.Expression))
+	case *AssignStatement:
+		obj.set("target", encodeExpression(n.Target))
+		obj.set("operator", n.Operator)
+		obj.set("value", encodeExpression(n.Value))
+	case *WhileStatement:
+		obj.set("condition", encodeExpression(n.Condition))
+		obj.set("body", encodeBlock(n.Body))
+	case *ForStatement:
+		obj.set("key", encodeIdentifier(n.Key))
+		obj.set("value", encodeIdentifier(n.Value))
+		obj.set("iterable", encodeExpression(n.Iterable))
+		obj.set("body", encodeBlock(n.Body))
+	case *BreakStatement, *ContinueStatement:
+	case *Identifier:
+		obj.set("value", n.Value)
+	case *IntegerLiteral:
+		obj.set("literal", n.Token.Literal)
+		if n.Big != nil {
+			obj.set("value", n.Big)
+		} else {
+			obj.set("value", n.Value)
+		}
+	case *FloatLiteral:
+		obj.set("literal", n.Token.Literal)
+		obj.set("value", n.Value)
+	case *StringLiteral:
+		obj.set("value", n.Value)
+		obj.set("raw", n.Token.Type == token.RAW_STRING)
+	case *Boolean:
+		obj.set("value", n.Value)
+	case *PrefixExpression:
+		obj.set("operator", n.Operator)
+		obj.set("right", encodeExpression(n.Right))
+	case *InfixExpression:
+		obj.set("operator", n.Operator)
+		obj.set("left", encodeExpression(n.Left))
+		obj.set("right", encodeExpression(n.Right))
+	case *IfExpression:
+		obj.set("condition", encodeExpression(n.Condition))
+		obj.set("consequence", encodeBlock(n.Consequence))
+		obj.set("alternative", encodeBlock(n.Alternative))
+	case *FunctionLiteral:
+		if n.Name != "" {
+			obj.set("name", n.Name)
+		}
+		obj.set("parameters", encodeIdentifiers(n.Parameters))
+		obj.set("body", encodeBlock(n.Body))
+	case *MacroLiteral:
+		obj.set("parameters", encodeIdentifiers(n.Parameters))
+		obj.set("body", encodeBlock(n.Body))
+	case *CallExpression:
+		obj.set("function", encodeExpression(n.Function))
+		obj.set("arguments", encodeExpressions(n.Arguments))
+	case *ArrayLiteral:
+		obj.set("elements", encodeExpressions(n.Elements))
+	case *IndexExpression:
+		obj.set("left", encodeExpression(n.Left))
+		obj.set("index", encodeExpression(n.Index))
+	case *HashLiteral:
+		pairs := []jsonPair{}
+		for _, key := range n.Keys() {
+			pairs = append(pairs, jsonPair{Key: encodeExpression(key), Value: encodeExpression(n.Pairs[key])})
+		}
+		obj.set("pairs", pairs)
+	}
+	return obj
+}
+
+func encodeExpression(e Expression) interface{} {
+	if e == nil {
+		return nil
+	}
+	return encodeNode(e)
+}
+
+// encodeBlock and encodeIdentifier turn nil pointers into null instead of
+// into a non-nil Node holding a nil pointer.
+func encodeBlock(b *BlockStatement) interface{} {
+	if b == nil {
+		return nil
+	}
+	return encodeNode(b)
+}
+
+func encodeIdentifier(ident *Identifier) interface{} {
+	if ident == nil {
+		return nil
+	}
+	return encodeNode(ident)
+}
+
+func encodeStatements(list []Statement) []interface{} {
+	result := []interface{}{}
+	for _, s := range list {
+		result = append(result, encodeNode(s))
+	}
+	return result
+}
+
+func encodeExpressions(list []Expression) []interface{} {
+	result := []interface{}{}
+	for _, e := range list {
+		result = append(result, encodeExpression(e))
+	}
+	return result
+}
+
+func encodeIdentifiers(list []*Identifier) []interface{} {
+	result := []interface{}{}
+	for _, ident := range list {
+		result = append(result, encodeIdentifier(ident))
+	}
+	return result
+}
+
+type jsonDecoder struct {
+	// filename is set on all decoded positions
+	filename string
+}
+
+type rawObject map[string]json.RawMessage
+
+// get decodes the member key into v and leaves v alone if it is missing.
+func (o rawObject) get(key string, v interface{}) error {
+	raw, ok := o[key]
+	if !ok {
+		return nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("ast: invalid %q: %w", key, err)
+	}
+	return nil
+}
+
+func isNull(data []byte) bool {
+	return len(bytes.TrimSpace(data)) == 0 || string(bytes.TrimSpace(data)) == "null"
+}
+
+func (d *jsonDecoder) position(p jsonPosition) token.Position {
+	if p.Line == 0 {
+		return token.Position{}
+	}
+	return token.Position{Filename: d.filename, Offset: p.Offset, Line: p.Line, Column: p.Column}
+}
+
+// opening returns the token literal starting at start, closing the one
+// ending at end. Both tokens are on a single line.
+func opening(tt token.TokenType, literal string, start token.Position) token.Token {
+	end := start
+	if start.IsValid() {
+		end.Offset += len(literal)
+		end.Column += len(literal)
+	}
+	return token.Token{Type: tt, Literal: literal, Pos: start, End: end}
+}
+
+func closing(tt token.TokenType, literal string, end token.Position) token.Token {
+	start := end
+	if end.IsValid() {
+		start.Offset -= len(literal)
+		start.Column -= len(literal)
+	}
+	return token.Token{Type: tt, Literal: literal, Pos: start, End: end}
+}
+
+func (d *jsonDecoder) node(data []byte) (Node, error) {
+	if isNull(data) {
+		return nil, nil
+	}
+	var obj rawObject
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, fmt.Errorf("ast: %w", err)
+	}
+	var kind string
+	if err := obj.get("kind", &kind); err != nil {
+		return nil, err
+	}
+	if kind == "Program" {
+		if err := obj.get("filename", &d.filename); err != nil {
+			return nil, err
+		}
+	}
+	var span jsonSpan
+	if err := obj.get("span", &span); err != nil {
+		return nil, err
+	}
+	start, end := d.position(span.Start), d.position(span.End)
+
+	var err error
+	errs := func(e error) {
+		if err == nil {
+			err = e
+		}
+	}
+
+	var node Node
+	switch kind {
+	case "Program":
+		n := &Program{}
+		n.Statements, err = d.statements(obj["statements"])
+		var comments []jsonComment
+		errs(obj.get("comments", &comments))
+		for _, c := range comments {
+			n.Comments = append(n.Comments, token.Comment{
+				Text: c.Text,
+				Pos:  d.position(c.Span.Start),
+				End:  d.position(c.Span.End),
+			})
+		}
+		node = n
+	case "BlockStatement":
+		n := &BlockStatement{Token: opening(token.LBRACE, "{", start), Rbrace: closing(token.RBRACE, "}", end)}
+		n.Statements, err = d.statements(obj["statements"])
+		node = n
+	case "LetStatement":
+		n := &LetStatement{Token: opening(token.LET, "let", start)}
+		n.Name, err = d.identifier(obj["name"])
+		n.Value, err = d.expression(obj["value"], err)
+		node = n
+	case "ReturnStatement":
+		n := &ReturnStatement{Token: opening(token.RETURN, "return", start)}
+		n.ReturnValue, err = d.expression(obj["value"], nil)
+		node = n
+	case "ThrowStatement":
+		n := &ThrowStatement{Token: opening(token.THROW, "throw", start)}
+		n.Value, err = d.expression(obj["value"], nil)
+		node = n
+	case "TryStatement":
+		n := &TryStatement{Token: opening(token.TRY, "try", start)}
+		n.Body, err = d.block(obj["body"], nil)
+		if err == nil {
+			n.Param, err = d.identifier(obj["param"])
+		}
+		n.Catch, err = d.block(obj["catch"], err)
+		n.Finally, err = d.block(obj["finally"], err)
+		node = n
+	case "ExpressionStatement":
+		n := &ExpressionStatement{Token: token.Token{Pos: start}}
+		n.Expression, err = d.expression(obj["expression"], nil)
+		if n.Expression != nil {
+			n.Token.Literal = n.Expression.TokenLiteral()
+		}
+		node = n
+	case "AssignStatement":
+		n := &AssignStatement{}
+		errs(obj.get("operator", &n.Operator))
+		n.Token = token.Token{Type: token.TokenType(n.Operator), Literal: n.Operator}
+		n.Target, err = d.expression(obj["target"], err)
+		n.Value, err = d.expression(obj["value"], err)
+		node = n
+	case "WhileStatement":
+		n := &WhileStatement{Token: opening(token.WHILE, "while", start)}
+		n.Condition, err = d.expression(obj["condition"], nil)
+		n.Body, err = d.block(obj["body"], err)
+		node = n
+	case "ForStatement":
+		n := &ForStatement{Token: opening(token.FOR, "for", start)}
+		n.Key, err = d.identifier(obj["key"])
+		if err == nil {
+			n.Value, err = d.identifier(obj["value"])
+		}
+		n.Iterable, err = d.expression(obj["iterable"], err)
+		n.Body, err = d.block(obj["body"], err)
+		node = n
+	case "BreakStatement":
+		node = &BreakStatement{Token: opening(token.BREAK, "break", start)}
+	case "ContinueStatement":
+		node = &ContinueStatement{Token: opening(token.CONTINUE, "continue", start)}
+	case "Identifier":
+		n := &Identifier{}
+		errs(obj.get("value", &n.Value))
+		n.Token = token.Token{Type: token.IDENT, Literal: n.Value, Pos: start, End: end}
+		node = n
+	case "IntegerLiteral":
+		n := &IntegerLiteral{Token: token.Token{Type: token.INT, Pos: start, End: end}}
+		errs(obj.get("literal", &n.Token.Literal))
+		if e := obj.get("value", &n.Value); e != nil {
+			// values beyond int64 go into Big
+			n.Big = new(big.Int)
+			if obj.get("value", n.Big) != nil || n.Big.IsInt64() {
+				n.Big = nil
+				errs(e)
+			}
+		}
+		node = n
+	case "FloatLiteral":
+		n := &FloatLiteral{Token: token.Token{Type: token.FLOAT, Pos: start, End: end}}
+		errs(obj.get("literal", &n.Token.Literal))
+		errs(obj.get("value", &n.Value))
+		node = n
+	case "StringLiteral":
+		n := &StringLiteral{}
+		var raw bool
+		errs(obj.get("value", &n.Value))
+		errs(obj.get("raw", &raw))
+		n.Token = token.Token{Type: token.STRING, Literal: n.Value, Pos: start, End: end}
+		if raw {
+			n.Token.Type = token.RAW_STRING
+		}
+		node = n
+	case "Boolean":
+		n := &Boolean{}
+		errs(obj.get("value", &n.Value))
+		n.Token = token.Token{Type: token.FALSE, Literal: "false", Pos: start, End: end}
+		if n.Value {
+			n.Token.Type, n.Token.Literal = token.TRUE, "true"
+		}
+		node = n
+	case "PrefixExpression":
+		n := &PrefixExpression{}
+		errs(obj.get("operator", &n.Operator))
+		n.Token = opening(token.TokenType(n.Operator), n.Operator, start)
+		n.Right, err = d.expression(obj["right"], err)
+		node = n
+	case "InfixExpression":
+		n := &InfixExpression{}
+		errs(obj.get("operator", &n.Operator))
+		n.Token = token.Token{Type: token.TokenType(n.Operator), Literal: n.Operator}
+		n.Left, err = d.expression(obj["left"], err)
+		n.Right, err = d.expression(obj["right"], err)
+		node = n
+	case "IfExpression":
+		n := &IfExpression{Token: opening(token.IF, "if", start)}
+		n.Condition, err = d.expression(obj["condition"], nil)
+		n.Consequence, err = d.block(obj["consequence"], err)
+		n.Alternative, err = d.block(obj["alternative"], err)
+		node = n
+	case "FunctionLiteral":
+		n := &FunctionLiteral{Token: opening(token.FUNCTION, "fn", start)}
+		errs(obj.get("name", &n.Name))
+		if err == nil {
+			n.Parameters, err = d.identifiers(obj["parameters"])
+		}
+		n.Body, err = d.block(obj["body"], err)
+		node = n
+	case "MacroLiteral":
+		n := &MacroLiteral{Token: opening(token.MACRO, "macro", start)}
+		n.Parameters, err = d.identifiers(obj["parameters"])
+		n.Body, err = d.block(obj["body"], err)
+		node = n
+	case "CallExpression":
+		n := &CallExpression{Token: token.Token{Type: token.LPAREN, Literal: "("}, Rparen: closing(token.RPAREN, ")", end)}
+		n.Function, err = d.expression(obj["function"], nil)
+		if err == nil {
+			n.Arguments, err = d.expressions(obj["arguments"])
+		}
+		node = n
+	case "ArrayLiteral":
+		n := &ArrayLiteral{Token: opening(token.LBRACKET, "[", start), Rbracket: closing(token.RBRACKET, "]", end)}
+		n.Elements, err = d.expressions(obj["elements"])
+		node = n
+	case "IndexExpression":
+		n := &IndexExpression{Token: token.Token{Type: token.LBRACKET, Literal: "["}, Rbracket: closing(token.RBRACKET, "]", end)}
+		n.Left, err = d.expression(obj["left"], nil)
+		n.Index, err = d.expression(obj["index"], err)
+		node = n
+	case "HashLiteral":
+		n := &HashLiteral{Token: opening(token.LBRACE, "{", start), Rbrace: closing(token.RBRACE, "}", end)}
+		n.Pairs = map[Expression]Expression{}
+		var pairs []struct {
+			Key   json.RawMessage `json:"key"`
+			Value json.RawMessage `json:"value"`
+		}
+		errs(obj.get("pairs", &pairs))
+		for _, pair := range pairs {
+			var key, value Expression
+			key, err = d.expression(pair.Key, err)
+			value, err = d.expression(pair.Value, err)
+			if key == nil {
+				errs(fmt.Errorf("ast: hash literal key must not be null"))
+			}
+			if err != nil {
+				break
+			}
+			n.Pairs[key] = value
+		}
+		node = n
+	default:
+		return nil, fmt.Errorf("ast: unknown node kind %q", kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// expression decodes data into an Expression unless err is already set, so
+// that a sequence of decodes stops at the first error.
+func (d *jsonDecoder) expression(data json.RawMessage, err error) (Expression, error) {
+	if err != nil {
+		return nil, err
+	}
+	node, err := d.node(data)
+	if err != nil || node == nil {
+		return nil, err
+	}
+	e, ok := node.(Expression)
+	if !ok {
+		return nil, fmt.Errorf("ast: expected an expression, got %s", nodeKind(node))
+	}
+	return e, nil
+}
+
+func (d *jsonDecoder) statement(data json.RawMessage) (Statement, error) {
+	node, err := d.node(data)
+	if err != nil || node == nil {
+		return nil, err
+	}
+	s, ok := node.(Statement)
+	if !ok {
+		return nil, fmt.Errorf("ast: expected a statement, got %s", nodeKind(node))
+	}
+	return s, nil
+}
+
+func (d *jsonDecoder) block(data json.RawMessage, err error) (*BlockStatement, error) {
+	if err != nil {
+		return nil, err
+	}
+	node, err := d.node(data)
+	if err != nil || node == nil {
+		return nil, err
+	}
+	b, ok := node.(*BlockStatement)
+	if !ok {
+		return nil, fmt.Errorf("ast: expected BlockStatement, got %s", nodeKind(node))
+	}
+	return b, nil
+}
+
+func (d *jsonDecoder) identifier(data json.RawMessage) (*Identifier, error) {
+	node, err := d.node(data)
+	if err != nil || node == nil {
+		return nil, err
+	}
+	ident, ok := node.(*Identifier)
+	if !ok {
+		return nil, fmt.Errorf("ast: expected Identifier, got %s", nodeKind(node))
+	}
+	return ident, nil
+}
+
+func decodeList(data json.RawMessage) ([]json.RawMessage, error) {
+	if isNull(data) {
+		return nil, nil
+	}
+	var list []json.RawMessage
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("ast: %w", err)
+	}
+	return list, nil
+}
+
+func (d *jsonDecoder) statements(data json.RawMessage) ([]Statement, error) {
+	list, err := decodeList(data)
+	if err != nil {
+		return nil, err
+	}
+	result := []Statement{}
+	for _, raw := range list {
+		s, err := d.statement(raw)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, s)
+	}
+	return result, nil
+}
+
+func (d *jsonDecoder) expressions(data json.RawMessage) ([]Expression, error) {
+	list, err := decodeList(data)
+	if err != nil {
+		return nil, err
+	}
+	result := []Expression{}
+	for _, raw := range list {
+		e, err := d.expression(raw, nil)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, e)
+	}
+	return result, nil
+}
+
+func (d *jsonDecoder) identifiers(data json.RawMessage) ([]*Identifier, error) {
+	list, err := decodeList(data)
+	if err != nil {
+		return nil, err
+	}
+	result := []*Identifier{}
+	for _, raw := range list {
+		ident, err := d.identifier(raw)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, ident)
+	}
+	return result, nil
+}
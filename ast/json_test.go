@@ -0,0 +1,186 @@
+package ast_test
+
+import (
+	"encoding/json"
+	"interpreter/ast"
+	"interpreter/lexer"
+	"interpreter/parser"
+	"interpreter/token"
+	"strings"
+	"testing"
+)
+
+const jsonInput = walkInput + `// a comment
+let s = "a\"b" + ` + "`raw`" + `;
+let f = fn() { return 0; 1 };
+-2 ** 3;
+try { throw f(); } catch (e) { e } finally { s }
+`
+
+func parseFile(t *testing.T, filename, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.NewWithFilename(filename, input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	return program
+}
+
+func spans(node ast.Node) []string {
+	var result []string
+	ast.Inspect(node, func(n ast.Node) bool {
+		if n != nil {
+			result = append(result, n.Pos().String()+"-"+n.End().String()+" "+n.String())
+		}
+		return true
+	})
+	return result
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	program := parseFile(t, "test.mk", jsonInput)
+
+	data, err := ast.ToJSON(program)
+	if err != nil {
+		t.Fatalf("ToJSON failed: %s", err)
+	}
+	node, err := ast.FromJSON(data)
+	if err != nil {
+		t.Fatalf("FromJSON failed: %s", err)
+	}
+	decoded, ok := node.(*ast.Program)
+	if !ok {
+		t.Fatalf("node is not *ast.Program. got=%T", node)
+	}
+
+	if decoded.String() != program.String() {
+		t.Errorf("String() differs.\nwant=%q\ngot =%q", program.String(), decoded.String())
+	}
+	want, got := spans(program), spans(decoded)
+	if len(want) != len(got) {
+		t.Fatalf("wrong number of nodes. want=%d, got=%d", len(want), len(got))
+	}
+	for i := range want {
+		if want[i] != got[i] {
+			t.Errorf("node %d differs.\nwant=%q\ngot =%q", i, want[i], got[i])
+		}
+	}
+	if len(decoded.Comments) != 1 || decoded.Comments[0] != program.Comments[0] {
+		t.Errorf("wrong comments. want=%v, got=%v", program.Comments, decoded.Comments)
+	}
+
+	again, err := ast.ToJSON(decoded)
+	if err != nil {
+		t.Fatalf("ToJSON failed: %s", err)
+	}
+	if string(again) != string(data) {
+		t.Errorf("encoding is not stable.\nfirst =%s\nsecond=%s", data, again)
+	}
+}
+
+func TestJSONEncoding(t *testing.T) {
+	program := parseFile(t, "", "x += -1;")
+	data, err := json.Marshal(program)
+	if err != nil {
+		t.Fatalf("json.Marshal failed: %s", err)
+	}
+	expected := `{"kind":"Program","span":{"start":{"offset":0,"line":1,"column":1},"end":{"offset":7,"line":1,"column":8}},` +
+		`"statements":[{"kind":"AssignStatement","span":{"start":{"offset":0,"line":1,"column":1},"end":{"offset":7,"line":1,"column":8}},` +
+		`"target":{"kind":"Identifier","span":{"start":{"offset":0,"line":1,"column":1},"end":{"offset":1,"line":1,"column":2}},"value":"x"},` +
+		`"operator":"+=",` +
+		`"value":{"kind":"PrefixExpression","span":{"start":{"offset":5,"line":1,"column":6},"end":{"offset":7,"line":1,"column":8}},"operator":"-",` +
+		`"right":{"kind":"IntegerLiteral","span":{"start":{"offset":6,"line":1,"column":7},"end":{"offset":7,"line":1,"column":8}},"literal":"1","value":1}}}],` +
+		`"comments":[]}`
+	if string(data) != expected {
+		t.Errorf("wrong encoding.\nwant=%s\ngot =%s", expected, data)
+	}
+
+	var decoded ast.Program
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("json.Unmarshal failed: %s", err)
+	}
+	if decoded.String() != program.String() {
+		t.Errorf("wrong program. want=%q, got=%q", program.String(), decoded.String())
+	}
+}
+
+func TestJSONBigIntegerLiteral(t *testing.T) {
+	program := parseFile(t, "", "18446744073709551616;")
+	data, err := json.Marshal(program)
+	if err != nil {
+		t.Fatalf("json.Marshal failed: %s", err)
+	}
+	if !strings.Contains(string(data), `"literal":"18446744073709551616","value":18446744073709551616}`) {
+		t.Errorf("wrong encoding. got=%s", data)
+	}
+
+	var decoded ast.Program
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("json.Unmarshal failed: %s", err)
+	}
+	lit := decoded.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.IntegerLiteral)
+	if lit.Big == nil || lit.Big.String() != "18446744073709551616" {
+		t.Errorf("wrong value. got=%d, big=%s", lit.Value, lit.Big)
+	}
+}
+
+func TestJSONWithoutPositions(t *testing.T) {
+	// nodes built by macros have no positions and are encoded without span
+	node := &ast.InfixExpression{
+		Token:    token.Token{Type: token.PLUS, Literal: "+"},
+		Operator: "+",
+		Left:     &ast.IntegerLiteral{Token: token.Token{Type: token.INT, Literal: "1"}, Value: 1},
+		Right:    &ast.Identifier{Token: token.Token{Type: token.IDENT, Literal: "x"}, Value: "x"},
+	}
+	data, err := ast.ToJSON(node)
+	if err != nil {
+		t.Fatalf("ToJSON failed: %s", err)
+	}
+	expected := `{"kind":"InfixExpression","operator":"+",` +
+		`"left":{"kind":"IntegerLiteral","literal":"1","value":1},` +
+		`"right":{"kind":"Identifier","value":"x"}}`
+	if string(data) != expected {
+		t.Errorf("wrong encoding.\nwant=%s\ngot =%s", expected, data)
+	}
+	decoded, err := ast.FromJSON(data)
+	if err != nil {
+		t.Fatalf("FromJSON failed: %s", err)
+	}
+	if decoded.String() != node.String() || decoded.Pos().IsValid() {
+		t.Errorf("wrong node. got=%q at %s", decoded.String(), decoded.Pos())
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"kind":"Nonsense"}`, `ast: unknown node kind "Nonsense"`},
+		{`{"statements":[]}`, `ast: unknown node kind ""`},
+		{`{"kind":"ExpressionStatement","expression":{"kind":"BreakStatement"}}`, "ast: expected an expression, got BreakStatement"},
+		{`{"kind":"LetStatement","name":{"kind":"Boolean","value":true}}`, "ast: expected Identifier, got Boolean"},
+		{`{"kind":"Program","statements":[{"kind":"Identifier","value":"x"}]}`, "ast: expected a statement, got Identifier"},
+		{`{"kind":"IntegerLiteral","value":"1"}`, `ast: invalid "value": json: cannot unmarshal string into Go value of type int64`},
+		{`{"kind":"IntegerLiteral","value":1.5}`, `ast: invalid "value": json: cannot unmarshal number 1.5 into Go value of type int64`},
+		{`[1]`, "ast: json: cannot unmarshal array into Go value of type ast.rawObject"},
+	}
+
+	for _, tt := range tests {
+		_, err := ast.FromJSON([]byte(tt.input))
+		if err == nil {
+			t.Errorf("input %s: expected an error", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("input %s: wrong error.\nwant=%q\ngot =%q", tt.input, tt.expected, err.Error())
+		}
+	}
+
+	var program ast.Program
+	err := json.Unmarshal([]byte(`{"kind":"Identifier","value":"x"}`), &program)
+	if err == nil || err.Error() != "ast: expected Program, got Identifier" {
+		t.Errorf("wrong error: %v", err)
+	}
+}
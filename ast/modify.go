@@ -0,0 +1,9 @@
+package ast
+
+type ModifierFunc func(Node) Node
+
+// Modify is Rewrite under the name used by the macro system: it returns a
+// copy of node in which every node has been replaced by modifier(node).
+func Modify(node Node, modifier ModifierFunc) Node {
+	return Rewrite(node, modifier)
+}
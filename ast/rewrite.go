@@ -0,0 +1,205 @@
+package ast
+
+import "fmt"
+
+// Rewrite traverses an AST in depth-first order and replaces every node by
+// f applied to a copy of the node whose children have already been
+// rewritten. The original tree is left untouched. nil children are skipped.
+//
+// f may return nil or any node that is valid in the position of the node it
+// replaces, e.g. an Expression for an Expression. Rewrite panics if f returns
+// a node of the wrong kind.
+func Rewrite(node Node, f func(Node) Node) Node {
+	switch node := node.(type) {
+	case *Program:
+		n := *node
+		n.Statements = rewriteStatements(node.Statements, f)
+		return f(&n)
+	case *BlockStatement:
+		n := *node
+		n.Statements = rewriteStatements(node.Statements, f)
+		return f(&n)
+	case *LetStatement:
+		n := *node
+		n.Name = rewriteIdentifier(node.Name, f)
+		n.Value = rewriteExpression(node.Value, f)
+		return f(&n)
+	case *ReturnStatement:
+		n := *node
+		n.ReturnValue = rewriteExpression(node.ReturnValue, f)
+		return f(&n)
+	case *ThrowStatement:
+		n := *node
+		n.Value = rewriteExpression(node.Value, f)
+		return f(&n)
+	case *TryStatement:
+		n := *node
+		n.Body = rewriteBlock(node.Body, f)
+		n.Param = rewriteIdentifier(node.Param, f)
+		n.Catch = rewriteBlock(node.Catch, f)
+		n.Finally = rewriteBlock(node.Finally, f)
+		return f(&n)
+	case *ExpressionStatement:
+		n := *node
+		n.Expression = rewriteExpression(node.Expression, f)
+		return f(&n)
+	case *AssignStatement:
+		n := *node
+		n.Target = rewriteExpression(node.Target, f)
+		n.Value = rewriteExpression(node.Value, f)
+		return f(&n)
+	case *WhileStatement:
+		n := *node
+		n.Condition = rewriteExpression(node.Condition, f)
+		n.Body = rewriteBlock(node.Body, f)
+		return f(&n)
+	case *ForStatement:
+		n := *node
+		n.Key = rewriteIdentifier(node.Key, f)
+		n.Value = rewriteIdentifier(node.Value, f)
+		n.Iterable = rewriteExpression(node.Iterable, f)
+		n.Body = rewriteBlock(node.Body, f)
+		return f(&n)
+	case *BreakStatement, *ContinueStatement:
+		return f(node)
+	case *Identifier, *IntegerLiteral, *FloatLiteral, *StringLiteral, *Boolean:
+		return f(node)
+	case *PrefixExpression:
+		n := *node
+		n.Right = rewriteExpression(node.Right, f)
+		return f(&n)
+	case *InfixExpression:
+		n := *node
+		n.Left = rewriteExpression(node.Left, f)
+		n.Right = rewriteExpression(node.Right, f)
+		return f(&n)
+	case *IfExpression:
+		n := *node
+		n.Condition = rewriteExpression(node.Condition, f)
+		n.Consequence = rewriteBlock(node.Consequence, f)
+		n.Alternative = rewriteBlock(node.Alternative, f)
+		return f(&n)
+	case *FunctionLiteral:
+		n := *node
+		n.Parameters = rewriteIdentifiers(node.Parameters, f)
+		n.Body = rewriteBlock(node.Body, f)
+		return f(&n)
+	case *MacroLiteral:
+		n := *node
+		n.Parameters = rewriteIdentifiers(node.Parameters, f)
+		n.Body = rewriteBlock(node.Body, f)
+		return f(&n)
+	case *CallExpression:
+		n := *node
+		n.Function = rewriteExpression(node.Function, f)
+		n.Arguments = rewriteExpressions(node.Arguments, f)
+		return f(&n)
+	case *ArrayLiteral:
+		n := *node
+		n.Elements = rewriteExpressions(node.Elements, f)
+		return f(&n)
+	case *IndexExpression:
+		n := *node
+		n.Left = rewriteExpression(node.Left, f)
+		n.Index = rewriteExpression(node.Index, f)
+		return f(&n)
+	case *HashLiteral:
+		n := *node
+		n.Pairs = make(map[Expression]Expression, len(node.Pairs))
+		for _, key := range node.Keys() {
+			n.Pairs[rewriteExpression(key, f)] = rewriteExpression(node.Pairs[key], f)
+		}
+		return f(&n)
+	default:
+		panic(fmt.Sprintf("ast.Rewrite: unexpected node type %T", node))
+	}
+}
+
+func rewriteStatement(s Statement, f func(Node) Node) Statement {
+	if s == nil {
+		return nil
+	}
+	switch r := Rewrite(s, f).(type) {
+	case nil:
+		return nil
+	case Statement:
+		return r
+	default:
+		panic(fmt.Sprintf("ast.Rewrite: cannot replace statement %T with %T", s, r))
+	}
+}
+
+func rewriteExpression(e Expression, f func(Node) Node) Expression {
+	if e == nil {
+		return nil
+	}
+	switch r := Rewrite(e, f).(type) {
+	case nil:
+		return nil
+	case Expression:
+		return r
+	default:
+		panic(fmt.Sprintf("ast.Rewrite: cannot replace expression %T with %T", e, r))
+	}
+}
+
+func rewriteBlock(b *BlockStatement, f func(Node) Node) *BlockStatement {
+	if b == nil {
+		return nil
+	}
+	switch r := Rewrite(b, f).(type) {
+	case nil:
+		return nil
+	case *BlockStatement:
+		return r
+	default:
+		panic(fmt.Sprintf("ast.Rewrite: cannot replace block statement with %T", r))
+	}
+}
+
+func rewriteIdentifier(ident *Identifier, f func(Node) Node) *Identifier {
+	if ident == nil {
+		return nil
+	}
+	switch r := Rewrite(ident, f).(type) {
+	case nil:
+		return nil
+	case *Identifier:
+		return r
+	default:
+		panic(fmt.Sprintf("ast.Rewrite: cannot replace identifier with %T", r))
+	}
+}
+
+func rewriteStatements(list []Statement, f func(Node) Node) []Statement {
+	if list == nil {
+		return nil
+	}
+	result := make([]Statement, len(list))
+	for i, s := range list {
+		result[i] = rewriteStatement(s, f)
+	}
+	return result
+}
+
+func rewriteExpressions(list []Expression, f func(Node) Node) []Expression {
+	if list == nil {
+		return nil
+	}
+	result := make([]Expression, len(list))
+	for i, e := range list {
+		result[i] = rewriteExpression(e, f)
+	}
+	return result
+}
+
+func rewriteIdentifiers(list []*Identifier, f func(Node) Node) []*Identifier {
+	if list == nil {
+		return nil
+	}
+	result := make([]*Identifier, len(list))
+	for i, ident := range list {
+		result[i] = rewriteIdentifier(ident, f)
+	}
+	return result
+}
@@ -0,0 +1,207 @@
+package ast_test
+
+import (
+	"fmt"
+	"interpreter/ast"
+	"interpreter/lexer"
+	"interpreter/parser"
+	"interpreter/token"
+	"strings"
+	"testing"
+)
+
+const walkInput = `let add = fn(a, b) { return a + b; };
+let m = macro(x) { x };
+let h = {"b": 2, "a": -1};
+x += h["a"];
+while (x < 10) { break; }
+for (k, v in [1.5, true]) { continue; }
+if (add(1, 2)) { 1 } else { 2 };
+`
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	return program
+}
+
+func nodeName(n ast.Node) string {
+	return strings.TrimPrefix(fmt.Sprintf("%T", n), "*ast.")
+}
+
+func TestInspect(t *testing.T) {
+	program := parse(t, walkInput)
+
+	var visited []string
+	ast.Inspect(program, func(n ast.Node) bool {
+		if n != nil {
+			visited = append(visited, nodeName(n))
+		}
+		return true
+	})
+
+	expected := []string{
+		"Program",
+		"LetStatement", "Identifier", "FunctionLiteral", "Identifier", "Identifier",
+		"BlockStatement", "ReturnStatement", "InfixExpression", "Identifier", "Identifier",
+		"LetStatement", "Identifier", "MacroLiteral", "Identifier",
+		"BlockStatement", "ExpressionStatement", "Identifier",
+		"LetStatement", "Identifier", "HashLiteral",
+		"StringLiteral", "IntegerLiteral", "StringLiteral", "PrefixExpression", "IntegerLiteral",
+		"AssignStatement", "Identifier", "IndexExpression", "Identifier", "StringLiteral",
+		"WhileStatement", "InfixExpression", "Identifier", "IntegerLiteral",
+		"BlockStatement", "BreakStatement",
+		"ForStatement", "Identifier", "Identifier", "ArrayLiteral", "FloatLiteral", "Boolean",
+		"BlockStatement", "ContinueStatement",
+		"ExpressionStatement", "IfExpression", "CallExpression", "Identifier",
+		"IntegerLiteral", "IntegerLiteral",
+		"BlockStatement", "ExpressionStatement", "IntegerLiteral",
+		"BlockStatement", "ExpressionStatement", "IntegerLiteral",
+	}
+
+	if strings.Join(visited, " ") != strings.Join(expected, " ") {
+		t.Errorf("wrong traversal.\nwant=%v\ngot =%v", expected, visited)
+	}
+}
+
+func TestInspectPrune(t *testing.T) {
+	program := parse(t, `let f = fn(x) { x + 1 }; f(2) + 3`)
+
+	var integers []string
+	ast.Inspect(program, func(n ast.Node) bool {
+		if _, ok := n.(*ast.FunctionLiteral); ok {
+			return false
+		}
+		if lit, ok := n.(*ast.IntegerLiteral); ok {
+			integers = append(integers, lit.String())
+		}
+		return true
+	})
+
+	if strings.Join(integers, ",") != "2,3" {
+		t.Errorf("wrong integers. want=%q, got=%q", "2,3", strings.Join(integers, ","))
+	}
+}
+
+type depthVisitor struct {
+	depth    *int
+	maxDepth *int
+}
+
+func (v depthVisitor) Visit(n ast.Node) ast.Visitor {
+	if n == nil {
+		*v.depth--
+		return nil
+	}
+	*v.depth++
+	if *v.depth > *v.maxDepth {
+		*v.maxDepth = *v.depth
+	}
+	return v
+}
+
+func TestWalk(t *testing.T) {
+	program := parse(t, `[1, [2, [3]]]`)
+
+	depth, maxDepth := 0, 0
+	ast.Walk(depthVisitor{&depth, &maxDepth}, program)
+
+	// Program, ExpressionStatement, 3 ArrayLiterals, IntegerLiteral
+	if maxDepth != 6 {
+		t.Errorf("wrong max depth. want=%d, got=%d", 6, maxDepth)
+	}
+	// every Visit(node) is matched by a Visit(nil) after the children
+	if depth != 0 {
+		t.Errorf("wrong depth after walk. want=%d, got=%d", 0, depth)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`x + y`, `(X + Y)`},
+		{`let x = fn(y) { y };`, `let X = fn<x>(Y) Y;`},
+		{`for (k, v in x) { k += v; }`, `for(K, V in X) K += V;`},
+		{`{x: y}`, `{X: Y, }`},
+		{`m(x)[y]`, `(M(X)[Y])`},
+		{`while (x) { y = !x; }`, `whileX Y = (!X);`},
+	}
+
+	upcase := func(n ast.Node) ast.Node {
+		ident, ok := n.(*ast.Identifier)
+		if !ok {
+			return n
+		}
+		value := strings.ToUpper(ident.Value)
+		return &ast.Identifier{Token: token.Token{Type: token.IDENT, Literal: value}, Value: value}
+	}
+
+	for _, tt := range tests {
+		program := parse(t, tt.input)
+		original := program.String()
+
+		rewritten := ast.Rewrite(program, upcase)
+
+		if rewritten.String() != tt.expected {
+			t.Errorf("wrong result. want=%q, got=%q", tt.expected, rewritten.String())
+		}
+		if program.String() != original {
+			t.Errorf("input was changed. want=%q, got=%q", original, program.String())
+		}
+	}
+}
+
+func TestRewriteReplacesWholeSubtrees(t *testing.T) {
+	program := parse(t, `1 + 2 * 3`)
+
+	fold := func(n ast.Node) ast.Node {
+		infix, ok := n.(*ast.InfixExpression)
+		if !ok {
+			return n
+		}
+		left, ok1 := infix.Left.(*ast.IntegerLiteral)
+		right, ok2 := infix.Right.(*ast.IntegerLiteral)
+		if !ok1 || !ok2 {
+			return n
+		}
+		var value int64
+		switch infix.Operator {
+		case "+":
+			value = left.Value + right.Value
+		case "*":
+			value = left.Value * right.Value
+		default:
+			return n
+		}
+		lit := fmt.Sprintf("%d", value)
+		return &ast.IntegerLiteral{Token: token.Token{Type: token.INT, Literal: lit}, Value: value}
+	}
+
+	rewritten := ast.Rewrite(program, fold)
+	if rewritten.String() != "7" {
+		t.Errorf("wrong result. want=%q, got=%q", "7", rewritten.String())
+	}
+}
+
+func TestRewriteWrongNodeKind(t *testing.T) {
+	program := parse(t, `let x = 1;`)
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("expected Rewrite to panic")
+		}
+	}()
+
+	ast.Rewrite(program, func(n ast.Node) ast.Node {
+		if _, ok := n.(*ast.IntegerLiteral); ok {
+			return &ast.BreakStatement{}
+		}
+		return n
+	})
+}
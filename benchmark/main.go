@@ -13,7 +13,15 @@ import (
 )
 
 var engine = flag.String("engine", "vm", "use 'vm' or 'eval'")
-var input = `
+var optimize = flag.Bool("optimize", true, "optimize the bytecode")
+var programName = flag.String("program", "fibonacci", "run 'fibonacci' or 'loop'")
+
+var programs = map[string]string{
+	"fibonacci": fibonacci,
+	"loop":      loop,
+}
+
+var fibonacci = `
 let fibonacci = fn(x) {
 if (x == 0) {
 0
@@ -28,15 +36,38 @@ fibonacci(x - 1) + fibonacci(x - 2);
 fibonacci(35);
 `
 
+var loop = `
+let sum = fn(n) {
+let i = 0;
+let total = 0;
+while (true) {
+if (!(i < n)) {
+break;
+}
+let square = i * i;
+total = total + square;
+i += 1;
+}
+total
+};
+sum(2000000);
+`
+
 func main() {
 	flag.Parse()
 	var duration time.Duration
 	var result object.Object
+	input, ok := programs[*programName]
+	if !ok {
+		fmt.Printf("unknown program: %s\n", *programName)
+		return
+	}
 	l := lexer.New(input)
 	p := parser.New(l)
 	program := p.ParseProgram()
 	if *engine == "vm" {
 		comp := compiler.New()
+		comp.SetOptimizations(*optimize)
 		err := comp.Compile(program)
 		if err != nil {
 			fmt.Printf("compiler error: %s", err)
@@ -58,8 +89,9 @@ func main() {
 		duration = time.Since(start)
 	}
 	fmt.Printf(
-		"engine=%s, result=%s, duration=%s\n",
+		"engine=%s, optimize=%t, result=%s, duration=%s\n",
 		*engine,
+		*optimize,
 		result.Inspect(),
 		duration)
 }
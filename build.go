@@ -0,0 +1,135 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"interpreter/compiler"
+	"interpreter/evaluator"
+	"interpreter/format"
+	"interpreter/lexer"
+	"interpreter/object"
+	"interpreter/parser"
+	"interpreter/vm"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// bytecodeExt is the file extension of compiled Monkey programs.
+const bytecodeExt = ".mkc"
+
+func runBuild(args []string) int {
+	flags := flag.NewFlagSet("build", flag.ContinueOnError)
+	output := flags.String("o", "", "write the bytecode to `file` instead of the source name with "+bytecodeExt)
+	noOpt := flags.Bool("N", false, "disable optimizations")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: monkey build [-o file] [-N] file%s\n", sourceExt)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	path := flags.Arg(0)
+	bytecode, err := compileFile(path, !*noOpt)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	data, err := bytecode.MarshalBinary()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "monkey build: %s\n", err)
+		return 1
+	}
+	out := *output
+	if out == "" {
+		out = strings.TrimSuffix(path, filepath.Ext(path)) + bytecodeExt
+	}
+	if err := os.WriteFile(out, data, 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "monkey build: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+func runRun(args []string) int {
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: monkey run file%s|file%s\n", bytecodeExt, sourceExt)
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	path := flags.Arg(0)
+	bytecode, err := loadFile(path, true)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	// bytecode files may be corrupt or hand-made, the VM trusts its input
+	if err := vm.Verify(bytecode); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: invalid bytecode: %s\n", path, err)
+		return 1
+	}
+	machine := vm.New(bytecode)
+	if err := machine.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+		if rerr, ok := err.(*vm.RuntimeError); ok {
+			fmt.Fprint(os.Stderr, rerr.StackTrace())
+		}
+		return 1
+	}
+	return 0
+}
+
+// compileFile parses the source file path, expands its macros and compiles
+// it.
+func compileFile(path string, optimize bool) (*compiler.Bytecode, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p := parser.New(lexer.NewWithFilename(path, string(src)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, &format.ParseError{Source: string(src), Diagnostics: p.Errors()}
+	}
+	macros := object.NewEnvironment()
+	evaluator.DefineMacros(program, macros)
+	expanded, err := evaluator.ExpandMacros(program, macros)
+	if err != nil {
+		return nil, err
+	}
+	comp := compiler.New()
+	comp.SetOptimizations(optimize)
+	if err := comp.Compile(expanded); err != nil {
+		return nil, err
+	}
+	return comp.Bytecode(), nil
+}
+
+// loadFile reads the bytecode file path. Any other file is compiled from
+// source, with optimizations if optimize is set.
+func loadFile(path string, optimize bool) (*compiler.Bytecode, error) {
+	if filepath.Ext(path) != bytecodeExt {
+		return compileFile(path, optimize)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	bytecode := &compiler.Bytecode{}
+	if err := bytecode.UnmarshalBinary(data); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return bytecode, nil
+}
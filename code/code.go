@@ -37,10 +37,54 @@ const (
 	OpClosure
 	OpGetFree
 	OpCurrentClosure
+	OpMod
+	OpPow
+	OpBitAnd
+	OpBitOr
+	OpBitXor
+	OpBitNot
+	OpShiftLeft
+	OpShiftRight
+	OpIter
+	OpIterNext
+	OpSetIndex
+	OpDup2
+	OpSetFree
+	OpCaptureLocal
+	OpCaptureFree
+	OpJumpTruthy
+	OpTeeLocal
+	OpTailCall
+	OpLessThan
+	OpLessEqual
+	OpGreaterEqual
+	OpThrow
 )
 
 type Instructions []byte
 
+// Handler catches the exceptions thrown by the instructions from Start up
+// to End of a function. Execution continues at Target with the stack cut
+// back to Height values above the locals and the exception pushed on top.
+// The handlers of a function are ordered innermost first, the first one
+// covering a failing instruction catches its exception.
+type Handler struct {
+	Start, End int
+	Target     int
+	Height     int
+}
+
+// FindHandler returns the first of handlers covering the instruction at
+// offset.
+func FindHandler(handlers []Handler, offset int) (Handler, bool) {
+	for _, h := range handlers {
+		if h.Start <= offset && offset < h.End {
+			return h, true
+		}
+	}
+	return Handler{}, false
+}
+
 type Opcode byte
 
 type Definition struct {
@@ -79,6 +123,28 @@ var definitions = map[Opcode]*Definition{
 	OpClosure:        {"OpClosure", []int{2, 2}},
 	OpGetFree:        {"OpGetFree", []int{2}},
 	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+	OpMod:            {"OpMod", []int{}},
+	OpPow:            {"OpPow", []int{}},
+	OpBitAnd:         {"OpBitAnd", []int{}},
+	OpBitOr:          {"OpBitOr", []int{}},
+	OpBitXor:         {"OpBitXor", []int{}},
+	OpBitNot:         {"OpBitNot", []int{}},
+	OpShiftLeft:      {"OpShiftLeft", []int{}},
+	OpShiftRight:     {"OpShiftRight", []int{}},
+	OpIter:           {"OpIter", []int{}},
+	OpIterNext:       {"OpIterNext", []int{2, 2}},
+	OpSetIndex:       {"OpSetIndex", []int{}},
+	OpDup2:           {"OpDup2", []int{}},
+	OpSetFree:        {"OpSetFree", []int{2}},
+	OpCaptureLocal:   {"OpCaptureLocal", []int{2}},
+	OpCaptureFree:    {"OpCaptureFree", []int{2}},
+	OpJumpTruthy:     {"OpJumpTruthy", []int{2}},
+	OpTeeLocal:       {"OpTeeLocal", []int{2}},
+	OpTailCall:       {"OpTailCall", []int{2}},
+	OpLessThan:       {"OpLessThan", []int{}},
+	OpLessEqual:      {"OpLessEqual", []int{}},
+	OpGreaterEqual:   {"OpGreaterEqual", []int{}},
+	OpThrow:          {"OpThrow", []int{}},
 }
 
 func Lookup(op byte) (*Definition, error) {
@@ -121,7 +187,9 @@ func (instructions Instructions) String() string {
 	for i < len(instructions) {
 		def, err := Lookup(instructions[i])
 		if err != nil {
-			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			fmt.Fprintf(&out, "%04d ERROR: %s\n\t", i, err)
+			i++
+			continue
 		}
 		operands, read := ReadOperands(def, instructions[i+1:])
 		out.WriteString(fmt.Sprintf("%04d ", i))
@@ -51,6 +51,17 @@ func TestInstructionsString(t *testing.T) {
 	}
 }
 
+func TestInstructionsStringUndefinedOpcode(t *testing.T) {
+	concatted := append(Instructions{255}, Make(OpConstant, 1)...)
+	expected := `0000 ERROR: opcode 255 undefined
+	0001 OpConstant 1
+	`
+	if concatted.String() != expected {
+		t.Errorf("instructions wrongly formatted.\nwant=%q\ngot=%q",
+			expected, concatted.String())
+	}
+}
+
 func TestReadOperands(t *testing.T) {
 	tests := []struct {
 		op        Opcode
@@ -77,3 +88,40 @@ func TestReadOperands(t *testing.T) {
 		}
 	}
 }
+
+func TestVerify(t *testing.T) {
+	concat := func(list ...[]byte) Instructions {
+		ins := Instructions{}
+		for _, b := range list {
+			ins = append(ins, b...)
+		}
+		return ins
+	}
+
+	tests := []struct {
+		ins      Instructions
+		expected string
+	}{
+		{concat(Make(OpTrue), Make(OpJumpNotTruthy, 6), Make(OpNull), Make(OpPop)), ""},
+		{concat(Make(OpJump, 3)), ""},
+		{Instructions{}, ""},
+		{concat(Make(OpNull), []byte{255}), "0001: opcode 255 undefined"},
+		{concat(Make(OpNull), Make(OpConstant, 1)[:2]), "0001: OpConstant is truncated"},
+		{concat(Make(OpJump, 4)), "0000: OpJump target 4 is out of range"},
+		{concat(Make(OpConstant, 0), Make(OpJump, 1)), "0003: OpJump target 1 is not the start of an instruction"},
+		{concat(Make(OpIter), Make(OpIterNext, 2, 1)), "0001: OpIterNext target 2 is not the start of an instruction"},
+	}
+
+	for _, tt := range tests {
+		err := Verify(tt.ins)
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("unexpected error for %q: %s", tt.ins, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%v", tt.expected, err)
+		}
+	}
+}
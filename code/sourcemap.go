@@ -0,0 +1,43 @@
+package code
+
+import (
+	"interpreter/token"
+	"sort"
+)
+
+// SourceMap maps instruction offsets to the source positions they were
+// compiled from. It is sorted by offset and every entry covers the
+// instructions up to the next entry. Instructions compiled from nodes
+// without a position, e.g. nodes built by macros, map to an invalid
+// position.
+type SourceMap []SourceMapEntry
+
+type SourceMapEntry struct {
+	Offset int
+	Pos    token.Position
+}
+
+// Pos returns the source position of the instruction at offset.
+func (m SourceMap) Pos(offset int) token.Position {
+	i := sort.Search(len(m), func(i int) bool { return m[i].Offset > offset })
+	if i == 0 {
+		return token.Position{}
+	}
+	return m[i-1].Pos
+}
+
+// Add records that the instructions starting at offset were compiled from
+// pos.
+func (m SourceMap) Add(offset int, pos token.Position) SourceMap {
+	m = m.Truncate(offset)
+	if len(m) == 0 && !pos.IsValid() || len(m) > 0 && m[len(m)-1].Pos == pos {
+		return m
+	}
+	return append(m, SourceMapEntry{Offset: offset, Pos: pos})
+}
+
+// Truncate drops the entries of the instructions at offset and after.
+func (m SourceMap) Truncate(offset int) SourceMap {
+	i := sort.Search(len(m), func(i int) bool { return m[i].Offset >= offset })
+	return m[:i]
+}
@@ -0,0 +1,131 @@
+package code
+
+import "fmt"
+
+// jumps holds the opcodes whose first operand is the offset of the
+// instruction to continue at.
+var jumps = map[Opcode]bool{
+	OpJump:          true,
+	OpJumpNotTruthy: true,
+	OpJumpTruthy:    true,
+	OpIterNext:      true,
+}
+
+// IsJump reports whether the first operand of op is a jump target.
+func IsJump(op Opcode) bool { return jumps[op] }
+
+// StackEffect returns the number of values the instruction op with the
+// given operands pops from the stack and the number of values it pushes when
+// execution continues with the next instruction.
+func StackEffect(op Opcode, operands []int) (pops, pushes int) {
+	switch op {
+	case OpPop, OpSetGlobal, OpSetLocal, OpSetFree:
+		return 1, 0
+	case OpConstant, OpTrue, OpFalse, OpNull, OpGetGlobal, OpGetLocal,
+		OpGetBuiltin, OpGetFree, OpCaptureLocal, OpCaptureFree, OpCurrentClosure:
+		return 0, 1
+	case OpAdd, OpSub, OpMul, OpDiv, OpMod, OpPow, OpEqual, OpNotEqual,
+		OpGreaterThan, OpLessThan, OpLessEqual, OpGreaterEqual,
+		OpBitAnd, OpBitOr, OpBitXor, OpShiftLeft, OpShiftRight, OpIndex:
+		return 2, 1
+	case OpMinus, OpBang, OpBitNot, OpIter, OpTeeLocal:
+		return 1, 1
+	case OpJumpNotTruthy, OpJumpTruthy:
+		return 1, 0
+	case OpArray, OpHash:
+		return operands[0], 1
+	case OpSetIndex:
+		return 3, 0
+	case OpDup2:
+		return 2, 4
+	case OpClosure:
+		return operands[1], 1
+	case OpCall, OpTailCall:
+		return operands[0] + 1, 1
+	case OpIterNext:
+		return 1, 1 + operands[1]
+	case OpReturnValue, OpThrow:
+		return 1, 0
+	}
+	return 0, 0
+}
+
+// VerifyError describes an invalid instruction.
+type VerifyError struct {
+	Offset int // offset of the instruction in its Instructions
+	Msg    string
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("%04d: %s", e.Offset, e.Msg)
+}
+
+// Verify checks that ins is a sequence of complete instructions with defined
+// opcodes, and that all jumps target the start of an instruction or the end
+// of ins.
+func Verify(ins Instructions) error {
+	starts, err := instructionStarts(ins)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(ins); {
+		def, _ := Lookup(ins[i])
+		operands, read := ReadOperands(def, ins[i+1:])
+		if IsJump(Opcode(ins[i])) {
+			target := operands[0]
+			if target > len(ins) {
+				return &VerifyError{i, fmt.Sprintf("%s target %d is out of range", def.Name, target)}
+			}
+			if !starts[target] {
+				return &VerifyError{i, fmt.Sprintf("%s target %d is not the start of an instruction", def.Name, target)}
+			}
+		}
+		i += 1 + read
+	}
+	return nil
+}
+
+// VerifyHandlers checks that handlers cover ranges of whole instructions of
+// ins and continue at the start of an instruction. ins must pass Verify.
+func VerifyHandlers(ins Instructions, handlers []Handler) error {
+	starts, err := instructionStarts(ins)
+	if err != nil {
+		return err
+	}
+	for i, h := range handlers {
+		switch {
+		case h.Start < 0 || h.Start > h.End || h.End > len(ins):
+			return &VerifyError{h.Start, fmt.Sprintf("handler %d range %d-%d is out of range", i, h.Start, h.End)}
+		case !starts[h.Start] || !starts[h.End]:
+			return &VerifyError{h.Start, fmt.Sprintf("handler %d range %d-%d does not cover whole instructions", i, h.Start, h.End)}
+		case h.Target < 0 || h.Target >= len(ins) || !starts[h.Target]:
+			return &VerifyError{h.Start, fmt.Sprintf("handler %d target %d is not the start of an instruction", i, h.Target)}
+		case h.Height < 0:
+			return &VerifyError{h.Start, fmt.Sprintf("handler %d has negative height %d", i, h.Height)}
+		}
+	}
+	return nil
+}
+
+// instructionStarts reports for every offset of ins, and its end, whether an
+// instruction starts there.
+func instructionStarts(ins Instructions) ([]bool, error) {
+	starts := make([]bool, len(ins)+1)
+	starts[len(ins)] = true
+	for i := 0; i < len(ins); {
+		starts[i] = true
+		def, err := Lookup(ins[i])
+		if err != nil {
+			return nil, &VerifyError{i, err.Error()}
+		}
+		width := 0
+		for _, w := range def.OperandWiths {
+			width += w
+		}
+		if i+1+width > len(ins) {
+			return nil, &VerifyError{i, fmt.Sprintf("%s is truncated", def.Name)}
+		}
+		i += 1 + width
+	}
+	return starts, nil
+}
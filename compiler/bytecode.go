@@ -0,0 +1,329 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"interpreter/code"
+	"interpreter/object"
+	"interpreter/token"
+	"math"
+	"math/big"
+)
+
+// The binary format of Bytecode, all numbers are big-endian:
+//
+//	magic        [4]byte "MKBC"
+//	version      uint16
+//	filename     uint32 length, followed by the file name of the positions
+//	instructions uint32 length, followed by the instructions
+//	source map   uint32 count, followed by the entries
+//	handlers     uint32 count, followed by the handlers
+//	constants    uint32 count, followed by the constants
+//	checksum     uint32 CRC-32 (IEEE) of everything before it
+//
+// A source map entry consists of the instruction offset and the offset,
+// line and column of its position, all uint32. All positions share the
+// file name in the header. A handler consists of its start, end, target
+// and height, all uint32.
+//
+// Every constant starts with a tag byte:
+//
+//	constInteger          int64
+//	constBigInteger       sign byte (0 or 1 for negative), uint32 length
+//	                      and the magnitude, of a value beyond int64
+//	constFloat            IEEE 754 bits as uint64
+//	constString           uint32 length and the UTF-8 bytes
+//	constCompiledFunction uint32 NumLocals, uint32 NumParameters, the
+//	                      instructions, the source map and the handlers
+//	                      like above
+const (
+	BytecodeMagic   = "MKBC"
+	BytecodeVersion = 3
+)
+
+const (
+	constInteger byte = iota + 1
+	constBigInteger
+	constFloat
+	constString
+	constCompiledFunction
+)
+
+// ErrCorruptBytecode is wrapped by the errors UnmarshalBinary returns for
+// input that was not produced by MarshalBinary.
+var ErrCorruptBytecode = errors.New("corrupt bytecode")
+
+func (b *Bytecode) MarshalBinary() ([]byte, error) {
+	var out bytes.Buffer
+	out.WriteString(BytecodeMagic)
+	writeUint16(&out, BytecodeVersion)
+	writeBytes(&out, []byte(b.Filename()))
+	writeBytes(&out, b.Instructions)
+	writeSourceMap(&out, b.SourceMap)
+	writeHandlers(&out, b.Handlers)
+	writeUint32(&out, len(b.Constants))
+	for i, c := range b.Constants {
+		if err := writeConstant(&out, c); err != nil {
+			return nil, fmt.Errorf("constant %d: %w", i, err)
+		}
+	}
+	writeUint32(&out, int(crc32.ChecksumIEEE(out.Bytes())))
+	return out.Bytes(), nil
+}
+
+// Filename returns the name of the file b was compiled from, the file name
+// of the first position in its source maps.
+func (b *Bytecode) Filename() string {
+	maps := []code.SourceMap{b.SourceMap}
+	for _, c := range b.Constants {
+		if fn, ok := c.(*object.CompiledFunction); ok {
+			maps = append(maps, fn.SourceMap)
+		}
+	}
+	for _, m := range maps {
+		for _, e := range m {
+			if e.Pos.IsValid() {
+				return e.Pos.Filename
+			}
+		}
+	}
+	return ""
+}
+
+func writeSourceMap(out *bytes.Buffer, m code.SourceMap) {
+	writeUint32(out, len(m))
+	for _, e := range m {
+		writeUint32(out, e.Offset)
+		writeUint32(out, e.Pos.Offset)
+		writeUint32(out, e.Pos.Line)
+		writeUint32(out, e.Pos.Column)
+	}
+}
+
+func writeHandlers(out *bytes.Buffer, handlers []code.Handler) {
+	writeUint32(out, len(handlers))
+	for _, h := range handlers {
+		writeUint32(out, h.Start)
+		writeUint32(out, h.End)
+		writeUint32(out, h.Target)
+		writeUint32(out, h.Height)
+	}
+}
+
+func writeConstant(out *bytes.Buffer, c object.Object) error {
+	switch c := c.(type) {
+	case *object.Integer:
+		out.WriteByte(constInteger)
+		binary.Write(out, binary.BigEndian, c.Value)
+	case *object.BigInteger:
+		out.WriteByte(constBigInteger)
+		if c.Value.Sign() < 0 {
+			out.WriteByte(1)
+		} else {
+			out.WriteByte(0)
+		}
+		writeBytes(out, c.Value.Bytes())
+	case *object.Float:
+		out.WriteByte(constFloat)
+		binary.Write(out, binary.BigEndian, math.Float64bits(c.Value))
+	case *object.String:
+		out.WriteByte(constString)
+		writeBytes(out, []byte(c.Value))
+	case *object.CompiledFunction:
+		out.WriteByte(constCompiledFunction)
+		writeUint32(out, c.NumLocals)
+		writeUint32(out, c.NumParameters)
+		writeBytes(out, c.Instructions)
+		writeSourceMap(out, c.SourceMap)
+		writeHandlers(out, c.Handlers)
+	default:
+		return fmt.Errorf("cannot encode constant of type %T", c)
+	}
+	return nil
+}
+
+func writeUint16(out *bytes.Buffer, n int) {
+	binary.Write(out, binary.BigEndian, uint16(n))
+}
+
+func writeUint32(out *bytes.Buffer, n int) {
+	binary.Write(out, binary.BigEndian, uint32(n))
+}
+
+func writeBytes(out *bytes.Buffer, b []byte) {
+	writeUint32(out, len(b))
+	out.Write(b)
+}
+
+// UnmarshalBinary decodes data written by MarshalBinary. It rejects data
+// with a wrong header, version or checksum and data that is truncated or has
+// trailing bytes. The instructions themselves are not checked.
+func (b *Bytecode) UnmarshalBinary(data []byte) error {
+	headerLen := len(BytecodeMagic) + 2
+	if len(data) < headerLen || string(data[:len(BytecodeMagic)]) != BytecodeMagic {
+		return fmt.Errorf("%w: not a bytecode file", ErrCorruptBytecode)
+	}
+	if version := binary.BigEndian.Uint16(data[len(BytecodeMagic):]); version != BytecodeVersion {
+		return fmt.Errorf("unsupported bytecode version %d, want %d", version, BytecodeVersion)
+	}
+	if len(data) < headerLen+4 {
+		return fmt.Errorf("%w: unexpected end of data", ErrCorruptBytecode)
+	}
+	body, sum := data[:len(data)-4], binary.BigEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return fmt.Errorf("%w: checksum mismatch", ErrCorruptBytecode)
+	}
+
+	r := &bytecodeReader{data: body, offset: headerLen}
+	r.filename = string(r.bytes())
+	instructions := r.bytes()
+	sourceMap := r.sourceMap()
+	handlers := r.handlers()
+	count := r.uint32()
+	var constants []object.Object
+	for i := 0; i < count && r.err == nil; i++ {
+		constants = append(constants, r.constant())
+	}
+	if r.err == nil && r.offset != len(r.data) {
+		r.fail("%d bytes of trailing data", len(r.data)-r.offset)
+	}
+	if r.err != nil {
+		return r.err
+	}
+	b.Instructions = code.Instructions(instructions)
+	b.SourceMap = sourceMap
+	b.Handlers = handlers
+	if constants == nil {
+		constants = []object.Object{}
+	}
+	b.Constants = constants
+	return nil
+}
+
+// bytecodeReader decodes the body of a bytecode file. After the first error
+// all reads return zero values.
+type bytecodeReader struct {
+	data     []byte
+	offset   int
+	err      error
+	filename string
+}
+
+func (r *bytecodeReader) fail(format string, a ...interface{}) {
+	if r.err == nil {
+		msg := fmt.Sprintf(format, a...)
+		r.err = fmt.Errorf("%w: offset %d: %s", ErrCorruptBytecode, r.offset, msg)
+	}
+}
+
+func (r *bytecodeReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.data)-r.offset {
+		r.fail("unexpected end of data")
+		return nil
+	}
+	b := r.data[r.offset : r.offset+n]
+	r.offset += n
+	return b
+}
+
+func (r *bytecodeReader) byte() byte {
+	b := r.next(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *bytecodeReader) uint32() int {
+	b := r.next(4)
+	if b == nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint32(b))
+}
+
+func (r *bytecodeReader) uint64() uint64 {
+	b := r.next(8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+func (r *bytecodeReader) bytes() []byte {
+	b := r.next(r.uint32())
+	// copy, so that the result does not keep data alive or alias it
+	return append([]byte{}, b...)
+}
+
+func (r *bytecodeReader) sourceMap() code.SourceMap {
+	count := r.uint32()
+	var m code.SourceMap
+	for i := 0; i < count && r.err == nil; i++ {
+		e := code.SourceMapEntry{Offset: r.uint32()}
+		e.Pos.Offset = r.uint32()
+		e.Pos.Line = r.uint32()
+		e.Pos.Column = r.uint32()
+		if e.Pos.IsValid() {
+			e.Pos.Filename = r.filename
+		} else {
+			e.Pos = token.Position{}
+		}
+		m = append(m, e)
+	}
+	return m
+}
+
+func (r *bytecodeReader) handlers() []code.Handler {
+	count := r.uint32()
+	var handlers []code.Handler
+	for i := 0; i < count && r.err == nil; i++ {
+		h := code.Handler{Start: r.uint32(), End: r.uint32()}
+		h.Target = r.uint32()
+		h.Height = r.uint32()
+		handlers = append(handlers, h)
+	}
+	return handlers
+}
+
+func (r *bytecodeReader) constant() object.Object {
+	switch tag := r.byte(); tag {
+	case constInteger:
+		return &object.Integer{Value: int64(r.uint64())}
+	case constBigInteger:
+		sign := r.byte()
+		value := new(big.Int).SetBytes(r.bytes())
+		if sign == 1 {
+			value.Neg(value)
+		} else if sign != 0 {
+			r.fail("invalid sign %d", sign)
+		}
+		if value.IsInt64() {
+			// MarshalBinary writes these as constInteger, see
+			// object.NewInteger
+			r.fail("big integer %s fits in an integer", value)
+		}
+		return &object.BigInteger{Value: value}
+	case constFloat:
+		return &object.Float{Value: math.Float64frombits(r.uint64())}
+	case constString:
+		return &object.String{Value: string(r.bytes())}
+	case constCompiledFunction:
+		fn := &object.CompiledFunction{}
+		fn.NumLocals = r.uint32()
+		fn.NumParameters = r.uint32()
+		fn.Instructions = r.bytes()
+		fn.SourceMap = r.sourceMap()
+		fn.Handlers = r.handlers()
+		return fn
+	default:
+		r.fail("unknown constant tag %d", tag)
+		return nil
+	}
+}
@@ -0,0 +1,117 @@
+package compiler
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"interpreter/object"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBytecodeRoundTrip(t *testing.T) {
+	program := parse(`
+	let big = 9223372036854775807 * 4;
+	let f = fn(a, b) { let c = a + b; fn() { c * 1.5 } };
+	puts(f(1, 2)(), "héllo", -big);
+	let g = fn() { try { f(1, 2) } catch (e) { e["message"] } };
+	try { g() } finally { puts(big) }
+	`)
+	compiler := New()
+	if err := compiler.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := compiler.Bytecode()
+	if len(bytecode.SourceMap) == 0 {
+		t.Fatalf("bytecode has no source map")
+	}
+	if len(bytecode.Handlers) == 0 {
+		t.Fatalf("bytecode has no handlers")
+	}
+	bytecode.Constants = append(bytecode.Constants,
+		&object.BigInteger{Value: new(big.Int).Lsh(big.NewInt(-3), 70)})
+
+	data, err := bytecode.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %s", err)
+	}
+	if !strings.HasPrefix(string(data), BytecodeMagic) {
+		t.Errorf("missing magic header. got=%q", data[:4])
+	}
+
+	decoded := &Bytecode{}
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary failed: %s", err)
+	}
+	if !reflect.DeepEqual(decoded, bytecode) {
+		t.Errorf("wrong bytecode.\nwant=%#v\ngot =%#v", bytecode, decoded)
+	}
+}
+
+func TestMarshalBinaryUnsupportedConstant(t *testing.T) {
+	bytecode := &Bytecode{Constants: []object.Object{&object.Integer{Value: 1}, &object.Array{}}}
+	_, err := bytecode.MarshalBinary()
+	expected := "constant 1: cannot encode constant of type *object.Array"
+	if err == nil || err.Error() != expected {
+		t.Errorf("wrong error. want=%q, got=%v", expected, err)
+	}
+}
+
+func TestUnmarshalBinaryErrors(t *testing.T) {
+	// withChecksum appends a valid checksum so that the body is decoded
+	withChecksum := func(body string) string {
+		sum := make([]byte, 4)
+		binary.BigEndian.PutUint32(sum, crc32.ChecksumIEEE([]byte(body)))
+		return body + string(sum)
+	}
+	header := BytecodeMagic + "\x00\x03"
+	// no file name, instructions, source map entries and handlers
+	empty := strings.Repeat("\x00", 16)
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"", "corrupt bytecode: not a bytecode file"},
+		{"MKXX\x00\x01", "corrupt bytecode: not a bytecode file"},
+		{BytecodeMagic + "\x00\x07", "unsupported bytecode version 7, want 3"},
+		{header + "\x00\x00", "corrupt bytecode: unexpected end of data"},
+		{header + "\x00\x00\x00\x00\x00\x00\x00\x00\xde\xad\xbe\xef", "corrupt bytecode: checksum mismatch"},
+		{
+			withChecksum(header + "\x00\x00\x00\x00" + "\x00\x00\x00\x05\x00"),
+			"corrupt bytecode: offset 14: unexpected end of data",
+		},
+		{
+			withChecksum(header + empty + "\x00\x00\x00\x01\x09"),
+			"corrupt bytecode: offset 27: unknown constant tag 9",
+		},
+		{
+			withChecksum(header + empty + "\x00\x00\x00\x01\x02\x05\x00\x00\x00\x00"),
+			"corrupt bytecode: offset 32: invalid sign 5",
+		},
+		{
+			withChecksum(header + empty + "\x00\x00\x00\x01\x02\x01\x00\x00\x00\x01\x05"),
+			"corrupt bytecode: offset 33: big integer -5 fits in an integer",
+		},
+		{
+			withChecksum(header + empty + "\x00\x00\x00\x00\xff"),
+			"corrupt bytecode: offset 26: 1 bytes of trailing data",
+		},
+	}
+
+	for _, tt := range tests {
+		err := (&Bytecode{}).UnmarshalBinary([]byte(tt.input))
+		if err == nil {
+			t.Errorf("input %q: expected an error", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("input %q: wrong error.\nwant=%q\ngot =%q", tt.input, tt.expected, err.Error())
+		}
+		if strings.HasPrefix(tt.expected, "corrupt") && !errors.Is(err, ErrCorruptBytecode) {
+			t.Errorf("input %q: error does not wrap ErrCorruptBytecode", tt.input)
+		}
+	}
+}
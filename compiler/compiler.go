@@ -7,13 +7,45 @@ import (
 	"interpreter/lexer"
 	"interpreter/object"
 	"interpreter/parser"
+	"interpreter/token"
+	"math"
 	"sort"
 )
 
+// placeholder is the target of jumps emitted before the offset they jump to
+// is known. It lies beyond the end of any function whose jumps can be
+// encoded.
+const placeholder = math.MaxUint16
+
 type CompilationScope struct {
 	instructions        code.Instructions
 	lastInstruction     EmittedInstruction
 	previousInstruction EmittedInstruction
+	loops               []*Loop
+	sourceMap           code.SourceMap
+	// tries holds the try and catch blocks being compiled, the innermost
+	// last, handlers those of the try statements compiled so far.
+	tries    []*tryBlock
+	handlers []code.Handler
+	// height is the number of values on the stack, above the locals, after
+	// the instructions emitted so far. It is kept up to date by emit and
+	// reset where paths join, it gives the heights of the handlers.
+	height int
+}
+
+// Loop tracks the jumps of the innermost loops of a scope. Jumps emitted by
+// break statements are patched once the end of the loop is known.
+type Loop struct {
+	start  int
+	breaks []int
+	// height is the stack height at start, break and continue inside an
+	// expression drop the operands above it, e.g. 1 in [1, if (c) { break }]
+	height int
+	// for-in loops keep their iterator on the stack, break has to drop it
+	iterator bool
+	// tries is the number of try blocks around the loop, break and continue
+	// run the finally blocks of those inside it
+	tries int
 }
 
 type EmittedInstruction struct {
@@ -27,11 +59,19 @@ type Compiler struct {
 	symbolTable  *SymbolTable
 	scopes       []CompilationScope
 	scopeIndex   int
+	// pos is the position of the innermost node being compiled, it is
+	// recorded in the source map of each emitted instruction
+	pos token.Position
+	// optimize enables constant folding and sharing, see SetOptimizations
+	optimize      bool
+	constantIndex map[constantKey]int
 }
 
 type Bytecode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
+	SourceMap    code.SourceMap
+	Handlers     []code.Handler
 }
 
 func New() *Compiler {
@@ -45,11 +85,13 @@ func New() *Compiler {
 		symbolTable.DefineBuiltin(i, fn.Name)
 	}
 	return &Compiler{
-		instructions: code.Instructions{},
-		constants:    []object.Object{},
-		symbolTable:  symbolTable,
-		scopes:       []CompilationScope{mainScope},
-		scopeIndex:   0,
+		instructions:  code.Instructions{},
+		constants:     []object.Object{},
+		symbolTable:   symbolTable,
+		scopes:        []CompilationScope{mainScope},
+		scopeIndex:    0,
+		optimize:      true,
+		constantIndex: map[constantKey]int{},
 	}
 }
 
@@ -59,16 +101,29 @@ func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
 		lastInstruction:     EmittedInstruction{},
 		previousInstruction: EmittedInstruction{},
 	}
-	return &Compiler{
-		instructions: code.Instructions{},
-		constants:    constants,
-		scopes:       []CompilationScope{mainScope},
-		scopeIndex:   0,
-		symbolTable:  s,
+	c := &Compiler{
+		instructions:  code.Instructions{},
+		constants:     constants,
+		scopes:        []CompilationScope{mainScope},
+		scopeIndex:    0,
+		symbolTable:   s,
+		optimize:      true,
+		constantIndex: map[constantKey]int{},
+	}
+	for i, constant := range constants {
+		if key, ok := keyOf(constant); ok {
+			c.constantIndex[key] = i
+		}
 	}
+	return c
 }
 
 func (c *Compiler) Compile(node ast.Node) error {
+	if node != nil && node.Pos().IsValid() {
+		defer func(pos token.Position) { c.pos = pos }(c.pos)
+		c.pos = node.Pos()
+	}
+
 	switch node := node.(type) {
 	case *ast.Program:
 		for _, s := range node.Statements {
@@ -91,26 +146,23 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 		c.emit(code.OpPop)
 	case *ast.InfixExpression:
-		// switch order
-		if node.Operator == "<" {
-			err := c.Compile(node.Right)
-			if err != nil {
-				return err
-			}
-			err = c.Compile(node.Left)
-			if err != nil {
-				return err
-			}
-		} else {
-			err := c.Compile(node.Left)
-			if err != nil {
-				return err
-			}
-			err = c.Compile(node.Right)
-			if err != nil {
-				return err
+		if c.optimize {
+			if obj, ok := fold(node); ok {
+				c.emitFolded(obj)
+				return nil
 			}
 		}
+		if node.Operator == "&&" || node.Operator == "||" {
+			return c.compileLogicalExpression(node)
+		}
+		err := c.Compile(node.Left)
+		if err != nil {
+			return err
+		}
+		err = c.Compile(node.Right)
+		if err != nil {
+			return err
+		}
 		switch node.Operator {
 		case "+":
 			c.emit(code.OpAdd)
@@ -120,18 +172,42 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpDiv)
 		case "*":
 			c.emit(code.OpMul)
+		case "%":
+			c.emit(code.OpMod)
+		case "**":
+			c.emit(code.OpPow)
+		case "&":
+			c.emit(code.OpBitAnd)
+		case "|":
+			c.emit(code.OpBitOr)
+		case "^":
+			c.emit(code.OpBitXor)
+		case "<<":
+			c.emit(code.OpShiftLeft)
+		case ">>":
+			c.emit(code.OpShiftRight)
 		case "<":
-			c.emit(code.OpGreaterThan)
+			c.emit(code.OpLessThan)
+		case "<=":
+			c.emit(code.OpLessEqual)
 		case ">":
 			c.emit(code.OpGreaterThan)
+		case ">=":
+			c.emit(code.OpGreaterEqual)
 		case "==":
 			c.emit(code.OpEqual)
 		case "!=":
 			c.emit(code.OpNotEqual)
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			return fmt.Errorf("%s: unknown operator %s", node.Token.Pos, node.Operator)
 		}
 	case *ast.PrefixExpression:
+		if c.optimize {
+			if obj, ok := fold(node); ok {
+				c.emitFolded(obj)
+				return nil
+			}
+		}
 		err := c.Compile(node.Right)
 		if err != nil {
 			return err
@@ -141,12 +217,20 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpMinus)
 		case "!":
 			c.emit(code.OpBang)
+		case "~":
+			c.emit(code.OpBitNot)
 		default:
-			return fmt.Errorf("unknown operator %s", node.Operator)
+			return fmt.Errorf("%s: unknown operator %s", node.Token.Pos, node.Operator)
 		}
 	case *ast.IntegerLiteral:
-		integer := &object.Integer{Value: node.Value}
+		var integer object.Object = &object.Integer{Value: node.Value}
+		if node.Big != nil {
+			integer = object.NewInteger(node.Big)
+		}
 		c.emit(code.OpConstant, c.addConstant(integer))
+	case *ast.FloatLiteral:
+		float := &object.Float{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(float))
 	case *ast.Boolean:
 		if node.Value {
 			c.emit(code.OpTrue)
@@ -158,7 +242,8 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if err != nil {
 			return err
 		}
-		jumpNotTruthy := c.emit(code.OpJumpNotTruthy, 9999)
+		jumpNotTruthy := c.emit(code.OpJumpNotTruthy, placeholder)
+		height := c.scopes[c.scopeIndex].height
 
 		err = c.Compile(node.Consequence)
 		if err != nil {
@@ -166,11 +251,15 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 		if c.lastInstructionIsPop() {
 			c.removeLastPop()
+		} else {
+			// the block ended with a statement that leaves no value
+			c.emit(code.OpNull)
 		}
 
-		jumpPos := c.emit(code.OpJump, 9999)
+		jumpPos := c.emit(code.OpJump, placeholder)
 		afterConsequencePos := len(c.currentInstructions())
 		c.changeOperand(jumpNotTruthy, afterConsequencePos)
+		c.scopes[c.scopeIndex].height = height
 
 		if node.Alternative == nil {
 			c.emit(code.OpNull)
@@ -181,25 +270,133 @@ func (c *Compiler) Compile(node ast.Node) error {
 			}
 			if c.lastInstructionIsPop() {
 				c.removeLastPop()
+			} else {
+				c.emit(code.OpNull)
 			}
 		}
 		afterAlternativePos := len(c.currentInstructions())
 		c.changeOperand(jumpPos, afterAlternativePos)
+	case *ast.AssignStatement:
+		return c.compileAssignStatement(node)
+	case *ast.WhileStatement:
+		start := len(c.currentInstructions())
+		err := c.Compile(node.Condition)
+		if err != nil {
+			return err
+		}
+		jumpNotTruthy := c.emit(code.OpJumpNotTruthy, placeholder)
+
+		c.enterLoop(start)
+		err = c.Compile(node.Body)
+		if err != nil {
+			return err
+		}
+		loop := c.leaveLoop()
+		c.emit(code.OpJump, start)
+
+		afterLoopPos := len(c.currentInstructions())
+		c.changeOperand(jumpNotTruthy, afterLoopPos)
+		for _, pos := range loop.breaks {
+			c.changeOperand(pos, afterLoopPos)
+		}
+		// the value of the loop is null, like in the evaluator, rather
+		// than the condition popped last
+		c.emit(code.OpNull)
+		c.emit(code.OpPop)
+	case *ast.ForStatement:
+		height := c.scopes[c.scopeIndex].height
+		err := c.Compile(node.Iterable)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpIter)
+
+		vars := []*ast.Identifier{node.Value}
+		if node.Key != nil {
+			vars = []*ast.Identifier{node.Key, node.Value}
+		}
+		symbols := make([]Symbol, len(vars))
+		for i, v := range vars {
+			symbols[i] = c.symbolTable.Define(v.Value)
+		}
+
+		start := c.emit(code.OpIterNext, placeholder, len(vars))
+		// the value is on top of the stack
+		for i := len(symbols) - 1; i >= 0; i-- {
+			c.storeSymbol(symbols[i])
+		}
+
+		c.enterLoop(start)
+		c.currentLoop().iterator = true
+		err = c.Compile(node.Body)
+		if err != nil {
+			return err
+		}
+		loop := c.leaveLoop()
+		c.emit(code.OpJump, start)
+
+		afterLoopPos := len(c.currentInstructions())
+		c.replaceInstruction(start, code.Make(code.OpIterNext, afterLoopPos, len(vars)))
+		for _, pos := range loop.breaks {
+			c.changeOperand(pos, afterLoopPos)
+		}
+		// the iterator is gone
+		c.scopes[c.scopeIndex].height = height
+		// null rather than the iterator popped last, as for while loops
+		c.emit(code.OpNull)
+		c.emit(code.OpPop)
+	case *ast.BreakStatement:
+		loop := c.currentLoop()
+		if loop == nil {
+			return fmt.Errorf("%s: break outside loop", node.Pos())
+		}
+		err := c.leaveTries(loop.tries)
+		if err != nil {
+			return err
+		}
+		height := c.scopes[c.scopeIndex].height
+		target := loop.height
+		if loop.iterator {
+			target--
+		}
+		c.unwind(loop, target)
+		loop.breaks = append(loop.breaks, c.emit(code.OpJump, placeholder))
+		// for the unreachable code up to the end of the block
+		c.scopes[c.scopeIndex].height = height
+		c.resumeTries(loop.tries)
+	case *ast.ContinueStatement:
+		loop := c.currentLoop()
+		if loop == nil {
+			return fmt.Errorf("%s: continue outside loop", node.Pos())
+		}
+		err := c.leaveTries(loop.tries)
+		if err != nil {
+			return err
+		}
+		height := c.scopes[c.scopeIndex].height
+		c.unwind(loop, loop.height)
+		c.emit(code.OpJump, loop.start)
+		c.scopes[c.scopeIndex].height = height
+		c.resumeTries(loop.tries)
+	case *ast.TryStatement:
+		return c.compileTryStatement(node)
+	case *ast.ThrowStatement:
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
 	case *ast.LetStatement:
 		symbol := c.symbolTable.Define(node.Name.Value)
 		err := c.Compile(node.Value)
 		if err != nil {
 			return err
 		}
-		if symbol.Scope == GlobalScope {
-			c.emit(code.OpSetGlobal, symbol.Index)
-		} else {
-			c.emit(code.OpSetLocal, symbol.Index)
-		}
+		c.storeSymbol(symbol)
 	case *ast.Identifier:
 		symbol, ok := c.symbolTable.Resolve(node.Value)
 		if !ok {
-			return fmt.Errorf("undefined variable %s", node.Value)
+			return fmt.Errorf("%s: undefined variable %s", node.Pos(), node.Value)
 		}
 		err := c.loadSymbol(symbol)
 		if err != nil {
@@ -245,9 +442,13 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 		c.emit(code.OpIndex)
+	case *ast.MacroLiteral:
+		return fmt.Errorf("%s: macro literals must be bound by a top-level let statement", node.Pos())
 	case *ast.FunctionLiteral:
 		c.enterScope()
-		if node.Name != "" {
+		// a function assigning to its own name refers to the variable
+		// holding it, like the evaluator, instead of the current closure
+		if node.Name != "" && !assigns(node.Body, node.Name) {
 			c.symbolTable.DefineFunctionName(node.Name)
 		}
 		for _, param := range node.Parameters {
@@ -261,23 +462,39 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.removeLastPop()
 			c.emit(code.OpReturnValue)
 		}
-		if len(c.currentInstructions()) == 0 {
+		if !c.lastInstructionIs(code.OpReturnValue) {
 			c.emit(code.OpReturn)
 		}
 		freeSymbols := c.symbolTable.FreeSymbols
 		numLocals := c.symbolTable.numDefinitions
+		sourceMap := c.scopes[c.scopeIndex].sourceMap
+		handlers := c.scopes[c.scopeIndex].handlers
 		instructions := c.leaveScope()
+		markTailCalls(instructions, handlers)
+		if c.optimize {
+			instructions, sourceMap, handlers = peephole(instructions, sourceMap, handlers)
+		}
 
+		// free variables are captured by reference, see object.Upvalue
 		for _, s := range freeSymbols {
-			err := c.loadSymbol(s)
-			if err != nil {
-				return err
+			switch s.Scope {
+			case LocalScope:
+				c.emit(code.OpCaptureLocal, s.Index)
+			case FreeScope:
+				c.emit(code.OpCaptureFree, s.Index)
+			default:
+				err := c.loadSymbol(s)
+				if err != nil {
+					return err
+				}
 			}
 		}
 		compiledFn := &object.CompiledFunction{
 			Instructions:  instructions,
 			NumLocals:     numLocals,
 			NumParameters: len(node.Parameters),
+			SourceMap:     sourceMap,
+			Handlers:      handlers,
 		}
 		fnIndex := c.addConstant(compiledFn)
 		c.emit(code.OpClosure, fnIndex, len(freeSymbols))
@@ -286,7 +503,12 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if err != nil {
 			return err
 		}
+		err = c.leaveTries(0)
+		if err != nil {
+			return err
+		}
 		c.emit(code.OpReturnValue)
+		c.resumeTries(0)
 	case *ast.CallExpression:
 		err := c.Compile(node.Function)
 		if err != nil {
@@ -303,6 +525,129 @@ func (c *Compiler) Compile(node ast.Node) error {
 	return nil
 }
 
+// compileLogicalExpression compiles && and || so that the right operand is
+// only evaluated when the left one does not determine the result. Both
+// operators produce a boolean.
+func (c *Compiler) compileLogicalExpression(node *ast.InfixExpression) error {
+	err := c.Compile(node.Left)
+	if err != nil {
+		return err
+	}
+	jumpNotTruthy := c.emit(code.OpJumpNotTruthy, placeholder)
+	height := c.scopes[c.scopeIndex].height
+	if node.Operator == "&&" {
+		err = c.Compile(node.Right)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpBang)
+		c.emit(code.OpBang)
+		jumpPos := c.emit(code.OpJump, placeholder)
+		c.changeOperand(jumpNotTruthy, len(c.currentInstructions()))
+		c.scopes[c.scopeIndex].height = height
+		c.emit(code.OpFalse)
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+		return nil
+	}
+	c.emit(code.OpTrue)
+	jumpPos := c.emit(code.OpJump, placeholder)
+	c.changeOperand(jumpNotTruthy, len(c.currentInstructions()))
+	c.scopes[c.scopeIndex].height = height
+	err = c.Compile(node.Right)
+	if err != nil {
+		return err
+	}
+	c.emit(code.OpBang)
+	c.emit(code.OpBang)
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+	return nil
+}
+
+var compoundAssignOperators = map[string]code.Opcode{
+	"+=": code.OpAdd,
+	"-=": code.OpSub,
+	"*=": code.OpMul,
+	"/=": code.OpDiv,
+}
+
+func (c *Compiler) compileAssignStatement(node *ast.AssignStatement) error {
+	op, compound := compoundAssignOperators[node.Operator]
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(target.Value)
+		if !ok {
+			return fmt.Errorf("%s: cannot assign to undeclared variable %s", target.Pos(), target.Value)
+		}
+		if symbol.Scope == BuiltinScope {
+			return fmt.Errorf("%s: cannot assign to builtin %s", target.Pos(), target.Value)
+		}
+		if compound {
+			err := c.loadSymbol(symbol)
+			if err != nil {
+				return err
+			}
+		}
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+		if compound {
+			c.emit(op)
+		}
+		c.storeSymbol(symbol)
+	case *ast.IndexExpression:
+		err := c.Compile(target.Left)
+		if err != nil {
+			return err
+		}
+		err = c.Compile(target.Index)
+		if err != nil {
+			return err
+		}
+		if compound {
+			// keep the container and the index for OpSetIndex
+			c.emit(code.OpDup2)
+			c.emit(code.OpIndex)
+		}
+		err = c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+		if compound {
+			c.emit(op)
+		}
+		c.emit(code.OpSetIndex)
+	default:
+		return fmt.Errorf("%s: cannot assign to %s", node.Pos(), node.Target)
+	}
+	return nil
+}
+
+// assigns reports whether node contains an assignment to the variable name.
+func assigns(node ast.Node, name string) bool {
+	found := false
+	ast.Inspect(node, func(n ast.Node) bool {
+		if as, ok := n.(*ast.AssignStatement); ok {
+			if ident, ok := as.Target.(*ast.Identifier); ok && ident.Value == name {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+func (c *Compiler) storeSymbol(symbol Symbol) {
+	switch symbol.Scope {
+	case GlobalScope:
+		c.emit(code.OpSetGlobal, symbol.Index)
+	case FreeScope:
+		c.emit(code.OpSetFree, symbol.Index)
+	default:
+		c.emit(code.OpSetLocal, symbol.Index)
+	}
+}
+
 func (c *Compiler) loadSymbol(symbol Symbol) error {
 	switch symbol.Scope {
 	case GlobalScope:
@@ -325,6 +670,8 @@ func (c *Compiler) emit(op code.Opcode, operands ...int) int {
 	ins := code.Make(op, operands...)
 	pos := c.addInstruction(ins)
 	c.setLastInstruction(op, pos)
+	pops, pushes := code.StackEffect(op, operands)
+	c.scopes[c.scopeIndex].height += pushes - pops
 	return pos
 }
 
@@ -333,6 +680,7 @@ func (c *Compiler) addInstruction(ins []byte) int {
 	updatedInstructions := append(c.currentInstructions(), ins...)
 
 	c.scopes[c.scopeIndex].instructions = updatedInstructions
+	c.scopes[c.scopeIndex].sourceMap = c.scopes[c.scopeIndex].sourceMap.Add(posNewInstruction, c.pos)
 	return posNewInstruction
 }
 
@@ -349,7 +697,14 @@ func (c *Compiler) currentInstructions() code.Instructions {
 }
 
 func (c *Compiler) lastInstructionIsPop() bool {
-	return c.scopes[c.scopeIndex].lastInstruction.Opcode == code.OpPop
+	return c.lastInstructionIs(code.OpPop)
+}
+
+func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
+	if len(c.currentInstructions()) == 0 {
+		return false
+	}
+	return c.scopes[c.scopeIndex].lastInstruction.Opcode == op
 }
 
 func (c *Compiler) removeLastPop() {
@@ -358,7 +713,42 @@ func (c *Compiler) removeLastPop() {
 	old := c.currentInstructions()
 	new := old[:last.Position]
 	c.scopes[c.scopeIndex].instructions = new
+	c.scopes[c.scopeIndex].sourceMap = c.scopes[c.scopeIndex].sourceMap.Truncate(last.Position)
 	c.scopes[c.scopeIndex].lastInstruction = previous
+	c.scopes[c.scopeIndex].height++
+}
+
+// markTailCalls turns the calls in ins whose result is returned right away
+// into OpTailCall, possibly after jumps, e.g. from the end of an if branch.
+// The VM makes them without a new frame. Calls covered by one of handlers
+// are left alone, their frame is needed to catch their exceptions.
+func markTailCalls(ins code.Instructions, handlers []code.Handler) {
+	covered := make([]bool, len(ins))
+	for _, h := range handlers {
+		for offset := h.Start; offset < h.End; offset++ {
+			covered[offset] = true
+		}
+	}
+	for i := 0; i < len(ins); {
+		def, _ := code.Lookup(ins[i])
+		_, read := code.ReadOperands(def, ins[i+1:])
+		next := i + 1 + read
+		if code.Opcode(ins[i]) == code.OpCall && !covered[i] {
+			target := next
+			for target < len(ins) && code.Opcode(ins[target]) == code.OpJump {
+				jump := int(code.ReadUint16(ins[target+1:]))
+				if jump <= target {
+					// a loop, the result is not returned
+					break
+				}
+				target = jump
+			}
+			if target < len(ins) && code.Opcode(ins[target]) == code.OpReturnValue {
+				ins[i] = byte(code.OpTailCall)
+			}
+		}
+		i = next
+	}
 }
 
 func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
@@ -374,15 +764,34 @@ func (c *Compiler) changeOperand(opPos int, operand int) {
 	c.replaceInstruction(opPos, newInstruction)
 }
 
-func (c *Compiler) addConstant(integer object.Object) int {
-	c.constants = append(c.constants, integer)
-	return len(c.constants) - 1
+func (c *Compiler) addConstant(obj object.Object) int {
+	key, shareable := keyOf(obj)
+	if shareable && c.optimize {
+		if index, ok := c.constantIndex[key]; ok {
+			return index
+		}
+	}
+	c.constants = append(c.constants, obj)
+	index := len(c.constants) - 1
+	if shareable {
+		c.constantIndex[key] = index
+	}
+	return index
 }
 
 func (c *Compiler) Bytecode() *Bytecode {
+	instructions := c.currentInstructions()
+	sourceMap := c.scopes[c.scopeIndex].sourceMap
+	handlers := c.scopes[c.scopeIndex].handlers
+	if c.optimize {
+		// the scope is left alone, the REPL keeps compiling into it
+		instructions, sourceMap, handlers = peephole(instructions, sourceMap, handlers)
+	}
 	return &Bytecode{
-		Instructions: c.currentInstructions(),
+		Instructions: instructions,
 		Constants:    c.constants,
+		SourceMap:    sourceMap,
+		Handlers:     handlers,
 	}
 }
 
@@ -405,6 +814,41 @@ func (c *Compiler) leaveScope() code.Instructions {
 	return instructions
 }
 
+func (c *Compiler) enterLoop(start int) {
+	scope := &c.scopes[c.scopeIndex]
+	scope.loops = append(scope.loops, &Loop{start: start, height: scope.height, tries: len(scope.tries)})
+}
+
+// unwind pops the values above height for a break or continue leaving loop.
+// The try blocks inside the loop are interrupted, their handlers keep
+// values the pops drop. resumeTries continues them.
+func (c *Compiler) unwind(loop *Loop, height int) {
+	if c.scopes[c.scopeIndex].height == height {
+		return
+	}
+	for _, t := range c.scopes[c.scopeIndex].tries[loop.tries:] {
+		t.interrupt(len(c.currentInstructions()))
+	}
+	for c.scopes[c.scopeIndex].height > height {
+		c.emit(code.OpPop)
+	}
+}
+
+func (c *Compiler) leaveLoop() *Loop {
+	scope := &c.scopes[c.scopeIndex]
+	loop := scope.loops[len(scope.loops)-1]
+	scope.loops = scope.loops[:len(scope.loops)-1]
+	return loop
+}
+
+func (c *Compiler) currentLoop() *Loop {
+	loops := c.scopes[c.scopeIndex].loops
+	if len(loops) == 0 {
+		return nil
+	}
+	return loops[len(loops)-1]
+}
+
 func parse(input string) *ast.Program {
 	l := lexer.New(input)
 	p := parser.New(l)
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"interpreter/code"
 	"interpreter/object"
+	"reflect"
 	"testing"
 )
 
@@ -54,6 +55,85 @@ func TestIntegerArithmetic(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input:             "2 % 1",
+			expectedConstants: []interface{}{2, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMod),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "2 ** 1",
+			expectedConstants: []interface{}{2, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPow),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "2 & 1",
+			expectedConstants: []interface{}{2, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpBitAnd),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "2 | 1",
+			expectedConstants: []interface{}{2, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpBitOr),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "2 ^ 1",
+			expectedConstants: []interface{}{2, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpBitXor),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "2 << 1",
+			expectedConstants: []interface{}{2, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpShiftLeft),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "2 >> 1",
+			expectedConstants: []interface{}{2, 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpShiftRight),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "~1",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpBitNot),
+				code.Make(code.OpPop),
+			},
+		},
 		{
 			input:             "2 / 1",
 			expectedConstants: []interface{}{2, 1},
@@ -64,6 +144,16 @@ func TestIntegerArithmetic(t *testing.T) {
 				code.Make(code.OpPop),
 			},
 		},
+		{
+			input:             "1.5 * 2",
+			expectedConstants: []interface{}{1.5, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMul),
+				code.Make(code.OpPop),
+			},
+		},
 		{
 			input:             "-1",
 			expectedConstants: []interface{}{1},
@@ -77,11 +167,20 @@ func TestIntegerArithmetic(t *testing.T) {
 	runCompilerTests(t, tests)
 }
 
+// runCompilerTests compiles without optimizations, so that the expected
+// bytecode follows the source. See TestOptimizations for the optimized
+// output.
 func runCompilerTests(t *testing.T, tests []compilerTestCase) {
+	t.Helper()
+	runCompilerTestsWith(t, tests, false)
+}
+
+func runCompilerTestsWith(t *testing.T, tests []compilerTestCase, optimize bool) {
 	t.Helper()
 	for _, tt := range tests {
 		program := parse(tt.input)
 		compiler := New()
+		compiler.SetOptimizations(optimize)
 		err := compiler.Compile(program)
 		if err != nil {
 			t.Fatalf("compiler error: %s", err)
@@ -142,6 +241,12 @@ func testConstants(
 					i, err)
 			}
 
+		case float64:
+			result, ok := actual[i].(*object.Float)
+			if !ok || result.Value != constant {
+				return fmt.Errorf("constant %d - wrong float. want=%g, got=%+v",
+					i, constant, actual[i])
+			}
 		case string:
 			err := testStringObject(constant, actual[i])
 			if err != nil {
@@ -207,11 +312,75 @@ func TestBooleanExpressions(t *testing.T) {
 		},
 		{
 			input:             "1 < 2",
-			expectedConstants: []interface{}{2, 1},
+			expectedConstants: []interface{}{1, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
-				code.Make(code.OpGreaterThan),
+				code.Make(code.OpLessThan),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 <= 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpLessEqual),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 >= 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpGreaterEqual),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "true && false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 10),
+				// 0004
+				code.Make(code.OpFalse),
+				// 0005
+				code.Make(code.OpBang),
+				// 0006
+				code.Make(code.OpBang),
+				// 0007
+				code.Make(code.OpJump, 11),
+				// 0010
+				code.Make(code.OpFalse),
+				// 0011
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "true || false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 8),
+				// 0004
+				code.Make(code.OpTrue),
+				// 0005
+				code.Make(code.OpJump, 11),
+				// 0008
+				code.Make(code.OpFalse),
+				// 0009
+				code.Make(code.OpBang),
+				// 0010
+				code.Make(code.OpBang),
+				// 0011
 				code.Make(code.OpPop),
 			},
 		},
@@ -322,47 +491,64 @@ if (true) { 10 } else { 20 }; 3333;
 	runCompilerTests(t, tests)
 }
 
-func TestGlobalLetStatements(t *testing.T) {
+func TestAssignStatements(t *testing.T) {
 	tests := []compilerTestCase{
 		{
-			input: `
-let one = 1;
-let two = 2;
-`,
+			input:             "let x = 1; x = 2;",
 			expectedConstants: []interface{}{1, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpConstant, 1),
-				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpSetGlobal, 0),
 			},
 		},
 		{
-			input: `
-let one = 1;
-one;
-`,
-			expectedConstants: []interface{}{1},
+			input:             "let x = 1; x += 2;",
+			expectedConstants: []interface{}{1, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpGetGlobal, 0),
-				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpSetGlobal, 0),
 			},
 		},
 		{
-			input: `
-let one = 1;
-let two = one;
-two;
-`,
-			expectedConstants: []interface{}{1},
+			input:             `let h = {}; h["a"] = 1;`,
+			expectedConstants: []interface{}{"a", 1},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
+				code.Make(code.OpHash, 0),
 				code.Make(code.OpSetGlobal, 0),
 				code.Make(code.OpGetGlobal, 0),
-				code.Make(code.OpSetGlobal, 1),
-				code.Make(code.OpGetGlobal, 1),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetIndex),
+			},
+		},
+		{
+			input: "fn() { let a = [1]; a[0] -= 1; }",
+			expectedConstants: []interface{}{
+				1,
+				0,
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpArray, 1),
+					code.Make(code.OpSetLocal, 0),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpDup2),
+					code.Make(code.OpIndex),
+					code.Make(code.OpConstant, 2),
+					code.Make(code.OpSub),
+					code.Make(code.OpSetIndex),
+					code.Make(code.OpReturn),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 3, 0),
 				code.Make(code.OpPop),
 			},
 		},
@@ -370,70 +556,405 @@ two;
 	runCompilerTests(t, tests)
 }
 
-func TestStringExpressions(t *testing.T) {
+func TestAssignFreeVariables(t *testing.T) {
 	tests := []compilerTestCase{
 		{
-			input:             `"monkey"`,
-			expectedConstants: []interface{}{"monkey"},
+			input: "fn(a) { fn() { a += 1; } }",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpGetFree, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpAdd),
+					code.Make(code.OpSetFree, 0),
+					code.Make(code.OpReturn),
+				},
+				[]code.Instructions{
+					code.Make(code.OpCaptureLocal, 0),
+					code.Make(code.OpClosure, 1, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
+				code.Make(code.OpClosure, 2, 0),
 				code.Make(code.OpPop),
 			},
 		},
 		{
-			input:             `"mon" + "key"`,
-			expectedConstants: []interface{}{"mon", "key"},
+			// the name is the global, not the current closure
+			input: "let f = fn() { f = 1; f }",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetGlobal, 0),
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpAdd),
-				code.Make(code.OpPop),
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpSetGlobal, 0),
 			},
 		},
 	}
 	runCompilerTests(t, tests)
 }
 
-func testStringObject(expected string, actual object.Object) error {
-	result, ok := actual.(*object.String)
-	if !ok {
-		return fmt.Errorf("object is not String. got=%T (%+v)",
-			actual, actual)
+func TestInvalidAssignments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x = 1;", "1:1: cannot assign to undeclared variable x"},
+		{"len = 1;", "1:1: cannot assign to builtin len"},
 	}
-	if result.Value != expected {
-		return fmt.Errorf("object has wrong value. got=%q, want=%q",
-			result.Value, expected)
+	for _, tt := range tests {
+		program := parse(tt.input)
+		compiler := New()
+		err := compiler.Compile(program)
+		if err == nil {
+			t.Fatalf("expected compiler error for %q", tt.input)
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong compiler error. want=%q, got=%q", tt.expected, err)
+		}
 	}
-	return nil
 }
 
-func TestArrayLiterals(t *testing.T) {
+func TestWhileStatements(t *testing.T) {
 	tests := []compilerTestCase{
 		{
-			input:             "[]",
+			input:             "while (true) { if (false) { continue; } break; }",
 			expectedConstants: []interface{}{},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpArray, 0),
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 23),
+				// 0004
+				code.Make(code.OpFalse),
+				// 0005
+				code.Make(code.OpJumpNotTruthy, 15),
+				// 0008
+				code.Make(code.OpJump, 0),
+				// 0011
+				code.Make(code.OpNull),
+				// 0012
+				code.Make(code.OpJump, 16),
+				// 0015
+				code.Make(code.OpNull),
+				// 0016
 				code.Make(code.OpPop),
-			},
-		},
-		{
-			input:             "[1, 2, 3]",
-			expectedConstants: []interface{}{1, 2, 3},
-			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpConstant, 2),
-				code.Make(code.OpArray, 3),
+				// 0017
+				code.Make(code.OpJump, 23),
+				// 0020
+				code.Make(code.OpJump, 0),
+				// 0023
+				code.Make(code.OpNull),
+				// 0024
 				code.Make(code.OpPop),
 			},
 		},
 		{
-			input:             "[1 + 2, 3 - 4, 5 * 6]",
-			expectedConstants: []interface{}{1, 2, 3, 4, 5, 6},
+			input:             "while (true) { while (false) { break; } break; }",
+			expectedConstants: []interface{}{},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpConstant, 1),
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 22),
+				// 0004
+				code.Make(code.OpFalse),
+				// 0005
+				code.Make(code.OpJumpNotTruthy, 14),
+				// 0008
+				code.Make(code.OpJump, 14),
+				// 0011
+				code.Make(code.OpJump, 4),
+				// 0014
+				code.Make(code.OpNull),
+				// 0015
+				code.Make(code.OpPop),
+				// 0016
+				code.Make(code.OpJump, 22),
+				// 0019
+				code.Make(code.OpJump, 0),
+				// 0022
+				code.Make(code.OpNull),
+				// 0023
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn() { while (true) { break; } }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					// 0000
+					code.Make(code.OpTrue),
+					// 0001
+					code.Make(code.OpJumpNotTruthy, 10),
+					// 0004
+					code.Make(code.OpJump, 10),
+					// 0007
+					code.Make(code.OpJump, 0),
+					// 0010
+					code.Make(code.OpNull),
+					// 0011
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestForStatements(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "for (x in [1]) { x }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpArray, 1),
+				// 0006
+				code.Make(code.OpIter),
+				// 0007
+				code.Make(code.OpIterNext, 22, 1),
+				// 0012
+				code.Make(code.OpSetGlobal, 0),
+				// 0015
+				code.Make(code.OpGetGlobal, 0),
+				// 0018
+				code.Make(code.OpPop),
+				// 0019
+				code.Make(code.OpJump, 7),
+				// 0022
+				code.Make(code.OpNull),
+				// 0023
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "for (x in []) { break; }",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpArray, 0),
+				// 0003
+				code.Make(code.OpIter),
+				// 0004
+				code.Make(code.OpIterNext, 19, 1),
+				// 0009
+				code.Make(code.OpSetGlobal, 0),
+				// 0012
+				code.Make(code.OpPop),
+				// 0013
+				code.Make(code.OpJump, 19),
+				// 0016
+				code.Make(code.OpJump, 4),
+				// 0019
+				code.Make(code.OpNull),
+				// 0020
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn() { for (k, v in {}) { continue; } }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					// 0000
+					code.Make(code.OpHash, 0),
+					// 0003
+					code.Make(code.OpIter),
+					// 0004
+					code.Make(code.OpIterNext, 21, 2),
+					// 0009
+					code.Make(code.OpSetLocal, 1),
+					// 0012
+					code.Make(code.OpSetLocal, 0),
+					// 0015
+					code.Make(code.OpJump, 4),
+					// 0018
+					code.Make(code.OpJump, 4),
+					// 0021
+					code.Make(code.OpNull),
+					// 0022
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestLoopControlOutsideLoop(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"break;", "1:1: break outside loop"},
+		{"if (true) { continue; }", "1:13: continue outside loop"},
+		{"while (true) { fn() { break; } }", "1:23: break outside loop"},
+	}
+	for _, tt := range tests {
+		program := parse(tt.input)
+		compiler := New()
+		err := compiler.Compile(program)
+		if err == nil {
+			t.Fatalf("expected compiler error for %q", tt.input)
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong compiler error. want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func TestMacroLiterals(t *testing.T) {
+	// macro definitions are removed and macro calls expanded before
+	// compiling, see evaluator.ExpandMacros
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"[macro(x) { x }]", "1:2: macro literals must be bound by a top-level let statement"},
+		{"let m = macro(x) { x }", "1:9: macro literals must be bound by a top-level let statement"},
+	}
+	for _, tt := range tests {
+		program := parse(tt.input)
+		compiler := New()
+		err := compiler.Compile(program)
+		if err == nil {
+			t.Fatalf("expected compiler error for %q", tt.input)
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong compiler error. want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func TestGlobalLetStatements(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `
+let one = 1;
+let two = 2;
+`,
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpSetGlobal, 1),
+			},
+		},
+		{
+			input: `
+let one = 1;
+one;
+`,
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: `
+let one = 1;
+let two = one;
+two;
+`,
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpGetGlobal, 1),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestStringExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `"monkey"`,
+			expectedConstants: []interface{}{"monkey"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `"mon" + "key"`,
+			expectedConstants: []interface{}{"mon", "key"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func testStringObject(expected string, actual object.Object) error {
+	result, ok := actual.(*object.String)
+	if !ok {
+		return fmt.Errorf("object is not String. got=%T (%+v)",
+			actual, actual)
+	}
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%q, want=%q",
+			result.Value, expected)
+	}
+	return nil
+}
+
+func TestArrayLiterals(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "[]",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpArray, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "[1, 2, 3]",
+			expectedConstants: []interface{}{1, 2, 3},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpArray, 3),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "[1 + 2, 3 - 4, 5 * 6]",
+			expectedConstants: []interface{}{1, 2, 3, 4, 5, 6},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
 				code.Make(code.OpAdd),
 				code.Make(code.OpConstant, 2),
 				code.Make(code.OpConstant, 3),
@@ -889,7 +1410,7 @@ push([], 1);
 				[]code.Instructions{
 					code.Make(code.OpGetBuiltin, 0),
 					code.Make(code.OpArray, 0),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
 			},
@@ -920,7 +1441,7 @@ fn(a) {
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCaptureLocal, 0),
 					code.Make(code.OpClosure, 0, 1),
 					code.Make(code.OpReturnValue),
 				},
@@ -950,13 +1471,13 @@ fn(a) {
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetFree, 0),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCaptureFree, 0),
+					code.Make(code.OpCaptureLocal, 0),
 					code.Make(code.OpClosure, 0, 2),
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCaptureLocal, 0),
 					code.Make(code.OpClosure, 1, 1),
 					code.Make(code.OpReturnValue),
 				},
@@ -1000,15 +1521,15 @@ fn() {
 				[]code.Instructions{
 					code.Make(code.OpConstant, 2),
 					code.Make(code.OpSetLocal, 0),
-					code.Make(code.OpGetFree, 0),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCaptureFree, 0),
+					code.Make(code.OpCaptureLocal, 0),
 					code.Make(code.OpClosure, 4, 2),
 					code.Make(code.OpReturnValue),
 				},
 				[]code.Instructions{
 					code.Make(code.OpConstant, 1),
 					code.Make(code.OpSetLocal, 0),
-					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpCaptureLocal, 0),
 					code.Make(code.OpClosure, 5, 1),
 					code.Make(code.OpReturnValue),
 				},
@@ -1038,7 +1559,7 @@ countDown(1);
 					code.Make(code.OpGetLocal, 0),
 					code.Make(code.OpConstant, 0),
 					code.Make(code.OpSub),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
 				1,
@@ -1067,7 +1588,7 @@ wrapper();
 					code.Make(code.OpGetLocal, 0),
 					code.Make(code.OpConstant, 0),
 					code.Make(code.OpSub),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
 				1,
@@ -1076,7 +1597,7 @@ wrapper();
 					code.Make(code.OpSetLocal, 0),
 					code.Make(code.OpGetLocal, 0),
 					code.Make(code.OpConstant, 2),
-					code.Make(code.OpCall, 1),
+					code.Make(code.OpTailCall, 1),
 					code.Make(code.OpReturnValue),
 				},
 			},
@@ -1091,3 +1612,494 @@ wrapper();
 	}
 	runCompilerTests(t, tests)
 }
+
+func TestSourceMap(t *testing.T) {
+	program := parse("let x = 1;\nputs(\n  x + 2);\nlet f = fn() {\n  x };")
+	compiler := New()
+	if err := compiler.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := compiler.Bytecode()
+
+	tests := []struct {
+		sourceMap code.SourceMap
+		offset    int
+		expected  string
+	}{
+		{bytecode.SourceMap, 0, "1:9"},  // OpConstant 1
+		{bytecode.SourceMap, 3, "1:1"},  // OpSetGlobal
+		{bytecode.SourceMap, 6, "2:1"},  // OpGetBuiltin
+		{bytecode.SourceMap, 9, "3:3"},  // OpGetGlobal
+		{bytecode.SourceMap, 12, "3:7"}, // OpConstant 2
+		{bytecode.SourceMap, 15, "3:3"}, // OpAdd
+		{bytecode.SourceMap, 16, "2:1"}, // OpCall
+		{bytecode.SourceMap, 19, "2:1"}, // OpPop
+		{bytecode.Constants[2].(*object.CompiledFunction).SourceMap, 0, "5:3"},
+	}
+
+	for _, tt := range tests {
+		pos := tt.sourceMap.Pos(tt.offset)
+		if pos.String() != tt.expected {
+			t.Errorf("wrong position at %04d. want=%s, got=%s", tt.offset, tt.expected, pos)
+		}
+	}
+}
+
+func TestOptimizations(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "2 * 3 + 4",
+			expectedConstants: []interface{}{10},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1; 1; 2; 1",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let x = 6; x + 2 * 3",
+			expectedConstants: []interface{}{6},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpAdd),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             `"a" + "b" + "c"`,
+			expectedConstants: []interface{}{"abc"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "1 < 2 == true; !(1 >= 2); !5; -(~0)",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+				code.Make(code.OpFalse),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "-(9223372036854775807 + 1)",
+			expectedConstants: []interface{}{-9223372036854775808},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// operations failing at runtime are left to the VM
+			input:             `1 / 0; "a" == "a"; 1 == true; 2 ** -1`,
+			expectedConstants: []interface{}{1, 0, "a", 0.5},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpDiv),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpEqual),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpTrue),
+				code.Make(code.OpEqual),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 3),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn() { 1 + 1 }; 2",
+			expectedConstants: []interface{}{
+				2,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTestsWith(t, tests, true)
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: `let f = fn(n) { if (n) { f(0) } else { len("") } }`,
+			expectedConstants: []interface{}{
+				0,
+				"",
+				[]code.Instructions{
+					// 0000
+					code.Make(code.OpGetLocal, 0),
+					// 0003
+					code.Make(code.OpJumpNotTruthy, 16),
+					// 0006
+					code.Make(code.OpCurrentClosure),
+					// 0007
+					code.Make(code.OpConstant, 0),
+					// 0010
+					code.Make(code.OpTailCall, 1),
+					// 0013
+					code.Make(code.OpJump, 25),
+					// 0016
+					code.Make(code.OpGetBuiltin, 0),
+					// 0019
+					code.Make(code.OpConstant, 1),
+					// 0022
+					code.Make(code.OpTailCall, 1),
+					// 0025
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+		{
+			input: `fn() { while (true) { len("") } }`,
+			expectedConstants: []interface{}{
+				"",
+				[]code.Instructions{
+					// 0000
+					code.Make(code.OpTrue),
+					// 0001
+					code.Make(code.OpJumpNotTruthy, 17),
+					// 0004
+					code.Make(code.OpGetBuiltin, 0),
+					// 0007
+					code.Make(code.OpConstant, 0),
+					// 0010
+					code.Make(code.OpCall, 1),
+					// 0013
+					code.Make(code.OpPop),
+					// 0014
+					code.Make(code.OpJump, 0),
+					// 0017
+					code.Make(code.OpNull),
+					// 0018
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// the main program has no frame to reuse
+			input:             `return len("");`,
+			expectedConstants: []interface{}{""},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetBuiltin, 0),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpCall, 1),
+				code.Make(code.OpReturnValue),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestTryStatements(t *testing.T) {
+	tests := []struct {
+		input        string
+		instructions []code.Instructions
+		handlers     []code.Handler
+	}{
+		{
+			input: `try { throw 1 } catch (e) { e } finally { 2 }`,
+			instructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpThrow),
+				// 0004
+				code.Make(code.OpConstant, 1),
+				// 0007
+				code.Make(code.OpPop),
+				// 0008
+				code.Make(code.OpJump, 30),
+				// 0011
+				code.Make(code.OpSetGlobal, 0),
+				// 0014
+				code.Make(code.OpGetGlobal, 0),
+				// 0017
+				code.Make(code.OpPop),
+				// 0018
+				code.Make(code.OpConstant, 2),
+				// 0021
+				code.Make(code.OpPop),
+				// 0022
+				code.Make(code.OpJump, 30),
+				// 0025
+				code.Make(code.OpConstant, 3),
+				// 0028
+				code.Make(code.OpPop),
+				// 0029
+				code.Make(code.OpThrow),
+			},
+			handlers: []code.Handler{
+				{Start: 0, End: 4, Target: 11, Height: 0},
+				{Start: 14, End: 18, Target: 25, Height: 0},
+			},
+		},
+		{
+			// the handler restores the array element below the try
+			input: `[1, if (true) { try { 2 } catch (e) { 3 } }]`,
+			instructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpTrue),
+				// 0004
+				code.Make(code.OpJumpNotTruthy, 25),
+				// 0007
+				code.Make(code.OpConstant, 1),
+				// 0010
+				code.Make(code.OpPop),
+				// 0011
+				code.Make(code.OpJump, 21),
+				// 0014
+				code.Make(code.OpSetGlobal, 0),
+				// 0017
+				code.Make(code.OpConstant, 2),
+				// 0020
+				code.Make(code.OpPop),
+				// 0021
+				code.Make(code.OpNull),
+				// 0022
+				code.Make(code.OpJump, 26),
+				// 0025
+				code.Make(code.OpNull),
+				// 0026
+				code.Make(code.OpArray, 2),
+				// 0029
+				code.Make(code.OpPop),
+			},
+			handlers: []code.Handler{
+				{Start: 7, End: 11, Target: 14, Height: 1},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		compiler := New()
+		compiler.SetOptimizations(false)
+		if err := compiler.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		bytecode := compiler.Bytecode()
+		if err := testInstructions(t, tt.input, tt.instructions, bytecode.Instructions); err != nil {
+			t.Fatalf("testInstructions failed: %s", err)
+		}
+		if !reflect.DeepEqual(bytecode.Handlers, tt.handlers) {
+			t.Errorf("%q: wrong handlers.\nwant=%+v\ngot =%+v", tt.input, tt.handlers, bytecode.Handlers)
+		}
+	}
+}
+
+func TestTryFinallyOnReturn(t *testing.T) {
+	// the finally block runs before the return, outside of the handler, and
+	// the call is not a tail call so that its exceptions reach the handler
+	compiler := New()
+	compiler.SetOptimizations(false)
+	if err := compiler.Compile(parse(`fn(f) { try { return f() } finally { 1 } }`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	fn, ok := compiler.Bytecode().Constants[3].(*object.CompiledFunction)
+	if !ok {
+		t.Fatalf("constant 3 is not a function. got=%T", compiler.Bytecode().Constants[3])
+	}
+	expected := []code.Instructions{
+		// 0000
+		code.Make(code.OpGetLocal, 0),
+		// 0003
+		code.Make(code.OpCall, 0),
+		// 0006
+		code.Make(code.OpConstant, 0),
+		// 0009
+		code.Make(code.OpPop),
+		// 0010
+		code.Make(code.OpReturnValue),
+		// 0011
+		code.Make(code.OpConstant, 1),
+		// 0014
+		code.Make(code.OpPop),
+		// 0015
+		code.Make(code.OpJump, 23),
+		// 0018
+		code.Make(code.OpConstant, 2),
+		// 0021
+		code.Make(code.OpPop),
+		// 0022
+		code.Make(code.OpThrow),
+		// 0023
+		code.Make(code.OpReturn),
+	}
+	if err := testInstructions(t, "function", expected, fn.Instructions); err != nil {
+		t.Fatalf("testInstructions failed: %s", err)
+	}
+	handlers := []code.Handler{{Start: 0, End: 6, Target: 18, Height: 0}}
+	if !reflect.DeepEqual(fn.Handlers, handlers) {
+		t.Errorf("wrong handlers.\nwant=%+v\ngot =%+v", handlers, fn.Handlers)
+	}
+}
+
+func TestPeephole(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "if (true) { 10 }; 20",
+			expectedConstants: []interface{}{10, 20},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpJump, 7),
+				// 0006
+				code.Make(code.OpNull),
+				// 0007
+				code.Make(code.OpPop),
+				// 0008
+				code.Make(code.OpConstant, 1),
+				// 0011
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "if (false) { 10 } else { 20 }",
+			expectedConstants: []interface{}{10, 20},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpJump, 9),
+				// 0003
+				code.Make(code.OpConstant, 0),
+				// 0006
+				code.Make(code.OpJump, 12),
+				// 0009
+				code.Make(code.OpConstant, 1),
+				// 0012
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input:             "let x = 1; if (!x) { 10 }",
+			expectedConstants: []interface{}{1, 10},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpSetGlobal, 0),
+				// 0006
+				code.Make(code.OpGetGlobal, 0),
+				// 0009
+				code.Make(code.OpJumpTruthy, 18),
+				// 0012
+				code.Make(code.OpConstant, 1),
+				// 0015
+				code.Make(code.OpJump, 19),
+				// 0018
+				code.Make(code.OpNull),
+				// 0019
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// the jumps to the loop condition continue at the loop end
+			input:             "while (true) { break; }",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpJump, 6),
+				// 0003
+				code.Make(code.OpJump, 6),
+				// 0006
+				code.Make(code.OpNull),
+				// 0007
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			input: "fn() { let a = 1; a }",
+			expectedConstants: []interface{}{
+				1,
+				[]code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpTeeLocal, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1, 0),
+				code.Make(code.OpPop),
+			},
+		},
+		{
+			// the loop jumps back to OpGetLocal, so it is kept
+			input: "fn() { let a = 1; while (a) { a = 0; } }",
+			expectedConstants: []interface{}{
+				1,
+				0,
+				[]code.Instructions{
+					// 0000
+					code.Make(code.OpConstant, 0),
+					// 0003
+					code.Make(code.OpSetLocal, 0),
+					// 0006
+					code.Make(code.OpGetLocal, 0),
+					// 0009
+					code.Make(code.OpJumpNotTruthy, 21),
+					// 0012
+					code.Make(code.OpConstant, 1),
+					// 0015
+					code.Make(code.OpSetLocal, 0),
+					// 0018
+					code.Make(code.OpJump, 6),
+					// 0021
+					code.Make(code.OpNull),
+					// 0022
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2, 0),
+				code.Make(code.OpPop),
+			},
+		},
+	}
+
+	runCompilerTestsWith(t, tests, true)
+}
@@ -0,0 +1,193 @@
+package compiler
+
+import (
+	"fmt"
+	"interpreter/ast"
+	"interpreter/code"
+	"interpreter/object"
+	"math"
+	"math/big"
+)
+
+// maxFoldBits limits the size of integers computed by ** and << at compile
+// time, larger results are left to the VM.
+const maxFoldBits = 4096
+
+// SetOptimizations turns constant folding, the sharing of equal constants
+// and the peephole pass on or off. All are on by default, turning them off
+// makes the bytecode follow the source more closely, which helps when
+// debugging the compiler.
+func (c *Compiler) SetOptimizations(enabled bool) {
+	c.optimize = enabled
+}
+
+// constantKey identifies the value of a constant that can be shared by all
+// instructions loading an equal constant.
+type constantKey struct {
+	typ   object.ObjectType
+	value string
+}
+
+func keyOf(obj object.Object) (constantKey, bool) {
+	switch obj := obj.(type) {
+	case *object.Integer, *object.BigInteger, *object.String:
+		return constantKey{obj.Type(), obj.Inspect()}, true
+	case *object.Float:
+		// by bits, so that 0.0 and -0.0 stay different constants
+		return constantKey{obj.Type(), fmt.Sprint(math.Float64bits(obj.Value))}, true
+	}
+	return constantKey{}, false
+}
+
+// emitFolded emits the instruction loading obj, the result of fold.
+func (c *Compiler) emitFolded(obj object.Object) {
+	if b, ok := obj.(*object.Boolean); ok {
+		if b.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+		return
+	}
+	c.emit(code.OpConstant, c.addConstant(obj))
+}
+
+// fold evaluates e at compile time if it only consists of integer, string
+// and boolean literals combined by operators. It gives up on operations that
+// fail at runtime, like 1 / 0 or "a" - "b", so that they still fail when the
+// program runs. The results are the same as those of the VM.
+func fold(e ast.Expression) (object.Object, bool) {
+	switch e := e.(type) {
+	case *ast.IntegerLiteral:
+		if e.Big != nil {
+			return object.NewInteger(e.Big), true
+		}
+		return &object.Integer{Value: e.Value}, true
+	case *ast.StringLiteral:
+		return &object.String{Value: e.Value}, true
+	case *ast.Boolean:
+		return &object.Boolean{Value: e.Value}, true
+	case *ast.PrefixExpression:
+		right, ok := fold(e.Right)
+		if !ok {
+			return nil, false
+		}
+		return foldPrefix(e.Operator, right)
+	case *ast.InfixExpression:
+		if e.Operator == "&&" || e.Operator == "||" {
+			return nil, false
+		}
+		left, ok := fold(e.Left)
+		if !ok {
+			return nil, false
+		}
+		right, ok := fold(e.Right)
+		if !ok {
+			return nil, false
+		}
+		return foldInfix(e.Operator, left, right)
+	}
+	return nil, false
+}
+
+func foldPrefix(operator string, right object.Object) (object.Object, bool) {
+	switch operator {
+	case "!":
+		if b, ok := right.(*object.Boolean); ok {
+			return &object.Boolean{Value: !b.Value}, true
+		}
+		// integers and strings are truthy
+		return &object.Boolean{Value: false}, true
+	case "-":
+		if v := object.ToBigInt(right); v != nil {
+			return object.NewInteger(new(big.Int).Neg(v)), true
+		}
+	case "~":
+		if v := object.ToBigInt(right); v != nil {
+			return object.NewInteger(new(big.Int).Not(v)), true
+		}
+	}
+	return nil, false
+}
+
+func foldInfix(operator string, left, right object.Object) (object.Object, bool) {
+	if lv, rv := object.ToBigInt(left), object.ToBigInt(right); lv != nil && rv != nil {
+		return foldIntegers(operator, lv, rv)
+	}
+	switch left := left.(type) {
+	case *object.String:
+		if right, ok := right.(*object.String); ok && operator == "+" {
+			return &object.String{Value: left.Value + right.Value}, true
+		}
+	case *object.Boolean:
+		right, ok := right.(*object.Boolean)
+		if !ok {
+			return nil, false
+		}
+		switch operator {
+		case "==":
+			return &object.Boolean{Value: left.Value == right.Value}, true
+		case "!=":
+			return &object.Boolean{Value: left.Value != right.Value}, true
+		}
+	}
+	return nil, false
+}
+
+func foldIntegers(operator string, lv, rv *big.Int) (object.Object, bool) {
+	result := new(big.Int)
+	switch operator {
+	case "+":
+		result.Add(lv, rv)
+	case "-":
+		result.Sub(lv, rv)
+	case "*":
+		result.Mul(lv, rv)
+	case "/":
+		if rv.Sign() == 0 {
+			return nil, false
+		}
+		result.Quo(lv, rv)
+	case "%":
+		if rv.Sign() == 0 {
+			return nil, false
+		}
+		result.Rem(lv, rv)
+	case "**":
+		if !rv.IsInt64() || int64(lv.BitLen())*rv.Int64() > maxFoldBits {
+			return nil, false
+		}
+		return object.PowInt(lv, rv.Int64()), true
+	case "&":
+		result.And(lv, rv)
+	case "|":
+		result.Or(lv, rv)
+	case "^":
+		result.Xor(lv, rv)
+	case "<<":
+		if rv.Sign() < 0 || !rv.IsInt64() || int64(lv.BitLen())+rv.Int64() > maxFoldBits {
+			return nil, false
+		}
+		result.Lsh(lv, uint(rv.Int64()))
+	case ">>":
+		if rv.Sign() < 0 || !rv.IsUint64() || rv.Uint64() > math.MaxUint32 {
+			return nil, false
+		}
+		result.Rsh(lv, uint(rv.Uint64()))
+	case "==":
+		return &object.Boolean{Value: lv.Cmp(rv) == 0}, true
+	case "!=":
+		return &object.Boolean{Value: lv.Cmp(rv) != 0}, true
+	case "<":
+		return &object.Boolean{Value: lv.Cmp(rv) < 0}, true
+	case "<=":
+		return &object.Boolean{Value: lv.Cmp(rv) <= 0}, true
+	case ">":
+		return &object.Boolean{Value: lv.Cmp(rv) > 0}, true
+	case ">=":
+		return &object.Boolean{Value: lv.Cmp(rv) >= 0}, true
+	default:
+		return nil, false
+	}
+	return object.NewInteger(result), true
+}
@@ -0,0 +1,138 @@
+package compiler
+
+import (
+	"interpreter/code"
+)
+
+// peepholeInstruction is an instruction of the scope being optimized.
+type peepholeInstruction struct {
+	op       code.Opcode
+	operands []int
+	// offset is the original offset of the instruction
+	offset int
+	// offsets holds the original offset of the instruction and those of
+	// the removed instructions in front of it, jumps to any of them
+	// continue at this instruction.
+	offsets []int
+}
+
+// peephole rewrites short instruction sequences of a finished scope:
+//
+//	OpTrue; OpJumpNotTruthy x    ->  (nothing)
+//	OpFalse; OpJumpNotTruthy x   ->  OpJump x
+//	OpBang; OpJumpNotTruthy x    ->  OpJumpTruthy x
+//	OpSetLocal i; OpGetLocal i   ->  OpTeeLocal i
+//
+// and makes jumps to an OpJump continue at its target right away. The
+// second instruction of a pair is kept if it is a jump target or a bound of
+// a handler. The jump targets, the source map and the handlers are moved to
+// the new offsets.
+func peephole(ins code.Instructions, sourceMap code.SourceMap, handlers []code.Handler) (code.Instructions, code.SourceMap, []code.Handler) {
+	targets := map[int]bool{}
+	for _, h := range handlers {
+		targets[h.Start] = true
+		targets[h.End] = true
+		targets[h.Target] = true
+	}
+	var decoded []peepholeInstruction
+	for i := 0; i < len(ins); {
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			// the compiler only emits defined opcodes
+			return ins, sourceMap, handlers
+		}
+		operands, read := code.ReadOperands(def, ins[i+1:])
+		op := code.Opcode(ins[i])
+		if code.IsJump(op) {
+			targets[operands[0]] = true
+		}
+		decoded = append(decoded, peepholeInstruction{op, operands, i, []int{i}})
+		i += 1 + read
+	}
+
+	var out []peepholeInstruction
+	// offsets of removed instructions waiting for the next one kept
+	var pending []int
+	for _, in := range decoded {
+		in.offsets = append(pending, in.offsets...)
+		pending = nil
+		if len(out) == 0 || isTarget(in, targets) {
+			out = append(out, in)
+			continue
+		}
+		last := &out[len(out)-1]
+		switch {
+		case last.op == code.OpTrue && in.op == code.OpJumpNotTruthy:
+			pending = append(last.offsets, in.offsets...)
+			out = out[:len(out)-1]
+		case last.op == code.OpFalse && in.op == code.OpJumpNotTruthy:
+			last.op, last.operands = code.OpJump, in.operands
+			last.offsets = append(last.offsets, in.offsets...)
+		case last.op == code.OpBang && in.op == code.OpJumpNotTruthy:
+			last.op, last.operands = code.OpJumpTruthy, in.operands
+			last.offsets = append(last.offsets, in.offsets...)
+		case last.op == code.OpSetLocal && in.op == code.OpGetLocal && last.operands[0] == in.operands[0]:
+			last.op = code.OpTeeLocal
+			last.offsets = append(last.offsets, in.offsets...)
+		default:
+			out = append(out, in)
+		}
+	}
+
+	// index maps the original offsets to the instructions in out
+	index := map[int]int{len(ins): len(out)}
+	for _, offset := range pending {
+		index[offset] = len(out)
+	}
+	for i, in := range out {
+		for _, offset := range in.offsets {
+			index[offset] = i
+		}
+	}
+
+	for i := range out {
+		if !code.IsJump(out[i].op) {
+			continue
+		}
+		target := out[i].operands[0]
+		// seen stops at jumps forming a loop
+		seen := map[int]bool{}
+		for j := index[target]; j < len(out) && out[j].op == code.OpJump && !seen[j]; j = index[target] {
+			seen[j] = true
+			target = out[j].operands[0]
+		}
+		out[i].operands = append([]int{target}, out[i].operands[1:]...)
+	}
+
+	newOffsets := make([]int, len(out)+1)
+	for i, in := range out {
+		newOffsets[i+1] = newOffsets[i] + len(code.Make(in.op, in.operands...))
+	}
+	optimized := code.Instructions{}
+	var optimizedMap code.SourceMap
+	for _, in := range out {
+		operands := in.operands
+		if code.IsJump(in.op) {
+			operands = append([]int{newOffsets[index[operands[0]]]}, operands[1:]...)
+		}
+		optimizedMap = optimizedMap.Add(len(optimized), sourceMap.Pos(in.offset))
+		optimized = append(optimized, code.Make(in.op, operands...)...)
+	}
+	var optimizedHandlers []code.Handler
+	for _, h := range handlers {
+		h.Start = newOffsets[index[h.Start]]
+		h.End = newOffsets[index[h.End]]
+		h.Target = newOffsets[index[h.Target]]
+		optimizedHandlers = append(optimizedHandlers, h)
+	}
+	return optimized, optimizedMap, optimizedHandlers
+}
+
+func isTarget(in peepholeInstruction, targets map[int]bool) bool {
+	for _, offset := range in.offsets {
+		if targets[offset] {
+			return true
+		}
+	}
+	return false
+}
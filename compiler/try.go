@@ -0,0 +1,173 @@
+package compiler
+
+import (
+	"interpreter/ast"
+	"interpreter/code"
+)
+
+// tryBlock is a try or catch block being compiled. The exceptions thrown by
+// its instructions go to the handler of its try statement, see
+// compileTryStatement.
+type tryBlock struct {
+	// finally is the finally block of the try statement, if any, that a
+	// return, break or continue leaving the block has to run
+	finally *ast.BlockStatement
+	// ranges holds the covered instructions, start the beginning of the
+	// range being compiled or -1 while the block is interrupted by a
+	// finally block inlined at a return, break or continue
+	ranges []code.Handler
+	start  int
+}
+
+func (t *tryBlock) interrupt(offset int) {
+	if t.start >= 0 && t.start < offset {
+		t.ranges = append(t.ranges, code.Handler{Start: t.start, End: offset})
+	}
+	t.start = -1
+}
+
+func (t *tryBlock) resume(offset int) {
+	if t.start < 0 {
+		t.start = offset
+	}
+}
+
+// handlers returns the handlers of the ranges of t continuing at target.
+func (t *tryBlock) handlers(target, height int) []code.Handler {
+	handlers := make([]code.Handler, len(t.ranges))
+	for i, r := range t.ranges {
+		handlers[i] = code.Handler{Start: r.Start, End: r.End, Target: target, Height: height}
+	}
+	return handlers
+}
+
+// compileTryStatement lays out a try statement as
+//
+//	body                 exceptions go to CATCH, or FINALLY without catch
+//	finally
+//	OpJump END
+//	CATCH:               the exception is on the stack
+//	store the exception
+//	catch                exceptions go to FINALLY
+//	finally
+//	OpJump END
+//	FINALLY:             the exception is on the stack
+//	finally
+//	OpThrow
+//	END:
+//
+// leaving out the parts of a missing catch or finally block. Returns, breaks
+// and continues leaving the body or the catch block run a copy of the
+// finally block first.
+func (c *Compiler) compileTryStatement(node *ast.TryStatement) error {
+	// the values below the statement, like the iterators of for-in loops
+	// or the operands waiting for an if expression, stay on the stack
+	height := c.scopes[c.scopeIndex].height
+	var handlers []code.Handler
+	var ends []int
+
+	body, err := c.compileTryBlock(node.Body, node.Finally)
+	if err != nil {
+		return err
+	}
+	ends = append(ends, c.emit(code.OpJump, placeholder))
+	// protected holds the exceptions going to the finally block
+	protected := body
+
+	if node.Catch != nil {
+		handlers = append(handlers, body.handlers(len(c.currentInstructions()), height)...)
+		c.scopes[c.scopeIndex].height = height + 1
+		c.storeSymbol(c.symbolTable.Define(node.Param.Value))
+		if node.Finally == nil {
+			err := c.Compile(node.Catch)
+			if err != nil {
+				return err
+			}
+		} else {
+			protected, err = c.compileTryBlock(node.Catch, node.Finally)
+			if err != nil {
+				return err
+			}
+			ends = append(ends, c.emit(code.OpJump, placeholder))
+		}
+	}
+
+	if node.Finally != nil {
+		handlers = append(handlers, protected.handlers(len(c.currentInstructions()), height)...)
+		c.scopes[c.scopeIndex].height = height + 1
+		err := c.Compile(node.Finally)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
+	}
+
+	end := len(c.currentInstructions())
+	for _, pos := range ends {
+		c.changeOperand(pos, end)
+	}
+	scope := &c.scopes[c.scopeIndex]
+	scope.handlers = append(scope.handlers, handlers...)
+	scope.height = height
+	// the statement leaves no value, a pop at the end of a block in it
+	// must not be taken for that of an expression statement
+	scope.lastInstruction = EmittedInstruction{}
+	return nil
+}
+
+// compileTryBlock compiles block, covered by a new try block, followed by
+// finally if it is not nil.
+func (c *Compiler) compileTryBlock(block, finally *ast.BlockStatement) (*tryBlock, error) {
+	t := &tryBlock{finally: finally, start: len(c.currentInstructions())}
+	scope := &c.scopes[c.scopeIndex]
+	scope.tries = append(scope.tries, t)
+
+	err := c.Compile(block)
+	if err != nil {
+		return nil, err
+	}
+
+	scope = &c.scopes[c.scopeIndex]
+	scope.tries = scope.tries[:len(scope.tries)-1]
+	t.interrupt(len(c.currentInstructions()))
+	if finally != nil {
+		err := c.Compile(finally)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// leaveTries compiles the finally blocks of the try blocks from the
+// innermost one down to the one at index n of the scope, for a return,
+// break or continue leaving them. The try blocks are interrupted until
+// resumeTries, so that the finally blocks are not covered by their own
+// handlers.
+func (c *Compiler) leaveTries(n int) error {
+	tries := c.scopes[c.scopeIndex].tries
+	for i := len(tries) - 1; i >= n; i-- {
+		if tries[i].finally == nil {
+			continue
+		}
+		for _, t := range tries[i:] {
+			t.interrupt(len(c.currentInstructions()))
+		}
+		// a return in the finally block leaves the outer try blocks only
+		c.scopes[c.scopeIndex].tries = tries[:i]
+		err := c.Compile(tries[i].finally)
+		c.scopes[c.scopeIndex].tries = tries
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resumeTries continues the try blocks from the one at index n of the scope
+// on after leaveTries.
+func (c *Compiler) resumeTries(n int) {
+	for _, t := range c.scopes[c.scopeIndex].tries[n:] {
+		t.resume(len(c.currentInstructions()))
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected string
+	}{
+		{"a\nb\n", "a\nb\n", ""},
+		{
+			"a\nb\nc\n",
+			"a\nB\nc\n",
+			"--- f.orig\n+++ f\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+		},
+		{
+			"1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			"0\n1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n",
+			"--- f.orig\n+++ f\n@@ -1,3 +1,4 @@\n+0\n 1\n 2\n 3\n@@ -9,4 +10,3 @@\n 9\n 10\n 11\n-12\n",
+		},
+		{
+			"",
+			"x\n",
+			"--- f.orig\n+++ f\n@@ -0,0 +1 @@\n+x\n",
+		},
+	}
+	for _, tt := range tests {
+		diff := unifiedDiff("f", tt.a, tt.b)
+		if diff != tt.expected {
+			t.Errorf("wrong diff.\nwant=%q\ngot =%q", tt.expected, diff)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"interpreter/disasm"
+	"os"
+	"path/filepath"
+)
+
+func runDisasm(args []string) int {
+	flags := flag.NewFlagSet("disasm", flag.ContinueOnError)
+	noOpt := flags.Bool("N", false, "disable optimizations when compiling source files")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: monkey disasm [-N] file%s|file%s\n", sourceExt, bytecodeExt)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	path := flags.Arg(0)
+	bytecode, err := loadFile(path, !*noOpt)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	source := path
+	if filepath.Ext(path) == bytecodeExt {
+		source = bytecode.Filename()
+	}
+	// the source is optional, the listing just lacks the source lines
+	src, _ := os.ReadFile(source)
+	fmt.Print(disasm.Bytecode(bytecode, string(src)))
+	return 0
+}
@@ -0,0 +1,219 @@
+// Package disasm renders compiled Monkey bytecode in human readable form.
+package disasm
+
+import (
+	"fmt"
+	"interpreter/code"
+	"interpreter/compiler"
+	"interpreter/object"
+	"sort"
+	"strings"
+)
+
+// Bytecode returns the disassembly of bytecode: the main program followed by
+// every compiled function, each function after the function creating it.
+// Operands referring to constants and builtins are followed by their values.
+// Jump targets and the bounds and targets of exception handlers get labels,
+// the handlers are listed after the instructions. If src is the source code
+// bytecode was compiled from, each source line is printed in front of the
+// instructions compiled from it.
+//
+//	main program:
+//	     1| let double = fn(x) { x * 2 };
+//	        0000  OpClosure 0 0           ; function 0
+//	        0004  OpSetGlobal 0
+func Bytecode(bytecode *compiler.Bytecode, src string) string {
+	p := &printer{
+		constants: bytecode.Constants,
+		numFree:   map[int]int{},
+		printed:   map[int]bool{},
+	}
+	if src != "" {
+		p.lines = strings.Split(src, "\n")
+	}
+	p.function("main program", bytecode.Instructions, bytecode.SourceMap, bytecode.Handlers)
+	// functions that are never turned into closures
+	for i, c := range p.constants {
+		if fn, ok := c.(*object.CompiledFunction); ok && !p.printed[i] {
+			p.compiledFunction(i, fn)
+		}
+	}
+	return p.out.String()
+}
+
+type printer struct {
+	out       strings.Builder
+	constants []object.Object
+	lines     []string
+	// numFree holds the number of free variables of the functions turned
+	// into closures so far.
+	numFree map[int]int
+	printed map[int]bool
+}
+
+func (p *printer) compiledFunction(index int, fn *object.CompiledFunction) {
+	p.printed[index] = true
+	title := fmt.Sprintf("function %d (params=%d, locals=%d", index, fn.NumParameters, fn.NumLocals)
+	if n, ok := p.numFree[index]; ok {
+		title += fmt.Sprintf(", free=%d", n)
+	}
+	p.function(title+")", fn.Instructions, fn.SourceMap, fn.Handlers)
+}
+
+func (p *printer) function(title string, ins code.Instructions, sourceMap code.SourceMap, handlers []code.Handler) {
+	if p.out.Len() > 0 {
+		p.out.WriteString("\n")
+	}
+	p.out.WriteString(title + ":\n")
+	labels := jumpLabels(ins, handlers)
+	closures := p.listing(ins, sourceMap, labels)
+	if len(handlers) > 0 {
+		p.out.WriteString("      handlers:\n")
+		for _, h := range handlers {
+			fmt.Fprintf(&p.out, "        %s-%s -> %s (height %d)\n", labels[h.Start], labels[h.End], labels[h.Target], h.Height)
+		}
+	}
+	for _, index := range closures {
+		if index >= len(p.constants) || p.printed[index] {
+			continue
+		}
+		if fn, ok := p.constants[index].(*object.CompiledFunction); ok {
+			p.compiledFunction(index, fn)
+		}
+	}
+}
+
+// listing prints the instructions and returns the constant indexes of the
+// functions they turn into closures.
+func (p *printer) listing(ins code.Instructions, sourceMap code.SourceMap, labels map[int]string) []int {
+	var closures []int
+	line := 0
+	for i := 0; i < len(ins); {
+		if label, ok := labels[i]; ok {
+			fmt.Fprintf(&p.out, "      %s:\n", label)
+		}
+		if pos := sourceMap.Pos(i); pos.IsValid() && pos.Line != line {
+			line = pos.Line
+			if line <= len(p.lines) {
+				fmt.Fprintf(&p.out, "%6d| %s\n", line, strings.TrimRight(p.lines[line-1], "\r"))
+			}
+		}
+
+		def, operands, next, err := decode(ins, i)
+		if err != nil {
+			fmt.Fprintf(&p.out, "        %04d  ERROR: %s\n", i, err)
+			i = next
+			continue
+		}
+
+		text := def.Name
+		for _, o := range operands {
+			text += fmt.Sprintf(" %d", o)
+		}
+		comment := p.comment(code.Opcode(ins[i]), operands, labels)
+		if comment != "" {
+			text = fmt.Sprintf("%-24s; %s", text, comment)
+		}
+		fmt.Fprintf(&p.out, "        %04d  %s\n", i, text)
+
+		if code.Opcode(ins[i]) == code.OpClosure {
+			index, numFree := operands[0], operands[1]
+			if _, ok := p.numFree[index]; !ok {
+				p.numFree[index] = numFree
+			}
+			closures = append(closures, index)
+		}
+		i = next
+	}
+	if label, ok := labels[len(ins)]; ok {
+		fmt.Fprintf(&p.out, "      %s:\n", label)
+	}
+	return closures
+}
+
+// comment describes the operands of an instruction.
+func (p *printer) comment(op code.Opcode, operands []int, labels map[int]string) string {
+	switch {
+	case code.IsJump(op):
+		return labels[operands[0]]
+	case op == code.OpConstant:
+		return p.constant(operands[0])
+	case op == code.OpClosure:
+		if operands[0] < len(p.constants) {
+			if _, ok := p.constants[operands[0]].(*object.CompiledFunction); ok {
+				return fmt.Sprintf("function %d", operands[0])
+			}
+		}
+		return "not a function"
+	case op == code.OpGetBuiltin:
+		if operands[0] < len(object.Builtins) {
+			return object.Builtins[operands[0]].Name
+		}
+		return "undefined builtin"
+	}
+	return ""
+}
+
+func (p *printer) constant(index int) string {
+	if index >= len(p.constants) {
+		return "undefined constant"
+	}
+	switch c := p.constants[index].(type) {
+	case *object.String:
+		return fmt.Sprintf("%q", c.Value)
+	case *object.CompiledFunction:
+		return fmt.Sprintf("function %d", index)
+	default:
+		return c.Inspect()
+	}
+}
+
+// jumpLabels names the jump targets in ins and the offsets handlers refer to
+// L0, L1, ... in the order of their offsets.
+func jumpLabels(ins code.Instructions, handlers []code.Handler) map[int]string {
+	var targets []int
+	seen := map[int]bool{}
+	add := func(target int) {
+		if !seen[target] {
+			seen[target] = true
+			targets = append(targets, target)
+		}
+	}
+	for i := 0; i < len(ins); {
+		_, operands, next, err := decode(ins, i)
+		if err == nil && code.IsJump(code.Opcode(ins[i])) {
+			add(operands[0])
+		}
+		i = next
+	}
+	for _, h := range handlers {
+		add(h.Start)
+		add(h.End)
+		add(h.Target)
+	}
+	sort.Ints(targets)
+	labels := map[int]string{}
+	for n, target := range targets {
+		labels[target] = fmt.Sprintf("L%d", n)
+	}
+	return labels
+}
+
+// decode reads the instruction at offset i and returns the offset of the
+// next one. Undefined opcodes are skipped, truncated instructions end the
+// instructions.
+func decode(ins code.Instructions, i int) (*code.Definition, []int, int, error) {
+	def, err := code.Lookup(ins[i])
+	if err != nil {
+		return nil, nil, i + 1, err
+	}
+	width := 0
+	for _, w := range def.OperandWiths {
+		width += w
+	}
+	if i+1+width > len(ins) {
+		return nil, nil, len(ins), fmt.Errorf("%s is truncated", def.Name)
+	}
+	operands, read := code.ReadOperands(def, ins[i+1:])
+	return def, operands, i + 1 + read, nil
+}
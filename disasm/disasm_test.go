@@ -0,0 +1,157 @@
+package disasm
+
+import (
+	"interpreter/code"
+	"interpreter/compiler"
+	"interpreter/lexer"
+	"interpreter/object"
+	"interpreter/parser"
+	"testing"
+)
+
+func compile(t *testing.T, input string) *compiler.Bytecode {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	c := compiler.New()
+	if err := c.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return c.Bytecode()
+}
+
+func TestBytecode(t *testing.T) {
+	input := `let f = fn(x) {
+  let g = fn() { x };
+  g()
+};
+if (len("ab") > 1) { f(1.5) } else { 0 };
+`
+	expected := `main program:
+     1| let f = fn(x) {
+        0000  OpClosure 1 0           ; function 1
+        0005  OpSetGlobal 0
+     5| if (len("ab") > 1) { f(1.5) } else { 0 };
+        0008  OpGetBuiltin 0          ; len
+        0011  OpConstant 2            ; "ab"
+        0014  OpCall 1
+        0017  OpConstant 3            ; 1
+        0020  OpGreaterThan
+        0021  OpJumpNotTruthy 36      ; L0
+        0024  OpGetGlobal 0
+        0027  OpConstant 4            ; 1.5
+        0030  OpCall 1
+        0033  OpJump 39               ; L1
+      L0:
+        0036  OpConstant 5            ; 0
+      L1:
+        0039  OpPop
+
+function 1 (params=1, locals=2, free=0):
+     2|   let g = fn() { x };
+        0000  OpCaptureLocal 0
+        0003  OpClosure 0 1           ; function 0
+        0008  OpTeeLocal 1
+     3|   g()
+        0011  OpTailCall 0
+     1| let f = fn(x) {
+        0014  OpReturnValue
+
+function 0 (params=0, locals=0, free=1):
+     2|   let g = fn() { x };
+        0000  OpGetFree 0
+        0003  OpReturnValue
+`
+	actual := Bytecode(compile(t, input), input)
+	if actual != expected {
+		t.Errorf("wrong disassembly.\nwant=\n%s\ngot=\n%s", expected, actual)
+	}
+}
+
+func TestBytecodeWithoutSource(t *testing.T) {
+	bytecode := compile(t, "let x = 1; while (x) { if (!x) { break; } }")
+	expected := `main program:
+        0000  OpConstant 0            ; 1
+        0003  OpSetGlobal 0
+      L0:
+        0006  OpGetGlobal 0
+        0009  OpJumpNotTruthy 30      ; L3
+        0012  OpGetGlobal 0
+        0015  OpJumpTruthy 25         ; L1
+        0018  OpJump 30               ; L3
+        0021  OpNull
+        0022  OpJump 26               ; L2
+      L1:
+        0025  OpNull
+      L2:
+        0026  OpPop
+        0027  OpJump 6                ; L0
+      L3:
+        0030  OpNull
+        0031  OpPop
+`
+	actual := Bytecode(bytecode, "")
+	if actual != expected {
+		t.Errorf("wrong disassembly.\nwant=\n%s\ngot=\n%s", expected, actual)
+	}
+}
+
+func TestBytecodeHandlers(t *testing.T) {
+	bytecode := compile(t, `try { throw "x" } catch (e) { 1 } finally { 2 }`)
+	expected := `main program:
+      L0:
+        0000  OpConstant 0            ; "x"
+        0003  OpThrow
+      L1:
+        0004  OpConstant 1            ; 2
+        0007  OpPop
+        0008  OpJump 30               ; L6
+      L2:
+        0011  OpSetGlobal 0
+      L3:
+        0014  OpConstant 2            ; 1
+        0017  OpPop
+      L4:
+        0018  OpConstant 1            ; 2
+        0021  OpPop
+        0022  OpJump 30               ; L6
+      L5:
+        0025  OpConstant 1            ; 2
+        0028  OpPop
+        0029  OpThrow
+      L6:
+      handlers:
+        L0-L1 -> L2 (height 0)
+        L3-L4 -> L5 (height 0)
+`
+	actual := Bytecode(bytecode, "")
+	if actual != expected {
+		t.Errorf("wrong disassembly.\nwant=\n%s\ngot=\n%s", expected, actual)
+	}
+}
+
+func TestBytecodeMalformed(t *testing.T) {
+	bytecode := &compiler.Bytecode{
+		Instructions: append(append(code.Instructions{255},
+			code.Make(code.OpConstant, 7)...),
+			code.Make(code.OpClosure, 0, 0)[:3]...),
+		Constants: []object.Object{
+			&object.CompiledFunction{Instructions: code.Make(code.OpReturn)},
+		},
+	}
+	expected := `main program:
+        0000  ERROR: opcode 255 undefined
+        0001  OpConstant 7            ; undefined constant
+        0004  ERROR: OpClosure is truncated
+
+function 0 (params=0, locals=0):
+        0000  OpReturn
+`
+	actual := Bytecode(bytecode, "")
+	if actual != expected {
+		t.Errorf("wrong disassembly.\nwant=\n%s\ngot=\n%s", expected, actual)
+	}
+}
@@ -4,12 +4,18 @@ import (
 	"fmt"
 	"interpreter/ast"
 	"interpreter/object"
+	"interpreter/token"
+	"math"
+	"math/big"
+	"strings"
 )
 
 var (
-	NULL  = &object.Null{}
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
+	NULL     = &object.Null{}
+	TRUE     = &object.Boolean{Value: true}
+	FALSE    = &object.Boolean{Value: false}
+	BREAK    = &object.Break{}
+	CONTINUE = &object.Continue{}
 )
 
 var builtins = map[string]*object.Builtin{
@@ -19,6 +25,10 @@ var builtins = map[string]*object.Builtin{
 	"last":  object.GetBuiltinByName("last"),
 	"rest":  object.GetBuiltinByName("rest"),
 	"push":  object.GetBuiltinByName("push"),
+	"int":   object.GetBuiltinByName("int"),
+	"float": object.GetBuiltinByName("float"),
+	"round": object.GetBuiltinByName("round"),
+	"range": object.GetBuiltinByName("range"),
 }
 
 func Eval(node ast.Node, env *object.Environment) object.Object {
@@ -28,30 +38,38 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.ExpressionStatement:
 		return Eval(node.Expression, env)
 	case *ast.IntegerLiteral:
+		if node.Big != nil {
+			return object.NewInteger(node.Big)
+		}
 		return &object.Integer{Value: node.Value}
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 	case *ast.PrefixExpression:
 		right := Eval(node.Right, env)
-		if isError(right) {
+		if isAbrupt(right) {
 			return right
 		}
-		return evalPrefixExpression(node.Operator, right)
+		return locate(evalPrefixExpression(node.Operator, right), node.Pos())
 	case *ast.InfixExpression:
-		right := Eval(node.Right, env)
-		if isError(right) {
-			return right
+		if node.Operator == "&&" || node.Operator == "||" {
+			return evalLogicalExpression(node, env)
 		}
 		left := Eval(node.Left, env)
-		if isError(left) {
+		if isAbrupt(left) {
 			return left
 		}
-		return evalInfixExpression(node.Operator, left, right)
+		right := Eval(node.Right, env)
+		if isAbrupt(right) {
+			return right
+		}
+		return locate(evalInfixExpression(node.Operator, left, right), node.Pos())
 	case *ast.IfExpression:
 		val := Eval(node.Condition, env)
-		if isError(val) {
+		if isAbrupt(val) {
 			return val
 		}
 		cond := isTruthy(val)
@@ -63,67 +81,93 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		}
 		return NULL
 	case *ast.ReturnStatement:
-		val := Eval(node.ReturnValue, env)
-		if isError(val) {
+		val := evalTail(node.ReturnValue, env)
+		if isAbrupt(val) {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
 	case *ast.BlockStatement:
 		return evalBlockStatements(node.Statements, env)
+	case *ast.AssignStatement:
+		return locate(evalAssignStatement(node, env), node.Pos())
+	case *ast.WhileStatement:
+		return evalWhileStatement(node, env)
+	case *ast.ForStatement:
+		return evalForStatement(node, env)
+	case *ast.BreakStatement:
+		return BREAK
+	case *ast.ContinueStatement:
+		return CONTINUE
+	case *ast.TryStatement:
+		return evalTryStatement(node, env)
+	case *ast.ThrowStatement:
+		val := Eval(node.Value, env)
+		if isAbrupt(val) {
+			return val
+		}
+		return throw(val, node.Pos())
 	case *ast.LetStatement:
 		val := Eval(node.Value, env)
-		if isError(val) {
+		if isAbrupt(val) {
 			return val
 		}
 		env.Set(node.Name.Value, val)
 		return val
 	case *ast.Identifier:
-		return evalIdentifier(node, env)
+		return locate(evalIdentifier(node, env), node.Pos())
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
 		return &object.Function{Parameters: params, Body: body, Env: env}
+	case *ast.MacroLiteral:
+		return newError("macro literals must be bound by a top-level let statement")
 	case *ast.CallExpression:
+		if isCallTo(node, "quote") {
+			if len(node.Arguments) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(node.Arguments))
+			}
+			return quote(node.Arguments[0], env)
+		}
 		fun := Eval(node.Function, env)
-		if isError(fun) {
+		if isAbrupt(fun) {
 			return fun
 		}
 		args := evalExpressions(node.Arguments, env)
-		if len(args) == 1 && isError(args[0]) {
+		if len(args) == 1 && isAbrupt(args[0]) {
 			return args[0]
 		}
-		return applyFunction(fun, args)
+		return addCallSite(applyFunction(fun, args), node.Pos())
 	case *ast.ArrayLiteral:
 		elms := evalExpressions(node.Elements, env)
-		if len(elms) == 1 && isError(elms[0]) {
+		if len(elms) == 1 && isAbrupt(elms[0]) {
 			return elms[0]
 		}
 		return &object.Array{Elements: elms}
 	case *ast.IndexExpression:
 		left := Eval(node.Left, env)
-		if isError(left) {
+		if isAbrupt(left) {
 			return left
 		}
 		index := Eval(node.Index, env)
-		if isError(index) {
+		if isAbrupt(index) {
 			return index
 		}
-		return evalIndexExpression(left, index)
+		return locate(evalIndexExpression(left, index), node.Pos())
 	case *ast.HashLiteral:
 		result := &object.Hash{}
 		result.Pairs = make(map[object.HashKey]object.HashPair)
 		for key, val := range node.Pairs {
 			k := Eval(key, env)
-			if isError(k) {
+			if isAbrupt(k) {
 				return k
 			}
 			v := Eval(val, env)
-			if isError(v) {
+			if isAbrupt(v) {
 				return v
 			}
 			hashKey, ok := k.(object.Hashable)
 			if !ok {
-				return newError("unusable as hash key: %s", k.Type())
+				return locate(newError("unusable as hash key: %s", k.Type()), node.Pos())
 			}
 			result.Pairs[hashKey.HashKey()] = object.HashPair{Key: k, Value: v}
 		}
@@ -132,16 +176,49 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	return nil
 }
 
+// evalLogicalExpression evaluates && and || and only evaluates the right
+// operand if the left one does not determine the result.
+func evalLogicalExpression(node *ast.InfixExpression, env *object.Environment) object.Object {
+	left := Eval(node.Left, env)
+	if isAbrupt(left) {
+		return left
+	}
+	if node.Operator == "&&" && !isTruthy(left) {
+		return FALSE
+	}
+	if node.Operator == "||" && isTruthy(left) {
+		return TRUE
+	}
+	right := Eval(node.Right, env)
+	if isAbrupt(right) {
+		return right
+	}
+	return nativeBoolToBooleanObject(isTruthy(right))
+}
+
 func evalIndexExpression(left, index object.Object) object.Object {
 	switch left.Type() {
 	case object.ARRAY_OBJ:
 		return evalArrayIndexExpression(left, index)
 	case object.HASH_OBJ:
 		return evalHashIndexExpression(left, index)
+	case object.EXCEPTION_OBJ:
+		return evalExceptionIndexExpression(left, index)
 	}
 	return newError("index operator not supported %s", left.Type())
 }
 
+func evalExceptionIndexExpression(left, index object.Object) object.Object {
+	key, ok := index.(*object.String)
+	if !ok {
+		return newError("index must be string: %s", index.Type())
+	}
+	if field, ok := left.(*object.Exception).Field(key.Value); ok {
+		return field
+	}
+	return NULL
+}
+
 func evalHashIndexExpression(left, index object.Object) object.Object {
 	hash := left.(*object.Hash)
 	key, ok := index.(object.Hashable)
@@ -157,9 +234,13 @@ func evalHashIndexExpression(left, index object.Object) object.Object {
 
 func evalArrayIndexExpression(array, index object.Object) object.Object {
 	if array.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ {
-		idx := index.(*object.Integer).Value
 		elms := array.(*object.Array).Elements
-		if v := int(idx); v >= len(elms) || v < 0 {
+		i, ok := index.(*object.Integer)
+		if !ok {
+			return NULL
+		}
+		idx := i.Value
+		if idx >= int64(len(elms)) || idx < 0 {
 			return NULL
 		}
 		return elms[idx]
@@ -167,25 +248,187 @@ func evalArrayIndexExpression(array, index object.Object) object.Object {
 	return newError("index operator not supported %s", array.Type())
 }
 
+// evalAssignStatement assigns the value of as to its target and returns
+// NULL, assignments leave no value as in the VM, or an error.
+func evalAssignStatement(as *ast.AssignStatement, env *object.Environment) object.Object {
+	// compound operators like += apply their arithmetic operator to the
+	// current value of the target
+	op := strings.TrimSuffix(as.Operator, "=")
+	switch target := as.Target.(type) {
+	case *ast.Identifier:
+		var current object.Object
+		if op != "" {
+			current = evalIdentifier(target, env)
+			if isError(current) {
+				return current
+			}
+		}
+		val := Eval(as.Value, env)
+		if isAbrupt(val) {
+			return val
+		}
+		if op != "" {
+			val = evalInfixExpression(op, current, val)
+			if isError(val) {
+				return val
+			}
+		}
+		if !env.Assign(target.Value, val) {
+			if _, ok := builtins[target.Value]; ok {
+				return newError("cannot assign to builtin %s", target.Value)
+			}
+			return newError("cannot assign to undeclared variable %s", target.Value)
+		}
+		return NULL
+	case *ast.IndexExpression:
+		left := Eval(target.Left, env)
+		if isAbrupt(left) {
+			return left
+		}
+		index := Eval(target.Index, env)
+		if isAbrupt(index) {
+			return index
+		}
+		var current object.Object
+		if op != "" {
+			current = evalIndexExpression(left, index)
+			if isError(current) {
+				return current
+			}
+		}
+		val := Eval(as.Value, env)
+		if isAbrupt(val) {
+			return val
+		}
+		if op != "" {
+			val = evalInfixExpression(op, current, val)
+			if isError(val) {
+				return val
+			}
+		}
+		return evalIndexAssignment(left, index, val)
+	}
+	return newError("cannot assign to %s", as.Target)
+}
+
+func evalIndexAssignment(left, index, val object.Object) object.Object {
+	switch left := left.(type) {
+	case *object.Array:
+		if big, ok := index.(*object.BigInteger); ok {
+			return newError("index out of range: %s", big.Inspect())
+		}
+		i, ok := index.(*object.Integer)
+		if !ok {
+			return newError("array index must be INTEGER, got %s", index.Type())
+		}
+		if i.Value < 0 || i.Value >= int64(len(left.Elements)) {
+			return newError("index out of range: %d", i.Value)
+		}
+		left.Elements[i.Value] = val
+		return NULL
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", index.Type())
+		}
+		left.Pairs[key.HashKey()] = object.HashPair{Key: index, Value: val}
+		return NULL
+	}
+	return newError("index assignment not supported: %s", left.Type())
+}
+
 func applyFunction(fn object.Object, args []object.Object) object.Object {
-	switch fn := fn.(type) {
-	case *object.Function:
-		extendedEnv := extendFunctionEnv(fn, args)
-		evaluated := Eval(fn.Body, extendedEnv)
-		return unwrapReturnValue(evaluated)
-	case *object.Builtin:
-		if result := fn.Fn(args...); result != nil {
-			return result
+	for {
+		switch f := fn.(type) {
+		case *object.Function:
+			extendedEnv := extendFunctionEnv(f, args)
+			evaluated := unwrapReturnValue(evalTail(f.Body, extendedEnv))
+			// make the calls in tail position here instead of nesting them
+			if tc, ok := evaluated.(*object.TailCall); ok {
+				fn, args = tc.Fn, tc.Args
+				continue
+			}
+			return evaluated
+		case *object.Builtin:
+			if result := f.Fn(args...); result != nil {
+				return result
+			}
+			return NULL
+		default:
+			return newError("not a function %s", fn.Type())
+		}
+	}
+}
+
+// evalTail evaluates node, whose value is returned by the function it is
+// part of. Calls of functions in tail position, directly or in a branch of
+// an if expression, are not made but returned as a *object.TailCall.
+func evalTail(node ast.Node, env *object.Environment) object.Object {
+	switch node := node.(type) {
+	case *ast.BlockStatement:
+		if len(node.Statements) == 0 {
+			return Eval(node, env)
+		}
+		last := len(node.Statements) - 1
+		result := evalBlockStatements(node.Statements[:last], env)
+		if result != nil {
+			switch result.Type() {
+			case object.RETURN_VALUE_OBJ, object.ERROR_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
+				return result
+			}
+		}
+		return evalTail(node.Statements[last], env)
+	case *ast.ExpressionStatement:
+		return evalTail(node.Expression, env)
+	case *ast.IfExpression:
+		val := Eval(node.Condition, env)
+		if isAbrupt(val) {
+			return val
+		}
+		if isTruthy(val) {
+			return evalTail(node.Consequence, env)
+		}
+		if node.Alternative != nil {
+			return evalTail(node.Alternative, env)
 		}
 		return NULL
-	default:
-		return newError("not a function %s", fn.Type())
+	case *ast.CallExpression:
+		if isCallTo(node, "quote") {
+			break
+		}
+		fun := Eval(node.Function, env)
+		if isAbrupt(fun) {
+			return fun
+		}
+		args := evalExpressions(node.Arguments, env)
+		if len(args) == 1 && isAbrupt(args[0]) {
+			return args[0]
+		}
+		if fn, ok := fun.(*object.Function); ok {
+			return &object.TailCall{Fn: fn, Args: args, Pos: node.Pos()}
+		}
+		return addCallSite(applyFunction(fun, args), node.Pos())
 	}
+	return Eval(node, env)
+}
+
+// callTail makes the call obj stands for if it is a *object.TailCall, which
+// return statements outside of functions produce.
+func callTail(obj object.Object) object.Object {
+	if tc, ok := obj.(*object.TailCall); ok {
+		return addCallSite(applyFunction(tc.Fn, tc.Args), tc.Pos)
+	}
+	return obj
 }
 
 func unwrapReturnValue(obj object.Object) object.Object {
-	if rv, ok := obj.(*object.ReturnValue); ok {
-		return rv.Value
+	switch obj := obj.(type) {
+	case *object.ReturnValue:
+		return obj.Value
+	case *object.Break:
+		return newError("break outside loop")
+	case *object.Continue:
+		return newError("continue outside loop")
 	}
 	return obj
 }
@@ -202,7 +445,7 @@ func evalExpressions(expression []ast.Expression, env *object.Environment) []obj
 	var result []object.Object
 	for _, e := range expression {
 		evaluated := Eval(e, env)
-		if isError(evaluated) {
+		if isAbrupt(evaluated) {
 			return []object.Object{evaluated}
 		}
 		result = append(result, evaluated)
@@ -245,6 +488,8 @@ func evalInfixExpression(op string, left, right object.Object) object.Object {
 	switch {
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		return evalIntegerInfixExpression(op, left, right)
+	case isNumber(left) && isNumber(right):
+		return evalFloatInfixExpression(op, left, right)
 	case left.Type() == object.BOOLEAN_OBJ && right.Type() == object.BOOLEAN_OBJ:
 		return evalBooleanInfixExpression(op, left, right)
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
@@ -281,21 +526,160 @@ func evalBooleanInfixExpression(op string, left, right object.Object) object.Obj
 }
 
 func evalIntegerInfixExpression(op string, left object.Object, right object.Object) object.Object {
-	lv := left.(*object.Integer).Value
-	rv := right.(*object.Integer).Value
+	l, lok := left.(*object.Integer)
+	r, rok := right.(*object.Integer)
+	if !lok || !rok {
+		return evalBigIntegerInfixExpression(op, left, right)
+	}
+	lv := l.Value
+	rv := r.Value
+	switch op {
+	case "-":
+		return object.SubInt64(lv, rv)
+	case "+":
+		return object.AddInt64(lv, rv)
+	case "*":
+		return object.MulInt64(lv, rv)
+	case "/":
+		if rv == 0 {
+			return newError("division by zero")
+		}
+		return object.DivInt64(lv, rv)
+	case "%":
+		if rv == 0 {
+			return newError("modulo by zero")
+		}
+		return &object.Integer{Value: lv % rv}
+	case "**":
+		if object.PowTooLarge(big.NewInt(lv), rv) {
+			return newError("exponent too large: %d", rv)
+		}
+		return object.PowInt(big.NewInt(lv), rv)
+	case "&":
+		return &object.Integer{Value: lv & rv}
+	case "|":
+		return &object.Integer{Value: lv | rv}
+	case "^":
+		return &object.Integer{Value: lv ^ rv}
+	case "<<":
+		if rv < 0 {
+			return newError("negative shift count: %d", rv)
+		}
+		if rv > math.MaxUint32 || object.ShlTooLarge(big.NewInt(lv), uint64(rv)) {
+			return newError("shift count too large: %d", rv)
+		}
+		return object.ShlInt64(lv, uint64(rv))
+	case ">>":
+		if rv < 0 {
+			return newError("negative shift count: %d", rv)
+		}
+		return &object.Integer{Value: lv >> uint64(rv)}
+	case ">":
+		return nativeBoolToBooleanObject(lv > rv)
+	case "<":
+		return nativeBoolToBooleanObject(lv < rv)
+	case ">=":
+		return nativeBoolToBooleanObject(lv >= rv)
+	case "<=":
+		return nativeBoolToBooleanObject(lv <= rv)
+	case "==":
+		return nativeBoolToBooleanObject(lv == rv)
+	case "!=":
+		return nativeBoolToBooleanObject(lv != rv)
+	default:
+		return newError("unknown operator: %s %s %s",
+			left.Type(), op, right.Type())
+	}
+}
+func evalBigIntegerInfixExpression(op string, left object.Object, right object.Object) object.Object {
+	lv := object.ToBigInt(left)
+	rv := object.ToBigInt(right)
 	switch op {
 	case "-":
-		return &object.Integer{Value: lv - rv}
+		return object.NewInteger(new(big.Int).Sub(lv, rv))
 	case "+":
-		return &object.Integer{Value: lv + rv}
+		return object.NewInteger(new(big.Int).Add(lv, rv))
 	case "*":
-		return &object.Integer{Value: lv * rv}
+		return object.NewInteger(new(big.Int).Mul(lv, rv))
 	case "/":
-		return &object.Integer{Value: lv / rv}
+		if rv.Sign() == 0 {
+			return newError("division by zero")
+		}
+		return object.NewInteger(new(big.Int).Quo(lv, rv))
+	case "%":
+		if rv.Sign() == 0 {
+			return newError("modulo by zero")
+		}
+		return object.NewInteger(new(big.Int).Rem(lv, rv))
+	case "**":
+		if !rv.IsInt64() || object.PowTooLarge(lv, rv.Int64()) {
+			return newError("exponent too large: %s", rv)
+		}
+		return object.PowInt(lv, rv.Int64())
+	case "&":
+		return object.NewInteger(new(big.Int).And(lv, rv))
+	case "|":
+		return object.NewInteger(new(big.Int).Or(lv, rv))
+	case "^":
+		return object.NewInteger(new(big.Int).Xor(lv, rv))
+	case "<<", ">>":
+		if rv.Sign() < 0 {
+			return newError("negative shift count: %s", rv)
+		}
+		if !rv.IsUint64() || rv.Uint64() > math.MaxUint32 {
+			return newError("shift count too large: %s", rv)
+		}
+		if op == "<<" && object.ShlTooLarge(lv, rv.Uint64()) {
+			return newError("shift count too large: %s", rv)
+		}
+		if op == "<<" {
+			return object.NewInteger(new(big.Int).Lsh(lv, uint(rv.Uint64())))
+		}
+		return object.NewInteger(new(big.Int).Rsh(lv, uint(rv.Uint64())))
+	case ">":
+		return nativeBoolToBooleanObject(lv.Cmp(rv) > 0)
+	case "<":
+		return nativeBoolToBooleanObject(lv.Cmp(rv) < 0)
+	case ">=":
+		return nativeBoolToBooleanObject(lv.Cmp(rv) >= 0)
+	case "<=":
+		return nativeBoolToBooleanObject(lv.Cmp(rv) <= 0)
+	case "==":
+		return nativeBoolToBooleanObject(lv.Cmp(rv) == 0)
+	case "!=":
+		return nativeBoolToBooleanObject(lv.Cmp(rv) != 0)
+	default:
+		return newError("unknown operator: %s %s %s",
+			left.Type(), op, right.Type())
+	}
+}
+
+// evalFloatInfixExpression handles float operands as well as a float mixed
+// with an integer, in which case the integer is promoted to a float.
+func evalFloatInfixExpression(op string, left object.Object, right object.Object) object.Object {
+	lv := object.ToFloat(left)
+	rv := object.ToFloat(right)
+	switch op {
+	case "-":
+		return &object.Float{Value: lv - rv}
+	case "+":
+		return &object.Float{Value: lv + rv}
+	case "*":
+		return &object.Float{Value: lv * rv}
+	case "/":
+		return &object.Float{Value: lv / rv}
+	case "%":
+		return &object.Float{Value: math.Mod(lv, rv)}
+	case "**":
+		return &object.Float{Value: math.Pow(lv, rv)}
 	case ">":
 		return nativeBoolToBooleanObject(lv > rv)
 	case "<":
 		return nativeBoolToBooleanObject(lv < rv)
+	case ">=":
+		return nativeBoolToBooleanObject(lv >= rv)
+	case "<=":
+		return nativeBoolToBooleanObject(lv <= rv)
 	case "==":
 		return nativeBoolToBooleanObject(lv == rv)
 	case "!=":
@@ -305,21 +689,41 @@ func evalIntegerInfixExpression(op string, left object.Object, right object.Obje
 			left.Type(), op, right.Type())
 	}
 }
+
+func isNumber(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
+
 func evalPrefixExpression(op string, right object.Object) object.Object {
 	switch op {
 	case "!":
 		return evalBangOperator(right)
 	case "-":
 		return evalMinusOperator(right)
+	case "~":
+		return evalBitNotOperator(right)
 	}
 	return newError("unknown operator: %s%s", op, right.Type())
 }
 func evalMinusOperator(right object.Object) object.Object {
-	if right.Type() != object.INTEGER_OBJ {
-		return newError("unknown operator: -%s", right.Type())
+	switch right := right.(type) {
+	case *object.Integer:
+		return object.NegInt64(right.Value)
+	case *object.BigInteger:
+		return object.NewInteger(new(big.Int).Neg(right.Value))
+	case *object.Float:
+		return &object.Float{Value: -right.Value}
 	}
-	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
+	return newError("unknown operator: -%s", right.Type())
+}
+func evalBitNotOperator(right object.Object) object.Object {
+	switch right := right.(type) {
+	case *object.Integer:
+		return &object.Integer{Value: ^right.Value}
+	case *object.BigInteger:
+		return object.NewInteger(new(big.Int).Not(right.Value))
+	}
+	return newError("unknown operator: ~%s", right.Type())
 }
 func evalBangOperator(right object.Object) object.Object {
 	switch right {
@@ -338,10 +742,13 @@ func evalProgram(statements []ast.Statement, env *object.Environment) object.Obj
 	var result object.Object
 	for _, statement := range statements {
 		result = Eval(statement, env)
-		if result.Type() == object.RETURN_VALUE_OBJ {
-			return result.(*object.ReturnValue).Value
+		if result == nil {
+			continue
 		}
-		if result.Type() == object.ERROR_OBJ {
+		switch result.Type() {
+		case object.RETURN_VALUE_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
+			return callTail(unwrapReturnValue(result))
+		case object.ERROR_OBJ:
 			return result
 		}
 	}
@@ -351,15 +758,161 @@ func evalBlockStatements(statements []ast.Statement, env *object.Environment) ob
 	var result object.Object
 	for _, statement := range statements {
 		result = Eval(statement, env)
-		if result != nil && result.Type() == object.RETURN_VALUE_OBJ {
-			return result
+		if result == nil {
+			continue
 		}
-		if result.Type() == object.ERROR_OBJ {
+		switch result.Type() {
+		case object.RETURN_VALUE_OBJ, object.ERROR_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
 			return result
 		}
 	}
 	return result
 }
+func evalWhileStatement(ws *ast.WhileStatement, env *object.Environment) object.Object {
+	for {
+		cond := Eval(ws.Condition, env)
+		if isAbrupt(cond) {
+			return cond
+		}
+		if !isTruthy(cond) {
+			return NULL
+		}
+		if result, done := evalLoopBody(ws.Body, env); done {
+			return result
+		}
+	}
+}
+func evalForStatement(fs *ast.ForStatement, env *object.Environment) object.Object {
+	iterable := Eval(fs.Iterable, env)
+	if isAbrupt(iterable) {
+		return iterable
+	}
+	it, ok := object.NewIterator(iterable)
+	if !ok {
+		return locate(newError("not iterable: %s", iterable.Type()), fs.Pos())
+	}
+	for {
+		if fs.Key != nil {
+			key, value, ok := it.Next()
+			if !ok {
+				return NULL
+			}
+			env.Set(fs.Key.Value, key)
+			env.Set(fs.Value.Value, value)
+		} else {
+			value, ok := it.NextElement()
+			if !ok {
+				return NULL
+			}
+			env.Set(fs.Value.Value, value)
+		}
+		if result, done := evalLoopBody(fs.Body, env); done {
+			return result
+		}
+	}
+}
+
+// evalLoopBody evaluates one iteration of a loop and reports whether the
+// loop is done, in which case the returned object is the loop's result.
+func evalLoopBody(body *ast.BlockStatement, env *object.Environment) (object.Object, bool) {
+	result := Eval(body, env)
+	if result == nil {
+		return nil, false
+	}
+	switch result.Type() {
+	case object.RETURN_VALUE_OBJ, object.ERROR_OBJ:
+		return result, true
+	case object.BREAK_OBJ:
+		return NULL, true
+	}
+	return nil, false
+}
+
+// evalTryStatement runs the try block and, if it fails, the catch block with
+// the error bound to its parameter as an *object.Exception. The finally block
+// runs in any case, if it returns, breaks, continues or fails, that replaces
+// the outcome of the other blocks.
+func evalTryStatement(ts *ast.TryStatement, env *object.Environment) object.Object {
+	result := callReturnedTail(Eval(ts.Body, env))
+	if err, ok := result.(*object.Error); ok && ts.Catch != nil {
+		env.Set(ts.Param.Value, &object.Exception{Message: err.Message, Stack: err.Stack, Value: err.Value})
+		result = Eval(ts.Catch, env)
+		if ts.Finally != nil {
+			result = callReturnedTail(result)
+		}
+	}
+	if ts.Finally != nil {
+		if final := Eval(ts.Finally, env); isAbrupt(final) {
+			return final
+		}
+	}
+	if isAbrupt(result) {
+		return result
+	}
+	return NULL
+}
+
+// callReturnedTail makes the call returned by a return statement in obj, if
+// there is one, so that its errors are caught and it is made before the
+// finally block runs.
+func callReturnedTail(obj object.Object) object.Object {
+	rv, ok := obj.(*object.ReturnValue)
+	if !ok {
+		return obj
+	}
+	tc, ok := rv.Value.(*object.TailCall)
+	if !ok {
+		return obj
+	}
+	val := addCallSite(applyFunction(tc.Fn, tc.Args), tc.Pos)
+	if isError(val) {
+		return val
+	}
+	return &object.ReturnValue{Value: val}
+}
+
+// isAbrupt reports whether obj ends the evaluation of the enclosing
+// expressions and statements: an error, or the signal of a return, break or
+// continue, e.g. the break in 1 + if (c) { break }.
+func isAbrupt(obj object.Object) bool {
+	switch obj.(type) {
+	case *object.ReturnValue, *object.Error, *object.Break, *object.Continue:
+		return true
+	}
+	return false
+}
+
+// throw returns the error a throw statement at pos raises for val: a
+// string thrown is the message, other values are inspected. A caught
+// exception is thrown again with its stack trace and value.
+func throw(val object.Object, pos token.Position) object.Object {
+	switch val := val.(type) {
+	case *object.Exception:
+		return &object.Error{Message: val.Message, Stack: append([]string{}, val.Stack...), Value: val.Value}
+	case *object.String:
+		return locate(&object.Error{Message: val.Value, Value: val}, pos)
+	}
+	return locate(&object.Error{Message: val.Inspect(), Value: val}, pos)
+}
+
+// locate records pos as the position an error was raised at, unless it has
+// one already.
+func locate(obj object.Object, pos token.Position) object.Object {
+	if err, ok := obj.(*object.Error); ok && len(err.Stack) == 0 && pos.IsValid() {
+		err.Stack = []string{pos.String()}
+	}
+	return obj
+}
+
+// addCallSite appends the position of the call an error comes out of to its
+// stack trace.
+func addCallSite(obj object.Object, pos token.Position) object.Object {
+	if err, ok := obj.(*object.Error); ok && pos.IsValid() {
+		err.Stack = append(err.Stack, pos.String())
+	}
+	return obj
+}
+
 func nativeBoolToBooleanObject(input bool) *object.Boolean {
 	if input {
 		return TRUE
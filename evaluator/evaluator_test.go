@@ -1,6 +1,7 @@
 package evaluator
 
 import (
+	"fmt"
 	"interpreter/lexer"
 	"interpreter/object"
 	"interpreter/parser"
@@ -54,6 +55,106 @@ func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
 	return false
 }
 
+func TestBigIntegers(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		{"-9223372036854775807 - 2", "-9223372036854775809"},
+		{"4611686018427387904 * 4", "18446744073709551616"},
+		{"let f = fn(n) { if (n == 0) { 1 } else { n * f(n - 1) } }; f(25)", "15511210043330985984000000"},
+		{"let f = fn(n) { if (n == 0) { 1 } else { n * f(n - 1) } }; f(25) / f(23)", "600"},
+		{"-(9223372036854775807 + 1)", "-9223372036854775808"},
+		{"(9223372036854775807 + 1) > 9223372036854775807", "true"},
+		{"(9223372036854775807 + 1) == (9223372036854775807 + 1)", "true"},
+		{"(9223372036854775807 + 1) * 0.5", "4.611686018427388e+18"},
+		{"int(\"100000000000000000000\")", "100000000000000000000"},
+		{"[1, 2][9223372036854775807 + 1]", "null"},
+		{"9223372036854775808", "9223372036854775808"},
+		{"-9223372036854775808", "-9223372036854775808"},
+		{"18446744073709551616 - 1", "18446744073709551615"},
+		{"let x = 100000000000000000000; x / 10", "10000000000000000000"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testObject(t, evaluated, tt.expected)
+	}
+	if _, ok := testEval("-9223372036854775808").(*object.Integer); !ok {
+		t.Errorf("negated literal fitting into int64 was not demoted")
+	}
+	if _, ok := testEval("(9223372036854775807 + 1) - 1").(*object.Integer); !ok {
+		t.Errorf("result fitting into int64 was not demoted")
+	}
+}
+
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"1.5", 1.5},
+		{"-2.5", -2.5},
+		{"1.5 + 1.5", 3},
+		{"0.25 * 3", 0.75},
+		{"1 + 0.5", 1.5},
+		{"0.5 + 1", 1.5},
+		{"7 / 2.0", 3.5},
+		{"2 * (1.25 - 1)", 0.5},
+		{"float(3) / 2", 1.5},
+		{"float(\"2.5\")", 2.5},
+		{"round(2.345, 2)", 2.35},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testFloatObject(t, evaluated, tt.expected)
+	}
+
+	comparisons := []struct {
+		input    string
+		expected bool
+	}{
+		{"1.5 < 2", true},
+		{"2 > 1.5", true},
+		{"1 == 1.0", true},
+		{"1.5 != 1.5", false},
+	}
+	for _, tt := range comparisons {
+		evaluated := testEval(tt.input)
+		testBooleanObject(t, tt.input, evaluated, tt.expected)
+	}
+
+	conversions := []struct {
+		input    string
+		expected int64
+	}{
+		{"int(2.9)", 2},
+		{"int(-2.9)", -2},
+		{"int(\"42\")", 42},
+		{"round(2.5)", 3},
+		{"round(-2.5)", -3},
+		{"round(7)", 7},
+	}
+	for _, tt := range conversions {
+		evaluated := testEval(tt.input)
+		testIntegerObject(t, evaluated, tt.expected)
+	}
+}
+
+func testFloatObject(t *testing.T, obj object.Object, expected float64) bool {
+	t.Helper()
+	result, ok := obj.(*object.Float)
+	if !ok {
+		t.Errorf("object is not Float. got=%T (%+v)", obj, obj)
+		return false
+	}
+	if result.Value != expected {
+		t.Errorf("object has wrong value. got=%g, want=%g",
+			result.Value, expected)
+	}
+	return true
+}
+
 func TestEvalBooleanExpression(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -99,6 +200,80 @@ func testBooleanObject(t *testing.T, name string, obj object.Object, expected bo
 	return true
 }
 
+func TestLogicalAndComparisonOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"1 <= 2", true},
+		{"2 <= 2", true},
+		{"3 <= 2", false},
+		{"1 >= 2", false},
+		{"2 >= 2", true},
+		{"1.5 >= 1", true},
+		{"1 <= 0.5", false},
+		{"true && true", true},
+		{"true && false", false},
+		{"false && true", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 && 2", true},
+		{"if (false) { 1 } || 0", true},
+		{"1 < 2 && 2 < 3", true},
+		{"1 > 2 || 2 > 3", false},
+		{"false && undefined", false},
+		{"true || undefined", true},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testBooleanObject(t, tt.input, evaluated, tt.expected)
+	}
+
+	evaluated := testEval("true && undefined")
+	errObj, ok := evaluated.(*object.Error)
+	if !ok || errObj.Message != "identifier not found: undefined" {
+		t.Errorf("right operand of && was not evaluated. got=%+v", evaluated)
+	}
+}
+
+func TestArithmeticAndBitwiseOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"7 % 3", "1"},
+		{"-7 % 3", "-1"},
+		{"7.5 % 2", "1.5"},
+		{"2 ** 10", "1024"},
+		{"2 ** 3 ** 2", "512"},
+		{"-2 ** 2", "-4"},
+		{"2 ** -1", "0.5"},
+		{"2 ** 0.5 * 2 ** 0.5", "2.0000000000000004"},
+		{"2 ** 64", "18446744073709551616"},
+		{"(2 ** 64) % 10", "6"},
+		{"12 & 10", "8"},
+		{"12 | 10", "14"},
+		{"12 ^ 10", "6"},
+		{"~5", "-6"},
+		{"~(2 ** 64)", "-18446744073709551617"},
+		{"(2 ** 64) & (2 ** 64 + 1)", "18446744073709551616"},
+		{"1 << 4", "16"},
+		{"1 << 64", "18446744073709551616"},
+		{"(1 << 64) >> 60", "16"},
+		{"-16 >> 2", "-4"},
+		{"1 >> 70", "0"},
+		{"1 + 2 * 3 % 4", "3"},
+		{"1 ** 99999999999", "1"},
+		{"(1 << 16777215) >> 16777215", "1"},
+		{"((1 << 64) << 16777151) >> 16777214", "2"},
+		{"0 << 4294967295", "0"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testObject(t, evaluated, tt.expected)
+	}
+}
+
 func TestBangOperator(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -141,6 +316,114 @@ func TestIfElseExpressions(t *testing.T) {
 	}
 }
 
+func TestAssignStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x = 1; x = 2; x", "2"},
+		{"let x = 10; x -= 3; x *= 2; x /= 7; x", "2"},
+		{"let x = \"a\"; x += \"b\"; x", "ab"},
+		{"let i = 0; while (true) { i += 1; if (i == 5) { break; } } i", "5"},
+		{"let i = 0; let sum = 0; while (i < 10) { i += 1; if (i % 2 == 0) { continue; } sum += i; } sum", "25"},
+		{"let sum = 0; for (x in range(101)) { sum += x; } sum", "5050"},
+		{"let c = 0; for (i in range(3)) { for (j in range(3)) { if (j == i) { continue; } c += 1; } } c", "6"},
+		{"let f = fn() { let n = 0; for (x in [1, 2, 3]) { n += x; } n }; f()", "6"},
+		{"let f = fn(n) { n = n * 2; n }; f(21)", "42"},
+		{"let g = 1; let f = fn() { g += 1; }; f(); f(); g", "3"},
+		{"let a = [1, 2, 3]; a[1] = 20; a[2] += 10; a", "[1, 20, 13]"},
+		{"let h = {\"a\": 1}; h[\"b\"] = 2; h[\"a\"] *= 5; [h[\"a\"], h[\"b\"]]", "[5, 2]"},
+		{"let a = [1]; let b = a; b[0] = 9; a[0]", "9"},
+		{"let m = [[0, 0], [0, 0]]; m[1][0] = 7; m", "[[0, 0], [7, 0]]"},
+		{"let calls = 0; let i = fn() { calls += 1; 0 }; let a = [5]; a[i()] += 1; [a[0], calls]", "[6, 1]"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestMutableClosures(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let mk = fn() { let n = 0; fn() { n += 1; n } }; let c = mk(); c(); c(); c()", "3"},
+		{"let mk = fn() { let n = 0; fn() { n += 1; n } }; let a = mk(); let b = mk(); a(); a(); b(); [a(), b()]", "[3, 2]"},
+		{"let pair = fn() { let n = 0; [fn() { n += 1; }, fn() { n }] }; let p = pair(); p[0](); p[0](); p[1]()", "2"},
+		{"fn() { let n = 0; let inc = fn() { n += 1; }; inc(); inc(); n }()", "2"},
+		{"fn() { let n = 1; let get = fn() { n }; n = 5; get() }()", "5"},
+		{"fn() { let n = 0; let f = fn() { fn() { n += 10; } }; f()(); f()(); n }()", "20"},
+		{"fn(n) { let add = fn(x) { n += x; }; add(2); add(3); n }(1)", "6"},
+		{"let fs = []; fn() { for (i in range(3)) { fs = push(fs, fn() { i }); } }(); [fs[0](), fs[2]()]", "[2, 2]"},
+		{"let gen = fn() { let i = 0; fn() { i += 1; i * i } }; let g = gen(); g(); g(); g()", "9"},
+		{"let memo = fn(f) { let cache = {}; let calls = 0; [fn(n) { let v = cache[n]; if (!v) { calls += 1; v = f(n); cache[n] = v; } v }, fn() { calls }] }; let m = memo(fn(x) { x * 2 }); m[0](2); m[0](2); [m[0](3), m[1]()]", "[6, 2]"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestWhileStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"fn() { while (false) { 1 } 5 }()", 5},
+		{"fn() { while (true) { break; } 5 }()", 5},
+		{"fn() { while (true) { return 3; } }()", 3},
+		{"fn() { while (true) { if (true) { break; } return 1; } 2 }()", 2},
+		{"fn() { while (true) { while (true) { break; } return 4; } }()", 4},
+		{"while (false) { }", nil},
+		{"let f = fn(n) { while (n > 0) { return f(n - 1) + 1; } 0 }; f(10)", 10},
+		{"let x = 7; while (true) { let y = x * 2; break; } y", 14},
+		{"let n = 0; let i = 0; while (i < 5) { i += 1; n = n + if (i % 2 == 0) { continue; } else { i }; } n", 9},
+		{"let i = 0; while (true) { i += 1; 1 + if (i == 3) { break; } else { 0 }; } i", 3},
+		{"let i = 0; while (i < 3) { i += 1; puts(if (i > 0) { continue; }); } i", 3},
+		{"fn() { [1, if (true) { return 7; }] }()", 7},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		integer, ok := tt.expected.(int)
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestForStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"fn() { for (x in [1, 2, 3]) { if (x > 1) { return x; } } }()", "2"},
+		{"fn() { for (i, x in [5, 6, 7]) { if (x == 6) { return i; } } }()", "1"},
+		{"fn() { for (x in []) { return 1; } 0 }()", "0"},
+		{"fn() { for (k in {\"b\": 2, \"a\": 1}) { return k; } }()", "a"},
+		{"fn() { for (k, v in {3: \"c\", -1: \"a\", 2: \"b\"}) { return [k, v]; } }()", "[-1, a]"},
+		{"fn() { for (i, c in \"h\\u{e9}llo\") { if (i == 1) { return c; } } }()", "\u00e9"},
+		{"fn() { for (x in range(10, 0, -3)) { if (x < 5) { return x; } } }()", "4"},
+		{"fn() { for (i, x in range(3)) { if (i == 2) { return x; } } }()", "2"},
+		{"fn() { for (x in range(3)) { if (x == 0) { continue; } return x; } }()", "1"},
+		{"fn() { for (x in [1, 2]) { for (y in [3, 4]) { break; } return x; } }()", "1"},
+		{"fn() { for (x in [1, 2]) { break; } 9 }()", "9"},
+		{"for (x in [1, 2, 3]) { } x", "3"},
+		{"len(range(0, 10, 3))", "4"},
+		{"len(range(5, 0))", "0"},
+		{"len(range(-9223372036854775808, 9223372036854775807))", "18446744073709551615"},
+		{"len(range(-9223372036854775808, 9223372036854775807, 2))", "9223372036854775808"},
+		{"range(1, 5)", "range(1, 5)"},
+		{"range(5, 1, -2)", "range(5, 1, -2)"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testObject(t, evaluated, tt.expected)
+	}
+}
+
 func testNullObject(t *testing.T, obj object.Object) bool {
 	t.Helper()
 	if obj != NULL {
@@ -182,6 +465,102 @@ func TestErrorHandling(t *testing.T) {
 			"-true",
 			"unknown operator: -BOOLEAN",
 		},
+		{
+			"5 % 0",
+			"modulo by zero",
+		},
+		{
+			"3 ** 99999999999",
+			"exponent too large: 99999999999",
+		},
+		{
+			"(2 ** 64) ** 99999999",
+			"exponent too large: 99999999",
+		},
+		{
+			"1 << 16777216",
+			"shift count too large: 16777216",
+		},
+		{
+			"(1 << 64) << 16777152",
+			"shift count too large: 16777152",
+		},
+		{
+			"-1 << 4294967295",
+			"shift count too large: 4294967295",
+		},
+		{
+			"break;",
+			"break outside loop",
+		},
+		{
+			"x = 1",
+			"cannot assign to undeclared variable x",
+		},
+		{
+			"len = 1",
+			"cannot assign to builtin len",
+		},
+		{
+			"let a = [1]; a[1] = 2",
+			"index out of range: 1",
+		},
+		{
+			"let s = \"ab\"; s[0] = \"c\"",
+			"index assignment not supported: STRING",
+		},
+		{
+			"for (x in 5) { x }",
+			"not iterable: INTEGER",
+		},
+		{
+			"range(1, 2, 0)",
+			"step of `range` must not be zero",
+		},
+		{
+			"range(2 ** 63)",
+			"argument to `range` out of range: 9223372036854775808",
+		},
+		{
+			"range(1, 2.5)",
+			"argument to `range` must be INTEGER, got FLOAT",
+		},
+		{
+			"round(1.5, -(2 ** 63) - 1)",
+			"second argument to `round` out of range: -9223372036854775809",
+		},
+		{
+			"let a = [1]; a[2 ** 64] = 2",
+			"index out of range: 18446744073709551616",
+		},
+		{
+			"while (true) { fn() { continue; }() }",
+			"continue outside loop",
+		},
+		{
+			"(2 ** 64) % 0",
+			"modulo by zero",
+		},
+		{
+			"let a = 0; 5 / a",
+			"division by zero",
+		},
+		{
+			"(2 ** 64) / 0",
+			"division by zero",
+		},
+		{
+			"1 << -1",
+			"negative shift count: -1",
+		},
+		{
+			"~1.5",
+			"unknown operator: ~FLOAT",
+		},
+		{
+			"1.5 & 1",
+			"unknown operator: FLOAT & INTEGER",
+		},
 		{
 			"true + false;",
 			"unknown operator: BOOLEAN + BOOLEAN",
@@ -290,6 +669,74 @@ func TestClosures(t *testing.T) {
 	testIntegerObject(t, testEval(input), 4)
 }
 
+func TestTailCalls(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let countdown = fn(n) { if (n == 0) { 0 } else { countdown(n - 1) } }; countdown(100000)", 0},
+		{"let countdown = fn(n) { if (n == 0) { return 0; } return countdown(n - 1); }; countdown(100000)", 0},
+		{
+			`let even = fn(n) { if (n == 0) { 1 } else { odd(n - 1) } };
+			let odd = fn(n) { if (n == 0) { 0 } else { even(n - 1) } };
+			even(100001)`,
+			0,
+		},
+		{"let sum = fn(n, acc) { if (n == 0) { acc } else { sum(n - 1, acc + n) } }; sum(100000, 0)", 5000050000},
+		{"let f = fn(n) { n * 2 }; return f(21);", 42},
+		{"let f = fn() { len([1, 2]) }; f() + 1", 3},
+		{"let f = fn(n) { while (true) { return g(n); } }; let g = fn(n) { n + 1 }; f(1)", 2},
+	}
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestTryStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let f = fn() { try { throw "oops" } catch (e) { return e["message"] } }; f()`, "oops"},
+		{`let f = fn() { try { 1 / 0 } catch (e) { return e } }; f()`, "exception: division by zero"},
+		{`let f = fn() { try { return 1 } finally { return 2 } }; f()`, "2"},
+		{`let x = 0; try { x = 1 } finally { x = x + 1 }; x`, "2"},
+		{`let x = 0; try { throw 1 } catch (e) { x = x + 1 } finally { x = x * 10 }; x`, "10"},
+		{`let f = fn() { throw "f" }; let g = fn() { try { f() } catch (e) { return e["stack"] } }; g()`, "[1:16, 1:50]"},
+		{`let f = fn() { try { return f } catch (e) { 1 } }; try { 1 } catch (e) { 2 }`, "null"},
+	}
+	for _, tt := range tests {
+		testObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestUncaughtExceptions(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+		expectedStack   []string
+	}{
+		{`throw "oops"`, "oops", []string{"1:1"}},
+		{`throw [1, 2]`, "[1, 2]", []string{"1:1"}},
+		{`let f = fn() { 1 / 0 }; f()`, "division by zero", []string{"1:16", "1:25"}},
+		{`try { throw 1 } finally { 2 }`, "1", []string{"1:7"}},
+		{`try { throw 1 } catch (e) { throw e }`, "1", []string{"1:7"}},
+	}
+	for _, tt := range tests {
+		errObj, ok := testEval(tt.input).(*object.Error)
+		if !ok {
+			t.Errorf("%q: no error object returned", tt.input)
+			continue
+		}
+		if errObj.Message != tt.expectedMessage {
+			t.Errorf("%q: wrong error message. expected=%q, got=%q", tt.input, tt.expectedMessage, errObj.Message)
+		}
+		if fmt.Sprint(errObj.Stack) != fmt.Sprint(tt.expectedStack) {
+			t.Errorf("%q: wrong stack. expected=%v, got=%v", tt.input, tt.expectedStack, errObj.Stack)
+		}
+	}
+}
+
 func TestStringLiteral(t *testing.T) {
 	input := `"Hello World!"`
 	evaluated := testEval(input)
@@ -535,6 +982,22 @@ func TestHashIndexExpressions(t *testing.T) {
 			`{false: 5}[false]`,
 			5,
 		},
+		{
+			`{1: 5}[1.0]`,
+			5,
+		},
+		{
+			`{0.0: 5}[-0.0]`,
+			5,
+		},
+		{
+			`{1.5: 5}[1.5]`,
+			5,
+		},
+		{
+			`{1: 5}[1.5]`,
+			nil,
+		},
 	}
 	for _, tt := range tests {
 		evaluated := testEval(tt.input)
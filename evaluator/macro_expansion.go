@@ -0,0 +1,119 @@
+package evaluator
+
+import (
+	"fmt"
+	"interpreter/ast"
+	"interpreter/object"
+)
+
+// DefineMacros removes all top-level `let name = macro(...)` statements
+// from program and binds the macros in env.
+func DefineMacros(program *ast.Program, env *object.Environment) {
+	definitions := []int{}
+	for i, statement := range program.Statements {
+		if isMacroDefinition(statement) {
+			addMacro(statement, env)
+			definitions = append(definitions, i)
+		}
+	}
+	for i := len(definitions) - 1; i >= 0; i-- {
+		idx := definitions[i]
+		program.Statements = append(program.Statements[:idx], program.Statements[idx+1:]...)
+	}
+}
+
+func isMacroDefinition(node ast.Statement) bool {
+	let, ok := node.(*ast.LetStatement)
+	if !ok {
+		return false
+	}
+	_, ok = let.Value.(*ast.MacroLiteral)
+	return ok
+}
+
+func addMacro(stmt ast.Statement, env *object.Environment) {
+	let := stmt.(*ast.LetStatement)
+	lit := let.Value.(*ast.MacroLiteral)
+	macro := &object.Macro{
+		Parameters: lit.Parameters,
+		Body:       lit.Body,
+		Env:        env,
+	}
+	env.Set(let.Name.Value, macro)
+}
+
+// ExpandMacros replaces every call of a macro defined in env by the node the
+// macro returns. The arguments are passed to the macro unevaluated, as
+// quoted nodes, and the macro has to return a quoted node itself.
+func ExpandMacros(program ast.Node, env *object.Environment) (ast.Node, error) {
+	var err error
+	expanded := ast.Modify(program, func(node ast.Node) ast.Node {
+		if err != nil {
+			return node
+		}
+		call, ok := node.(*ast.CallExpression)
+		if !ok {
+			return node
+		}
+		macro, ok := isMacroCall(call, env)
+		if !ok {
+			return node
+		}
+		if len(call.Arguments) != len(macro.Parameters) {
+			err = fmt.Errorf("%s: wrong number of arguments to macro %s. got=%d, want=%d",
+				call.Pos(), call.Function, len(call.Arguments), len(macro.Parameters))
+			return node
+		}
+		evalEnv := extendMacroEnv(macro, quoteArgs(call))
+		evaluated := callTail(unwrapReturnValue(Eval(macro.Body, evalEnv)))
+		switch evaluated := evaluated.(type) {
+		case *object.Quote:
+			return evaluated.Node
+		case *object.Error:
+			err = fmt.Errorf("%s: macro %s: %s", call.Pos(), call.Function, evaluated.Message)
+		default:
+			err = fmt.Errorf("%s: macro %s must return a quoted node, got %s", call.Pos(), call.Function, evaluatedType(evaluated))
+		}
+		return node
+	})
+	if err != nil {
+		return nil, err
+	}
+	return expanded, nil
+}
+
+func isMacroCall(exp *ast.CallExpression, env *object.Environment) (*object.Macro, bool) {
+	identifier, ok := exp.Function.(*ast.Identifier)
+	if !ok {
+		return nil, false
+	}
+	obj, ok := env.Get(identifier.Value)
+	if !ok {
+		return nil, false
+	}
+	macro, ok := obj.(*object.Macro)
+	return macro, ok
+}
+
+func quoteArgs(exp *ast.CallExpression) []*object.Quote {
+	args := []*object.Quote{}
+	for _, a := range exp.Arguments {
+		args = append(args, &object.Quote{Node: a})
+	}
+	return args
+}
+
+func extendMacroEnv(macro *object.Macro, args []*object.Quote) *object.Environment {
+	extended := object.NewEnclosedEnvironment(macro.Env)
+	for paramIdx, param := range macro.Parameters {
+		extended.Set(param.Value, args[paramIdx])
+	}
+	return extended
+}
+
+func evaluatedType(obj object.Object) object.ObjectType {
+	if obj == nil {
+		return object.NULL_OBJ
+	}
+	return obj.Type()
+}
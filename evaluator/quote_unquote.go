@@ -0,0 +1,77 @@
+package evaluator
+
+import (
+	"interpreter/ast"
+	"interpreter/object"
+	"interpreter/token"
+	"strconv"
+)
+
+func quote(node ast.Node, env *object.Environment) object.Object {
+	var err object.Object
+	node = ast.Modify(node, func(node ast.Node) ast.Node {
+		if err != nil || !isCallTo(node, "unquote") {
+			return node
+		}
+		call := node.(*ast.CallExpression)
+		if len(call.Arguments) != 1 {
+			err = newError("wrong number of arguments. got=%d, want=1", len(call.Arguments))
+			return node
+		}
+		unquoted := Eval(call.Arguments[0], env)
+		if isError(unquoted) {
+			err = unquoted
+			return node
+		}
+		converted := convertObjectToASTNode(unquoted)
+		if converted == nil {
+			err = newError("cannot unquote %s", unquoted.Type())
+			return node
+		}
+		return converted
+	})
+	if err != nil {
+		return err
+	}
+	return &object.Quote{Node: node}
+}
+
+func isCallTo(node ast.Node, name string) bool {
+	call, ok := node.(*ast.CallExpression)
+	if !ok {
+		return false
+	}
+	ident, ok := call.Function.(*ast.Identifier)
+	return ok && ident.Value == name
+}
+
+// convertObjectToASTNode turns the result of an unquote call back into a
+// node. It returns nil for objects that have no literal representation.
+func convertObjectToASTNode(obj object.Object) ast.Node {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		t := token.Token{Type: token.INT, Literal: strconv.FormatInt(obj.Value, 10)}
+		return &ast.IntegerLiteral{Token: t, Value: obj.Value}
+	case *object.BigInteger:
+		t := token.Token{Type: token.INT, Literal: obj.Value.String()}
+		return &ast.IntegerLiteral{Token: t, Big: obj.Value}
+	case *object.Float:
+		t := token.Token{Type: token.FLOAT, Literal: obj.Inspect()}
+		return &ast.FloatLiteral{Token: t, Value: obj.Value}
+	case *object.Boolean:
+		var t token.Token
+		if obj.Value {
+			t = token.Token{Type: token.TRUE, Literal: "true"}
+		} else {
+			t = token.Token{Type: token.FALSE, Literal: "false"}
+		}
+		return &ast.Boolean{Token: t, Value: obj.Value}
+	case *object.String:
+		t := token.Token{Type: token.STRING, Literal: obj.Value}
+		return &ast.StringLiteral{Token: t, Value: obj.Value}
+	case *object.Quote:
+		return obj.Node
+	default:
+		return nil
+	}
+}
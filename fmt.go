@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"interpreter/format"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// sourceExt is the file extension of Monkey source files.
+const sourceExt = ".mk"
+
+func runFmt(args []string) int {
+	flags := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	write := flags.Bool("w", false, "write result to (source) file instead of stdout")
+	diff := flags.Bool("d", false, "display diffs instead of rewriting files")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: monkey fmt [-w] [-d] [path ...]\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		if *write {
+			fmt.Fprintln(os.Stderr, "monkey fmt: cannot use -w with standard input")
+			return 2
+		}
+		src, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "monkey fmt: %s\n", err)
+			return 2
+		}
+		if err := formatFile("<standard input>", src, *write, *diff); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		return 0
+	}
+
+	status := 0
+	for _, path := range flags.Args() {
+		err := filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			// files named explicitly are formatted regardless of their name
+			if d.IsDir() || (filepath.Ext(path) != sourceExt && !isArg(flags, path)) {
+				return nil
+			}
+			src, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if err := formatFile(path, src, *write, *diff); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				status = 2
+			}
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "monkey fmt: %s\n", err)
+			status = 2
+		}
+	}
+	return status
+}
+
+func isArg(flags *flag.FlagSet, path string) bool {
+	for _, arg := range flags.Args() {
+		if arg == path {
+			return true
+		}
+	}
+	return false
+}
+
+// formatFile formats src and prints it, its diff against src or writes it
+// back to filename.
+func formatFile(filename string, src []byte, write, diff bool) error {
+	res, err := format.Source(filename, src)
+	if err != nil {
+		return err
+	}
+	if !write && !diff {
+		_, err := os.Stdout.Write(res)
+		return err
+	}
+	if bytes.Equal(src, res) {
+		return nil
+	}
+	if diff {
+		fmt.Print(unifiedDiff(filename, string(src), string(res)))
+	}
+	if write {
+		info, err := os.Stat(filename)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filename, res, info.Mode().Perm())
+	}
+	return nil
+}
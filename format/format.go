@@ -0,0 +1,443 @@
+// Package format implements the canonical formatting of Monkey source code.
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"interpreter/ast"
+	"interpreter/lexer"
+	"interpreter/parser"
+	"interpreter/token"
+	"strings"
+	"unicode"
+)
+
+// ParseError is returned by Source for input that does not parse.
+type ParseError struct {
+	Source      string
+	Diagnostics []parser.Diagnostic
+}
+
+func (e *ParseError) Error() string {
+	var out strings.Builder
+	for _, d := range e.Diagnostics {
+		out.WriteString(d.Render(e.Source))
+	}
+	return strings.TrimRight(out.String(), "\n")
+}
+
+// Source formats src in canonical style. filename is only used in error
+// messages.
+func Source(filename string, src []byte) ([]byte, error) {
+	p := parser.New(lexer.NewWithFilename(filename, string(src)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, &ParseError{Source: string(src), Diagnostics: p.Errors()}
+	}
+	return []byte(Node(program)), nil
+}
+
+// Node formats node in canonical style: one statement per line, blocks
+// indented with tabs, operators surrounded by spaces and only the
+// parentheses that are needed to preserve the meaning. Hash literal pairs
+// keep their source order. The comments of a *ast.Program are preserved;
+// comments inside an expression are moved in front of its statement.
+func Node(node ast.Node) string {
+	pr := &printer{}
+	switch node := node.(type) {
+	case *ast.Program:
+		pr.comments = node.Comments
+		pr.statements(node.Statements, token.Position{})
+	case ast.Statement:
+		pr.statement(node)
+		pr.newline()
+	case ast.Expression:
+		pr.expression(node, parser.LOWEST)
+	}
+	return pr.out.String()
+}
+
+type printer struct {
+	out    bytes.Buffer
+	indent int
+	// comments holds the comments that have not been printed yet.
+	comments []token.Comment
+	// line is the source line of the last printed statement or comment, it
+	// is used to preserve blank lines. It is 0 at the start of a block.
+	line int
+}
+
+func (p *printer) write(s string) { p.out.WriteString(s) }
+
+func (p *printer) newline() {
+	p.out.WriteByte('\n')
+}
+
+func (p *printer) writeIndent() {
+	p.write(strings.Repeat("\t", p.indent))
+}
+
+// separate emits a blank line if the source had one or more blank lines
+// between the previous item and the one starting at pos.
+func (p *printer) separate(pos token.Position) {
+	if p.line > 0 && pos.IsValid() && pos.Line > p.line+1 {
+		p.newline()
+	}
+}
+
+func (p *printer) comment(c token.Comment) {
+	p.separate(c.Pos)
+	p.writeIndent()
+	p.write(c.Text)
+	p.newline()
+	p.line = c.End.Line
+}
+
+// flush prints all pending comments that start before offset on lines of
+// their own. A negative offset flushes all of them.
+func (p *printer) flush(offset int) {
+	for len(p.comments) > 0 && (offset < 0 || p.comments[0].Pos.Offset < offset) {
+		c := p.comments[0]
+		p.comments = p.comments[1:]
+		p.comment(c)
+	}
+}
+
+// hoist prints the pending comments inside s that do not belong to one of
+// its blocks. They cannot be kept in the middle of an expression, which is
+// always printed on a single line.
+func (p *printer) hoist(s ast.Statement) {
+	start, end := s.Pos(), s.End()
+	if !start.IsValid() {
+		return
+	}
+	var blocks []*ast.BlockStatement
+	ast.Inspect(s, func(n ast.Node) bool {
+		if b, ok := n.(*ast.BlockStatement); ok {
+			blocks = append(blocks, b)
+			return false
+		}
+		return true
+	})
+	remaining := p.comments[:0:0]
+	for _, c := range p.comments {
+		if c.Pos.Offset < start.Offset || c.Pos.Offset >= end.Offset || insideBlock(c, blocks) {
+			remaining = append(remaining, c)
+			continue
+		}
+		p.comment(c)
+	}
+	p.comments = remaining
+}
+
+func insideBlock(c token.Comment, blocks []*ast.BlockStatement) bool {
+	for _, b := range blocks {
+		if b.Pos().Offset <= c.Pos.Offset && c.Pos.Offset < b.End().Offset {
+			return true
+		}
+	}
+	return false
+}
+
+// trailing appends the pending comments that follow the statement ending at
+// end on the same source line, as long as they start before limit.
+func (p *printer) trailing(end token.Position, limit int) {
+	if !end.IsValid() {
+		return
+	}
+	for len(p.comments) > 0 {
+		c := p.comments[0]
+		if c.Pos.Line != end.Line || c.Pos.Offset < end.Offset || (limit >= 0 && c.Pos.Offset >= limit) {
+			return
+		}
+		p.comments = p.comments[1:]
+		p.write(" ")
+		p.write(c.Text)
+		p.line = c.End.Line
+	}
+}
+
+// statements prints list one statement per line. end is the position of
+// the closing brace, the comments in front of it are printed as well. For
+// a program end is invalid and all remaining comments are printed.
+func (p *printer) statements(list []ast.Statement, end token.Position) {
+	line := p.line
+	p.line = 0
+	for i, s := range list {
+		if s == nil {
+			continue
+		}
+		p.flush(s.Pos().Offset)
+		p.hoist(s)
+		p.separate(s.Pos())
+		p.writeIndent()
+		p.statement(s)
+		if es, ok := s.(*ast.ExpressionStatement); ok && needsSemicolon(es, list[i+1:]) {
+			p.write(";")
+		}
+		if s.End().IsValid() {
+			p.line = s.End().Line
+		}
+		limit := end.Offset
+		if !end.IsValid() {
+			limit = -1
+		}
+		if i+1 < len(list) && list[i+1] != nil && list[i+1].Pos().IsValid() {
+			limit = list[i+1].Pos().Offset
+		}
+		p.trailing(s.End(), limit)
+		p.newline()
+	}
+	if end.IsValid() {
+		p.flush(end.Offset)
+	} else {
+		p.flush(-1)
+	}
+	p.line = line
+}
+
+// needsSemicolon reports whether the expression statement es has to be
+// terminated. Only if-expressions are left alone, unless the next statement
+// would otherwise continue them, as in `if (x) { f }\n(1)`.
+func needsSemicolon(es *ast.ExpressionStatement, rest []ast.Statement) bool {
+	if _, ok := es.Expression.(*ast.IfExpression); !ok {
+		return true
+	}
+	if len(rest) == 0 || rest[0] == nil {
+		return false
+	}
+	next := &printer{}
+	next.statement(rest[0])
+	return strings.IndexAny(next.out.String(), "([-") == 0
+}
+
+func (p *printer) statement(s ast.Statement) {
+	switch s := s.(type) {
+	case *ast.LetStatement:
+		p.write("let ")
+		p.write(s.Name.Value)
+		p.write(" = ")
+		p.expression(s.Value, parser.LOWEST)
+		p.write(";")
+	case *ast.ReturnStatement:
+		p.write("return")
+		if s.ReturnValue != nil {
+			p.write(" ")
+			p.expression(s.ReturnValue, parser.LOWEST)
+		}
+		p.write(";")
+	case *ast.ExpressionStatement:
+		p.expression(s.Expression, parser.LOWEST)
+	case *ast.AssignStatement:
+		p.expression(s.Target, parser.LOWEST)
+		p.write(" " + s.Operator + " ")
+		p.expression(s.Value, parser.LOWEST)
+		p.write(";")
+	case *ast.WhileStatement:
+		p.write("while (")
+		p.expression(s.Condition, parser.LOWEST)
+		p.write(") ")
+		p.block(s.Body)
+	case *ast.ForStatement:
+		p.write("for (")
+		if s.Key != nil {
+			p.write(s.Key.Value)
+			p.write(", ")
+		}
+		p.write(s.Value.Value)
+		p.write(" in ")
+		p.expression(s.Iterable, parser.LOWEST)
+		p.write(") ")
+		p.block(s.Body)
+	case *ast.TryStatement:
+		p.write("try ")
+		p.block(s.Body)
+		if s.Catch != nil {
+			p.write(" catch (")
+			p.write(s.Param.Value)
+			p.write(") ")
+			p.block(s.Catch)
+		}
+		if s.Finally != nil {
+			p.write(" finally ")
+			p.block(s.Finally)
+		}
+	case *ast.ThrowStatement:
+		p.write("throw ")
+		p.expression(s.Value, parser.LOWEST)
+		p.write(";")
+	case *ast.BreakStatement:
+		p.write("break;")
+	case *ast.ContinueStatement:
+		p.write("continue;")
+	case *ast.BlockStatement:
+		p.block(s)
+	}
+}
+
+func (p *printer) block(b *ast.BlockStatement) {
+	if b == nil {
+		p.write("{}")
+		return
+	}
+	hasComments := len(p.comments) > 0 && b.End().IsValid() && p.comments[0].Pos.Offset < b.End().Offset
+	if len(b.Statements) == 0 && !hasComments {
+		p.write("{}")
+		return
+	}
+	p.write("{")
+	p.newline()
+	p.indent++
+	p.statements(b.Statements, b.Rbrace.Pos)
+	p.indent--
+	p.writeIndent()
+	p.write("}")
+}
+
+// precedence returns the binding power of e as used by the parser. Operands
+// binding less tightly than their context requires get parenthesized.
+func precedence(e ast.Expression) int {
+	switch e := e.(type) {
+	case *ast.InfixExpression:
+		return parser.Precedence(e.Operator)
+	case *ast.PrefixExpression:
+		return parser.PREFIX
+	case *ast.CallExpression, *ast.IndexExpression:
+		return parser.CALL
+	case *ast.IntegerLiteral, *ast.FloatLiteral:
+		// negative literals only come from macros, they read like -x
+		if strings.HasPrefix(e.TokenLiteral(), "-") {
+			return parser.PREFIX
+		}
+	}
+	return parser.INDEX + 1
+}
+
+func (p *printer) expression(e ast.Expression, prec int) {
+	if e == nil {
+		return
+	}
+	if precedence(e) < prec {
+		p.write("(")
+		defer p.write(")")
+	}
+	switch e := e.(type) {
+	case *ast.Identifier:
+		p.write(e.Value)
+	case *ast.IntegerLiteral, *ast.FloatLiteral:
+		p.write(e.TokenLiteral())
+	case *ast.Boolean:
+		p.write(fmt.Sprintf("%t", e.Value))
+	case *ast.StringLiteral:
+		p.write(quote(e))
+	case *ast.PrefixExpression:
+		p.write(e.Operator)
+		p.expression(e.Right, parser.PREFIX)
+	case *ast.InfixExpression:
+		prec := parser.Precedence(e.Operator)
+		left, right := prec, prec+1
+		if e.Operator == "**" {
+			// ** is right-associative
+			left, right = prec+1, prec
+		}
+		p.expression(e.Left, left)
+		p.write(" " + e.Operator + " ")
+		p.expression(e.Right, right)
+	case *ast.IfExpression:
+		p.write("if (")
+		p.expression(e.Condition, parser.LOWEST)
+		p.write(") ")
+		p.block(e.Consequence)
+		if e.Alternative != nil {
+			p.write(" else ")
+			p.block(e.Alternative)
+		}
+	case *ast.FunctionLiteral:
+		p.write("fn")
+		p.parameters(e.Parameters)
+		p.block(e.Body)
+	case *ast.MacroLiteral:
+		p.write("macro")
+		p.parameters(e.Parameters)
+		p.block(e.Body)
+	case *ast.CallExpression:
+		p.expression(e.Function, parser.CALL)
+		p.write("(")
+		p.expressions(e.Arguments)
+		p.write(")")
+	case *ast.IndexExpression:
+		p.expression(e.Left, parser.CALL)
+		p.write("[")
+		p.expression(e.Index, parser.LOWEST)
+		p.write("]")
+	case *ast.ArrayLiteral:
+		p.write("[")
+		p.expressions(e.Elements)
+		p.write("]")
+	case *ast.HashLiteral:
+		p.write("{")
+		for i, key := range e.Keys() {
+			if i > 0 {
+				p.write(", ")
+			}
+			p.expression(key, parser.LOWEST)
+			p.write(": ")
+			p.expression(e.Pairs[key], parser.LOWEST)
+		}
+		p.write("}")
+	}
+}
+
+func (p *printer) expressions(list []ast.Expression) {
+	for i, e := range list {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.expression(e, parser.LOWEST)
+	}
+}
+
+func (p *printer) parameters(params []*ast.Identifier) {
+	p.write("(")
+	for i, param := range params {
+		if i > 0 {
+			p.write(", ")
+		}
+		p.write(param.Value)
+	}
+	p.write(") ")
+}
+
+// quote renders a string literal the way it was written if it was a raw
+// string and with the escape sequences understood by the lexer otherwise.
+func quote(s *ast.StringLiteral) string {
+	if s.Token.Type == token.RAW_STRING {
+		return "`" + s.Value + "`"
+	}
+	var out strings.Builder
+	out.WriteByte('"')
+	for _, r := range s.Value {
+		switch r {
+		case '"':
+			out.WriteString(`\"`)
+		case '\\':
+			out.WriteString(`\\`)
+		case '\n':
+			out.WriteString(`\n`)
+		case '\t':
+			out.WriteString(`\t`)
+		case '\r':
+			out.WriteString(`\r`)
+		case 0:
+			out.WriteString(`\0`)
+		default:
+			if unicode.IsPrint(r) {
+				out.WriteRune(r)
+			} else {
+				fmt.Fprintf(&out, `\u{%x}`, r)
+			}
+		}
+	}
+	out.WriteByte('"')
+	return out.String()
+}
@@ -0,0 +1,200 @@
+package format
+
+import (
+	"errors"
+	"interpreter/ast"
+	"interpreter/token"
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x=1", "let x = 1;\n"},
+		{"return(1)", "return 1;\n"},
+		{"x+=2*y ;", "x += 2 * y;\n"},
+		{"a[1]=-b", "a[1] = -b;\n"},
+		{"puts( 1,2 )", "puts(1, 2);\n"},
+		{"break;continue", "break;\ncontinue;\n"},
+		{"throw(x)", "throw x;\n"},
+		{"(a+b)*c", "(a + b) * c;\n"},
+		{"a+(b*c)", "a + b * c;\n"},
+		{"a-(b-c)", "a - (b - c);\n"},
+		{"(a-b)-c", "a - b - c;\n"},
+		{"(a**b)**c", "(a ** b) ** c;\n"},
+		{"a**(b**c)", "a ** b ** c;\n"},
+		{"(-a)**2", "(-a) ** 2;\n"},
+		{"-(a**2)", "-a ** 2;\n"},
+		{"!(a==b)", "!(a == b);\n"},
+		{"(a||b)&&c", "(a || b) && c;\n"},
+		{"(1&2)|3<<4", "1 & 2 | 3 << 4;\n"},
+		{"~(~a)", "~~a;\n"},
+		{"(f)(x)[0]", "f(x)[0];\n"},
+		{"(a+b)(x)", "(a + b)(x);\n"},
+		{"(a+b)[0]", "(a + b)[0];\n"},
+		{`"a\"b\\c\n\t"`, `"a\"b\\c\n\t";` + "\n"},
+		{"`raw \\n`", "`raw \\n`;\n"},
+		{"{}", "{};\n"},
+		{"[]", "[];\n"},
+		{`{"b": 1, "a": [1,2]}`, `{"b": 1, "a": [1, 2]};` + "\n"},
+		{"fn(){}", "fn() {};\n"},
+		{"fn(a,b){a+b}", "fn(a, b) {\n\ta + b;\n};\n"},
+		{"macro(a){quote(a)}", "macro(a) {\n\tquote(a);\n};\n"},
+		{
+			"try{f()}catch(e){e}finally{g()}",
+			"try {\n\tf();\n} catch (e) {\n\te;\n} finally {\n\tg();\n}\n",
+		},
+		{
+			"try{f()}finally{}",
+			"try {\n\tf();\n} finally {}\n",
+		},
+		{
+			"if(x){1}else{2}",
+			"if (x) {\n\t1;\n} else {\n\t2;\n}\n",
+		},
+		{
+			"if(x){1};(a+b)*2",
+			"if (x) {\n\t1;\n};\n(a + b) * 2;\n",
+		},
+		{
+			"if(x){1};[2]",
+			"if (x) {\n\t1;\n};\n[2];\n",
+		},
+		{
+			"if(x){1};-2",
+			"if (x) {\n\t1;\n};\n-2;\n",
+		},
+		{
+			"if(x){1};y",
+			"if (x) {\n\t1;\n}\ny;\n",
+		},
+		{
+			"while(i<3){i+=1}",
+			"while (i < 3) {\n\ti += 1;\n}\n",
+		},
+		{
+			"for(k,v in h){puts(k)}for(x in xs){}",
+			"for (k, v in h) {\n\tputs(k);\n}\nfor (x in xs) {}\n",
+		},
+		{
+			"let f=fn(x){let g=fn(){x};g()}",
+			"let f = fn(x) {\n\tlet g = fn() {\n\t\tx;\n\t};\n\tg();\n};\n",
+		},
+		{
+			"let a = 1;\n\n\n\nlet b = 2;\nlet c = 3;",
+			"let a = 1;\n\nlet b = 2;\nlet c = 3;\n",
+		},
+	}
+
+	for _, tt := range tests {
+		formatted, err := Source("", []byte(tt.input))
+		if err != nil {
+			t.Errorf("input %q: unexpected error: %s", tt.input, err)
+			continue
+		}
+		if string(formatted) != tt.expected {
+			t.Errorf("input %q: wrong result.\nwant=%q\ngot =%q", tt.input, tt.expected, formatted)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			"// header\nlet x = 1; // trailing\n\n// next\nx",
+			"// header\nlet x = 1; // trailing\n\n// next\nx;\n",
+		},
+		{
+			"let f = fn() {\n  // inside\n  1 /* one */\n  // last\n};",
+			"let f = fn() {\n\t// inside\n\t1; /* one */\n\t// last\n};\n",
+		},
+		{
+			"let f = fn() { /* empty */ };",
+			"let f = fn() {\n\t/* empty */\n};\n",
+		},
+		{
+			"let h = {\n  \"a\": 1, // one\n  \"b\": 2\n};",
+			"// one\nlet h = {\"a\": 1, \"b\": 2};\n",
+		},
+		{
+			"x;\n/* multi\n   line */\ny;\n// end",
+			"x;\n/* multi\n   line */\ny;\n// end\n",
+		},
+		{
+			"// only a comment",
+			"// only a comment\n",
+		},
+	}
+
+	for _, tt := range tests {
+		formatted, err := Source("", []byte(tt.input))
+		if err != nil {
+			t.Errorf("input %q: unexpected error: %s", tt.input, err)
+			continue
+		}
+		if string(formatted) != tt.expected {
+			t.Errorf("input %q: wrong result.\nwant=%q\ngot =%q", tt.input, tt.expected, formatted)
+		}
+	}
+}
+
+func TestIdempotent(t *testing.T) {
+	input := `// fib computes fibonacci numbers
+let fib = fn(x) { if (x < 2) { return x; } /* recurse */ fib(x-1)+fib(x - 2) }; // slow
+
+
+let h = {"b": 2, "a": [1,2,(3+4)*5]}  ;
+for (k, v in h) {
+  // inside
+  puts(k)
+}
+let m = macro(x) { quote(unquote(x) * 2) };
+if (a) { 1 } else { while (false) { break } }
+-(2 ** 3) ** 2; (-2) ** 3 ** 2;
+`
+	first, err := Source("", []byte(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	second, err := Source("", first)
+	if err != nil {
+		t.Fatalf("formatted source does not parse: %s\n%s", err, first)
+	}
+	if string(first) != string(second) {
+		t.Errorf("formatting is not idempotent.\nfirst =%q\nsecond=%q", first, second)
+	}
+}
+
+func TestSourceErrors(t *testing.T) {
+	_, err := Source("x.mk", []byte("let x = ;"))
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("expected *ParseError. got=%T (%v)", err, err)
+	}
+	expected := "x.mk:1:9: error[P002]: expected an expression but got SEMICOLON \";\"\nlet x = ;\n        ^"
+	if err.Error() != expected {
+		t.Errorf("wrong error.\nwant=%q\ngot =%q", expected, err.Error())
+	}
+}
+
+func TestNodeWithoutPositions(t *testing.T) {
+	// nodes built by macros have neither positions nor comments
+	node := &ast.InfixExpression{
+		Operator: "*",
+		Left: &ast.InfixExpression{
+			Operator: "+",
+			Left:     &ast.IntegerLiteral{Token: token.Token{Literal: "-1"}, Value: -1},
+			Right:    &ast.Identifier{Value: "x"},
+		},
+		Right: &ast.StringLiteral{Token: token.Token{Type: token.STRING}, Value: "\u0001"},
+	}
+	expected := `(-1 + x) * "\u{1}"`
+	if Node(node) != expected {
+		t.Errorf("wrong result. want=%q, got=%q", expected, Node(node))
+	}
+}
@@ -2,31 +2,113 @@ package lexer
 
 import (
 	"bytes"
+	"fmt"
 	"interpreter/token"
+	"strconv"
+	"unicode/utf8"
 )
 
 type Lexer struct {
 	input        string
+	filename     string
 	position     int
 	readPosition int
 	ch           byte
+	line         int
+	column       int
 }
 
 func New(input string) *Lexer {
-	lexer := &Lexer{input: input}
+	return NewWithFilename("", input)
+}
+
+func NewWithFilename(filename, input string) *Lexer {
+	lexer := &Lexer{input: input, filename: filename, line: 1}
 	lexer.readChar()
 	return lexer
 }
 
 func (l *Lexer) NextToken() token.Token {
+	var comments []token.Comment
+	for {
+		l.skipWhitespaces()
+		if l.ch != '/' || (l.peekChar() != '/' && l.peekChar() != '*') {
+			break
+		}
+		comment, ok := l.readComment()
+		if !ok {
+			return token.Token{
+				Type:     token.ILLEGAL,
+				Literal:  "unterminated block comment",
+				Pos:      comment.Pos,
+				End:      comment.End,
+				Comments: comments,
+			}
+		}
+		comments = append(comments, comment)
+	}
+	pos := l.currentPosition()
+	tok := l.readToken()
+	tok.Pos = pos
+	tok.End = l.currentPosition()
+	tok.Comments = comments
+	return tok
+}
+
+// readComment reads a `//` comment up to the end of the line or a `/* */`
+// comment, which may be nested. It reports false if a block comment is not
+// terminated before the end of the input.
+func (l *Lexer) readComment() (token.Comment, bool) {
+	comment := token.Comment{Pos: l.currentPosition()}
+	start := l.position
+	ok := true
+	if l.peekChar() == '/' {
+		for l.ch != '\n' && l.ch != 0 {
+			l.readChar()
+		}
+	} else {
+		l.readChar()
+		l.readChar()
+		depth := 1
+		for depth > 0 {
+			switch {
+			case l.ch == 0:
+				ok = false
+				depth = 0
+			case l.ch == '/' && l.peekChar() == '*':
+				l.readChar()
+				l.readChar()
+				depth++
+			case l.ch == '*' && l.peekChar() == '/':
+				l.readChar()
+				l.readChar()
+				depth--
+			default:
+				l.readChar()
+			}
+		}
+	}
+	comment.Text = l.input[start:l.position]
+	comment.End = l.currentPosition()
+	return comment, ok
+}
+
+// currentPosition returns the position of l.ch.
+func (l *Lexer) currentPosition() token.Position {
+	return token.Position{
+		Filename: l.filename,
+		Offset:   l.position,
+		Line:     l.line,
+		Column:   l.column,
+	}
+}
+
+func (l *Lexer) readToken() token.Token {
 	var tok token.Token
-	l.skipWhitespaces()
 	switch l.ch {
 	case '=':
 		if l.peekChar() == '=' {
-			ch := l.ch
-			l.readChar()
-			tok = token.Token{Type: token.EQ, Literal: string(ch) + string(l.ch)}
+			tok = l.readTwoCharToken(token.EQ)
 		} else {
 			tok = newToken(token.ASSIGN, l.ch)
 		}
@@ -39,9 +121,17 @@ func (l *Lexer) NextToken() token.Token {
 	case ',':
 		tok = newToken(token.COMMA, l.ch)
 	case '+':
-		tok = newToken(token.PLUS, l.ch)
+		if l.peekChar() == '=' {
+			tok = l.readTwoCharToken(token.PLUS_ASSIGN)
+		} else {
+			tok = newToken(token.PLUS, l.ch)
+		}
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		if l.peekChar() == '=' {
+			tok = l.readTwoCharToken(token.MINUS_ASSIGN)
+		} else {
+			tok = newToken(token.MINUS, l.ch)
+		}
 	case '{':
 		tok = newToken(token.LBRACE, l.ch)
 	case '}':
@@ -52,22 +142,65 @@ func (l *Lexer) NextToken() token.Token {
 		tok = newToken(token.RBRACKET, l.ch)
 	case '!':
 		if l.peekChar() == '=' {
-			ch := l.ch
-			l.readChar()
-			tok = token.Token{Type: token.NOT_EQ, Literal: string(ch) + string(l.ch)}
+			tok = l.readTwoCharToken(token.NOT_EQ)
 		} else {
 			tok = newToken(token.BANG, l.ch)
 		}
 	case '/':
-		tok = newToken(token.SLASH, l.ch)
+		if l.peekChar() == '=' {
+			tok = l.readTwoCharToken(token.SLASH_ASSIGN)
+		} else {
+			tok = newToken(token.SLASH, l.ch)
+		}
 	case '*':
-		tok = newToken(token.ASTERISK, l.ch)
+		switch l.peekChar() {
+		case '*':
+			tok = l.readTwoCharToken(token.POWER)
+		case '=':
+			tok = l.readTwoCharToken(token.ASTERISK_ASSIGN)
+		default:
+			tok = newToken(token.ASTERISK, l.ch)
+		}
+	case '%':
+		tok = newToken(token.PERCENT, l.ch)
+	case '^':
+		tok = newToken(token.BIT_XOR, l.ch)
+	case '~':
+		tok = newToken(token.BIT_NOT, l.ch)
 	case '<':
-		tok = newToken(token.LT, l.ch)
+		switch l.peekChar() {
+		case '=':
+			tok = l.readTwoCharToken(token.LT_EQ)
+		case '<':
+			tok = l.readTwoCharToken(token.SHL)
+		default:
+			tok = newToken(token.LT, l.ch)
+		}
 	case '>':
-		tok = newToken(token.GT, l.ch)
+		switch l.peekChar() {
+		case '=':
+			tok = l.readTwoCharToken(token.GT_EQ)
+		case '>':
+			tok = l.readTwoCharToken(token.SHR)
+		default:
+			tok = newToken(token.GT, l.ch)
+		}
+	case '&':
+		if l.peekChar() == '&' {
+			tok = l.readTwoCharToken(token.AND)
+		} else {
+			tok = newToken(token.BIT_AND, l.ch)
+		}
+	case '|':
+		if l.peekChar() == '|' {
+			tok = l.readTwoCharToken(token.OR)
+		} else {
+			tok = newToken(token.BIT_OR, l.ch)
+		}
 	case '"':
 		tok = l.readString()
+	case '`':
+		tok = l.readRawString()
 	case ':':
 		tok = newToken(token.COLON, l.ch)
 	case 0:
@@ -79,46 +212,151 @@ func (l *Lexer) NextToken() token.Token {
 			tok.Type = token.LookupIdent(tok.Literal)
 			return tok
 		} else if isDigit(l.ch) {
-			tok.Type = token.INT
-			tok.Literal = l.readNumber()
-			return tok
+			return l.readNumber()
 		} else {
-			tok = newToken(token.ILLEGAL, l.ch)
+			tok = token.Token{Type: token.ILLEGAL, Literal: fmt.Sprintf("illegal character %q", l.ch)}
 		}
 	}
 	l.readChar()
 	return tok
 }
 
+// readString reads a double quoted string and decodes its escape sequences.
+// It leaves l.ch at the closing quote. A malformed escape sequence turns the
+// whole string into an ILLEGAL token.
 func (l *Lexer) readString() token.Token {
 	var b bytes.Buffer
-	for l.peekChar() != '"' || l.peekChar() == 0 {
+	msg := ""
+	for {
 		l.readChar()
-		b.WriteByte(l.ch)
+		switch l.ch {
+		case 0:
+			return token.Token{Type: token.ILLEGAL, Literal: "unterminated string"}
+		case '"':
+			if msg != "" {
+				return token.Token{Type: token.ILLEGAL, Literal: msg}
+			}
+			return token.Token{Type: token.STRING, Literal: b.String()}
+		case '\\':
+			if err := l.readEscape(&b); err != "" && msg == "" {
+				msg = err
+			}
+		default:
+			b.WriteByte(l.ch)
+		}
+	}
+}
+
+var escapes = map[byte]byte{
+	'n':  '\n',
+	't':  '\t',
+	'r':  '\r',
+	'0':  0,
+	'\\': '\\',
+	'"':  '"',
+}
+
+// readEscape decodes the escape sequence starting at the backslash in l.ch
+// into b and leaves l.ch at its last character. It returns a description
+// of the problem if the sequence is malformed.
+func (l *Lexer) readEscape(b *bytes.Buffer) string {
+	if l.peekChar() == 0 {
+		return ""
+	}
+	l.readChar()
+	if ch, ok := escapes[l.ch]; ok {
+		b.WriteByte(ch)
+		return ""
+	}
+	if l.ch != 'u' {
+		return fmt.Sprintf("unknown escape sequence \\%c", l.ch)
+	}
+	if l.peekChar() != '{' {
+		return "invalid unicode escape: expected \\u{XXXX}"
 	}
 	l.readChar()
-	return token.Token{Type: token.STRING, Literal: b.String()}
+	start := l.readPosition
+	for isHexDigit(l.peekChar()) {
+		l.readChar()
+	}
+	digits := l.input[start:l.readPosition]
+	if l.peekChar() != '}' || len(digits) == 0 || len(digits) > 6 {
+		return "invalid unicode escape: expected \\u{XXXX}"
+	}
+	l.readChar()
+	value, _ := strconv.ParseUint(digits, 16, 32)
+	if !utf8.ValidRune(rune(value)) {
+		return fmt.Sprintf("invalid unicode code point U+%X", value)
+	}
+	b.WriteRune(rune(value))
+	return ""
+}
+
+// readRawString reads a backtick quoted string verbatim, newlines included,
+// and leaves l.ch at the closing backtick.
+func (l *Lexer) readRawString() token.Token {
+	start := l.position + 1
+	for {
+		l.readChar()
+		switch l.ch {
+		case 0:
+			return token.Token{Type: token.ILLEGAL, Literal: "unterminated raw string"}
+		case '`':
+			return token.Token{Type: token.RAW_STRING, Literal: l.input[start:l.position]}
+		}
+	}
 }
 
 func (l *Lexer) peekChar() byte {
-	if l.readPosition > len(l.input) {
+	if l.readPosition >= len(l.input) {
 		return 0
 	}
 	return l.input[l.readPosition]
 }
 
-func (l *Lexer) readNumber() string {
+// readNumber reads an integer or a float literal such as 1.5, 2e10 or
+// 1.5E-3. A '.' or an exponent marker is only part of the number when
+// digits follow it.
+func (l *Lexer) readNumber() token.Token {
 	pos := l.position
+	tp := token.TokenType(token.INT)
+	l.readDigits()
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		tp = token.FLOAT
+		l.readChar()
+		l.readDigits()
+	}
+	if l.ch == 'e' || l.ch == 'E' {
+		next := l.peekChar()
+		if (next == '+' || next == '-') && l.readPosition+1 < len(l.input) {
+			next = l.input[l.readPosition+1]
+			if isDigit(next) {
+				l.readChar()
+			}
+		}
+		if isDigit(next) {
+			tp = token.FLOAT
+			l.readChar()
+			l.readDigits()
+		}
+	}
+	return token.Token{Type: tp, Literal: l.input[pos:l.position]}
+}
+
+func (l *Lexer) readDigits() {
 	for isDigit(l.ch) {
 		l.readChar()
 	}
-	return l.input[pos:l.position]
 }
 
 func isDigit(ch byte) bool {
 	return '0' <= ch && ch <= '9'
 }
 
+func isHexDigit(ch byte) bool {
+	return isDigit(ch) || 'a' <= ch && ch <= 'f' || 'A' <= ch && ch <= 'F'
+}
+
 func isLetter(ch byte) bool {
 	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
 }
@@ -127,6 +365,14 @@ func newToken(tp token.TokenType, literal byte) token.Token {
 	return token.Token{Type: tp, Literal: string(literal)}
 }
 
+// readTwoCharToken consumes l.ch and the following character as a single
+// token of type tp.
+func (l *Lexer) readTwoCharToken(tp token.TokenType) token.Token {
+	ch := l.ch
+	l.readChar()
+	return token.Token{Type: tp, Literal: string(ch) + string(l.ch)}
+}
+
 func (l *Lexer) skipWhitespaces() {
 	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
 		l.readChar()
@@ -134,14 +380,20 @@ func (l *Lexer) skipWhitespaces() {
 }
 
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.column = 0
+	}
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
 		l.ch = l.input[l.readPosition]
 	}
-
-	l.position = l.readPosition
-	l.readPosition++
+	if l.readPosition <= len(l.input) {
+		l.position = l.readPosition
+		l.readPosition++
+		l.column++
+	}
 }
 
 func (l *Lexer) readIdentifier() string {
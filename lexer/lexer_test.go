@@ -13,7 +13,7 @@ let add = fn(x, y) {
 };
 
 let result = add(five, ten);
-!-/*5;
+!-/ *5;
 5 < 10 > 5;
 
 if (5 < 10) {
@@ -24,6 +24,9 @@ return false;
 
 10 == 10;
 10 != 9;
+a <= b >= c && d || e;
+a % b ** c & d | e ^ ~f << g >> h;
+a += b -= c *= d /= e;
 "foobar"
 "foo bar"
 [1, 2];
@@ -107,6 +110,43 @@ return false;
 		{token.NOT_EQ, "!="},
 		{token.INT, "9"},
 		{token.SEMICOLON, ";"},
+		{token.IDENT, "a"},
+		{token.LT_EQ, "<="},
+		{token.IDENT, "b"},
+		{token.GT_EQ, ">="},
+		{token.IDENT, "c"},
+		{token.AND, "&&"},
+		{token.IDENT, "d"},
+		{token.OR, "||"},
+		{token.IDENT, "e"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "a"},
+		{token.PERCENT, "%"},
+		{token.IDENT, "b"},
+		{token.POWER, "**"},
+		{token.IDENT, "c"},
+		{token.BIT_AND, "&"},
+		{token.IDENT, "d"},
+		{token.BIT_OR, "|"},
+		{token.IDENT, "e"},
+		{token.BIT_XOR, "^"},
+		{token.BIT_NOT, "~"},
+		{token.IDENT, "f"},
+		{token.SHL, "<<"},
+		{token.IDENT, "g"},
+		{token.SHR, ">>"},
+		{token.IDENT, "h"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "a"},
+		{token.PLUS_ASSIGN, "+="},
+		{token.IDENT, "b"},
+		{token.MINUS_ASSIGN, "-="},
+		{token.IDENT, "c"},
+		{token.ASTERISK_ASSIGN, "*="},
+		{token.IDENT, "d"},
+		{token.SLASH_ASSIGN, "/="},
+		{token.IDENT, "e"},
+		{token.SEMICOLON, ";"},
 		{token.STRING, "foobar"},
 		{token.STRING, "foo bar"},
 		{token.LBRACKET, "["},
@@ -132,3 +172,178 @@ return false;
 	}
 
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := "let x = 5;\n  foo(\"bar\")\n"
+
+	tests := []struct {
+		expectedType   token.TokenType
+		expectedPos    token.Position
+		expectedEndCol int
+	}{
+		{token.LET, token.Position{Filename: "test.mk", Offset: 0, Line: 1, Column: 1}, 4},
+		{token.IDENT, token.Position{Filename: "test.mk", Offset: 4, Line: 1, Column: 5}, 6},
+		{token.ASSIGN, token.Position{Filename: "test.mk", Offset: 6, Line: 1, Column: 7}, 8},
+		{token.INT, token.Position{Filename: "test.mk", Offset: 8, Line: 1, Column: 9}, 10},
+		{token.SEMICOLON, token.Position{Filename: "test.mk", Offset: 9, Line: 1, Column: 10}, 11},
+		{token.IDENT, token.Position{Filename: "test.mk", Offset: 13, Line: 2, Column: 3}, 6},
+		{token.LPAREN, token.Position{Filename: "test.mk", Offset: 16, Line: 2, Column: 6}, 7},
+		{token.STRING, token.Position{Filename: "test.mk", Offset: 17, Line: 2, Column: 7}, 12},
+		{token.RPAREN, token.Position{Filename: "test.mk", Offset: 22, Line: 2, Column: 12}, 13},
+		{token.EOF, token.Position{Filename: "test.mk", Offset: 24, Line: 3, Column: 1}, 1},
+		{token.EOF, token.Position{Filename: "test.mk", Offset: 24, Line: 3, Column: 1}, 1},
+	}
+
+	l := NewWithFilename("test.mk", input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong, expected %q, got %q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Pos != tt.expectedPos {
+			t.Fatalf("tests[%d] - position wrong, expected %+v, got %+v", i, tt.expectedPos, tok.Pos)
+		}
+		if tok.End.Column != tt.expectedEndCol {
+			t.Fatalf("tests[%d] - end column wrong, expected %d, got %d", i, tt.expectedEndCol, tok.End.Column)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// leading
+let x = 5; // trailing
+/* block /* nested */ still comment */ x / 2;
+// last`
+
+	tests := []struct {
+		expectedType     token.TokenType
+		expectedLiteral  string
+		expectedComments []string
+	}{
+		{token.LET, "let", []string{"// leading"}},
+		{token.IDENT, "x", nil},
+		{token.ASSIGN, "=", nil},
+		{token.INT, "5", nil},
+		{token.SEMICOLON, ";", nil},
+		{token.IDENT, "x", []string{"// trailing", "/* block /* nested */ still comment */"}},
+		{token.SLASH, "/", nil},
+		{token.INT, "2", nil},
+		{token.SEMICOLON, ";", nil},
+		{token.EOF, "", []string{"// last"}},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong, expected %q, got %q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong, expected %q, got %q", i, tt.expectedLiteral, tok.Literal)
+		}
+		if len(tok.Comments) != len(tt.expectedComments) {
+			t.Fatalf("tests[%d] - wrong number of comments, expected %d, got %d", i, len(tt.expectedComments), len(tok.Comments))
+		}
+		for j, c := range tt.expectedComments {
+			if tok.Comments[j].Text != c {
+				t.Errorf("tests[%d] - comment %d wrong, expected %q, got %q", i, j, c, tok.Comments[j].Text)
+			}
+		}
+	}
+}
+
+func TestUnterminatedBlockComment(t *testing.T) {
+	l := New("1 /* open /* nested */\n")
+	l.NextToken()
+	tok := l.NextToken()
+	if tok.Type != token.ILLEGAL {
+		t.Fatalf("tokentype wrong, expected %q, got %q", token.ILLEGAL, tok.Type)
+	}
+	if tok.Literal != "unterminated block comment" {
+		t.Fatalf("literal wrong, got %q", tok.Literal)
+	}
+	if tok.Pos.Offset != 2 || tok.End.Offset != 23 {
+		t.Fatalf("span wrong, got %d-%d", tok.Pos.Offset, tok.End.Offset)
+	}
+	if tok := l.NextToken(); tok.Type != token.EOF {
+		t.Fatalf("tokentype wrong, expected %q, got %q", token.EOF, tok.Type)
+	}
+}
+
+func TestStringLiterals(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{`"a\nb\tc\\d\"e"`, token.STRING, "a\nb\tc\\d\"e"},
+		{`"\u{41}\u{1F600}"`, token.STRING, "A\U0001F600"},
+		{`"\0"`, token.STRING, "\x00"},
+		{"\"multi\nline\"", token.STRING, "multi\nline"},
+		{"`raw \\n \"text\"\n{}`", token.RAW_STRING, "raw \\n \"text\"\n{}"},
+		{`"unterminated`, token.ILLEGAL, "unterminated string"},
+		{`"ends with \`, token.ILLEGAL, "unterminated string"},
+		{"`unterminated", token.ILLEGAL, "unterminated raw string"},
+		{`"bad \q escape"`, token.ILLEGAL, `unknown escape sequence \q`},
+		{`"\u0041"`, token.ILLEGAL, `invalid unicode escape: expected \u{XXXX}`},
+		{`"\u{}"`, token.ILLEGAL, `invalid unicode escape: expected \u{XXXX}`},
+		{`"\u{1234567}"`, token.ILLEGAL, `invalid unicode escape: expected \u{XXXX}`},
+		{`"\u{D800}"`, token.ILLEGAL, `invalid unicode code point U+D800`},
+	}
+
+	for i, tt := range tests {
+		l := New(tt.input)
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong, expected %q, got %q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong, expected %q, got %q", i, tt.expectedLiteral, tok.Literal)
+		}
+		if tok.End.Offset != len(tt.input) {
+			t.Fatalf("tests[%d] - token does not span the input, end=%d", i, tok.End.Offset)
+		}
+		if tok := l.NextToken(); tok.Type != token.EOF {
+			t.Fatalf("tests[%d] - tokentype wrong, expected %q, got %q", i, token.EOF, tok.Type)
+		}
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	input := `1 1.5 0.25 2e10 1.5E-3 3e+2 7.x 4e 5e+`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.INT, "1"},
+		{token.FLOAT, "1.5"},
+		{token.FLOAT, "0.25"},
+		{token.FLOAT, "2e10"},
+		{token.FLOAT, "1.5E-3"},
+		{token.FLOAT, "3e+2"},
+		{token.INT, "7"},
+		{token.ILLEGAL, "illegal character '.'"},
+		{token.IDENT, "x"},
+		{token.INT, "4"},
+		{token.IDENT, "e"},
+		{token.INT, "5"},
+		{token.IDENT, "e"},
+		{token.PLUS, "+"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong, expected %q, got %q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong, expected %q, got %q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
@@ -7,7 +7,26 @@ import (
 	"os/user"
 )
 
+// commands maps the subcommands of the monkey binary to their
+// implementations. Without a subcommand the REPL is started.
+var commands = map[string]func(args []string) int{
+	"ast":    runAST,
+	"build":  runBuild,
+	"disasm": runDisasm,
+	"fmt":    runFmt,
+	"run":    runRun,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		cmd, ok := commands[os.Args[1]]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "monkey: unknown command %q\n", os.Args[1])
+			fmt.Fprintf(os.Stderr, "usage: monkey [ast|build|disasm|fmt|run] [arguments]\n")
+			os.Exit(2)
+		}
+		os.Exit(cmd(os.Args[2:]))
+	}
 
 	user, err := user.Current()
 
@@ -2,6 +2,10 @@ package object
 
 import (
 	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
 )
 
 var Builtins = []struct {
@@ -20,6 +24,8 @@ var Builtins = []struct {
 				return &Integer{Value: int64(len(arg.Elements))}
 			case *String:
 				return &Integer{Value: int64(len(arg.Value))}
+			case *Range:
+				return NewInteger(new(big.Int).SetUint64(arg.Len()))
 			default:
 				return newError("argument to `len` not supported, got %s",
 					args[0].Type())
@@ -117,6 +123,126 @@ var Builtins = []struct {
 			},
 		},
 	},
+	{
+		"int",
+		&Builtin{
+			Fn: func(args ...Object) Object {
+				if l := len(args); l != 1 {
+					return newError("wrong number of arguments. got=%d, want=%d", l, 1)
+				}
+				switch arg := args[0].(type) {
+				case *Integer, *BigInteger:
+					return arg
+				case *Float:
+					if math.IsNaN(arg.Value) || math.IsInf(arg.Value, 0) {
+						return newError("cannot convert %s to %s", arg.Inspect(), INTEGER_OBJ)
+					}
+					value, _ := big.NewFloat(arg.Value).Int(nil)
+					return NewInteger(value)
+				case *String:
+					value, ok := new(big.Int).SetString(strings.TrimSpace(arg.Value), 10)
+					if !ok {
+						return newError("could not parse %q as integer", arg.Value)
+					}
+					return NewInteger(value)
+				}
+				return newError("argument to `int` not supported, got %s", args[0].Type())
+			},
+		},
+	},
+	{
+		"float",
+		&Builtin{
+			Fn: func(args ...Object) Object {
+				if l := len(args); l != 1 {
+					return newError("wrong number of arguments. got=%d, want=%d", l, 1)
+				}
+				switch arg := args[0].(type) {
+				case *Integer, *BigInteger:
+					return &Float{Value: ToFloat(arg)}
+				case *Float:
+					return arg
+				case *String:
+					value, err := strconv.ParseFloat(strings.TrimSpace(arg.Value), 64)
+					if err != nil {
+						return newError("could not parse %q as float", arg.Value)
+					}
+					return &Float{Value: value}
+				}
+				return newError("argument to `float` not supported, got %s", args[0].Type())
+			},
+		},
+	},
+	{
+		"round",
+		&Builtin{
+			Fn: func(args ...Object) Object {
+				if l := len(args); l != 1 && l != 2 {
+					return newError("wrong number of arguments. got=%d, want=1 or 2", l)
+				}
+				digits := int64(0)
+				if len(args) == 2 {
+					switch arg := args[1].(type) {
+					case *Integer:
+						digits = arg.Value
+					case *BigInteger:
+						return newError("second argument to `round` out of range: %s", arg.Inspect())
+					default:
+						return newError("second argument to `round` must be %s, got %s", INTEGER_OBJ, args[1].Type())
+					}
+				}
+				switch arg := args[0].(type) {
+				case *Integer, *BigInteger:
+					return arg
+				case *Float:
+					// round(x) rounds to the nearest integer, round(x, n) to n decimals
+					if len(args) == 1 {
+						if math.IsNaN(arg.Value) || math.IsInf(arg.Value, 0) {
+							return newError("cannot convert %s to %s", arg.Inspect(), INTEGER_OBJ)
+						}
+						value, _ := big.NewFloat(math.Round(arg.Value)).Int(nil)
+						return NewInteger(value)
+					}
+					scale := math.Pow(10, float64(digits))
+					return &Float{Value: math.Round(arg.Value*scale) / scale}
+				}
+				return newError("argument to `round` not supported, got %s", args[0].Type())
+			},
+		},
+	},
+	{
+		"range",
+		&Builtin{
+			Fn: func(args ...Object) Object {
+				if l := len(args); l < 1 || l > 3 {
+					return newError("wrong number of arguments. got=%d, want=1 to 3", l)
+				}
+				bounds := make([]int64, len(args))
+				for i, arg := range args {
+					switch arg := arg.(type) {
+					case *Integer:
+						bounds[i] = arg.Value
+					case *BigInteger:
+						// ranges are limited to int64 like arrays
+						return newError("argument to `range` out of range: %s", arg.Inspect())
+					default:
+						return newError("argument to `range` must be %s, got %s", INTEGER_OBJ, arg.Type())
+					}
+				}
+				// range(end), range(start, end) or range(start, end, step)
+				switch len(bounds) {
+				case 1:
+					return &Range{Start: 0, End: bounds[0], Step: 1}
+				case 2:
+					return &Range{Start: bounds[0], End: bounds[1], Step: 1}
+				}
+				if bounds[2] == 0 {
+					return newError("step of `range` must not be zero")
+				}
+				return &Range{Start: bounds[0], End: bounds[1], Step: bounds[2]}
+			},
+		},
+	},
 }
 
 func newError(format string, a ...interface{}) *Error {
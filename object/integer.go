@@ -0,0 +1,143 @@
+package object
+
+import (
+	"hash/fnv"
+	"math"
+	"math/big"
+)
+
+// BigInteger holds integers that do not fit into an int64. It reports the
+// same type as Integer so that the promotion is invisible to Monkey code.
+// Integer arithmetic always goes through NewInteger, which guarantees that a
+// BigInteger never holds a value representable by an Integer.
+type BigInteger struct {
+	Value *big.Int
+}
+
+func (b *BigInteger) Type() ObjectType { return INTEGER_OBJ }
+
+func (b *BigInteger) Inspect() string { return b.Value.String() }
+
+func (b *BigInteger) HashKey() HashKey {
+	h := fnv.New64a()
+	if b.Value.Sign() < 0 {
+		h.Write([]byte{'-'})
+	}
+	h.Write(b.Value.Bytes())
+	return HashKey{Type: b.Type(), Value: h.Sum64()}
+}
+
+// NewInteger returns v as an *Integer if it fits into an int64 and as a
+// *BigInteger otherwise.
+func NewInteger(v *big.Int) Object {
+	if v.IsInt64() {
+		return &Integer{Value: v.Int64()}
+	}
+	return &BigInteger{Value: v}
+}
+
+// ToBigInt returns the value of an *Integer or *BigInteger as a *big.Int.
+// It returns nil for any other object.
+func ToBigInt(obj Object) *big.Int {
+	switch obj := obj.(type) {
+	case *Integer:
+		return big.NewInt(obj.Value)
+	case *BigInteger:
+		return obj.Value
+	}
+	return nil
+}
+
+// The following functions implement int64 arithmetic and fall back to
+// math/big when the result overflows.
+
+func AddInt64(a, b int64) Object {
+	sum := a + b
+	if (a^sum)&(b^sum) < 0 {
+		return NewInteger(new(big.Int).Add(big.NewInt(a), big.NewInt(b)))
+	}
+	return &Integer{Value: sum}
+}
+
+func SubInt64(a, b int64) Object {
+	diff := a - b
+	if (a^b)&(a^diff) < 0 {
+		return NewInteger(new(big.Int).Sub(big.NewInt(a), big.NewInt(b)))
+	}
+	return &Integer{Value: diff}
+}
+
+func MulInt64(a, b int64) Object {
+	if a == 0 || b == 0 {
+		return &Integer{Value: 0}
+	}
+	product := a * b
+	if product/b != a || (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+		return NewInteger(new(big.Int).Mul(big.NewInt(a), big.NewInt(b)))
+	}
+	return &Integer{Value: product}
+}
+
+func DivInt64(a, b int64) Object {
+	if a == math.MinInt64 && b == -1 {
+		return NewInteger(new(big.Int).Neg(big.NewInt(a)))
+	}
+	return &Integer{Value: a / b}
+}
+
+func NegInt64(a int64) Object {
+	if a == math.MinInt64 {
+		return NewInteger(new(big.Int).Neg(big.NewInt(a)))
+	}
+	return &Integer{Value: -a}
+}
+
+// ToFloat converts an *Integer, *BigInteger or *Float to a float64.
+func ToFloat(obj Object) float64 {
+	switch obj := obj.(type) {
+	case *Integer:
+		return float64(obj.Value)
+	case *BigInteger:
+		f, _ := new(big.Float).SetInt(obj.Value).Float64()
+		return f
+	case *Float:
+		return obj.Value
+	}
+	return 0
+}
+
+// MaxResultBits limits the size of the results of ** and <<. Larger results
+// take seconds to minutes to compute, or gigabytes of memory, the engines
+// fail with an error instead.
+const MaxResultBits = 1 << 24
+
+// PowTooLarge reports whether a ** b has more than MaxResultBits bits.
+func PowTooLarge(a *big.Int, b int64) bool {
+	// every factor of a adds at least BitLen - 1 bits
+	bits := int64(a.BitLen() - 1)
+	return bits > 0 && b > MaxResultBits/bits
+}
+
+// ShlTooLarge reports whether a << n has more than MaxResultBits bits.
+func ShlTooLarge(a *big.Int, n uint64) bool {
+	return a.Sign() != 0 && (n > MaxResultBits || uint64(a.BitLen())+n > MaxResultBits)
+}
+
+// PowInt returns a ** b. A negative exponent yields a *Float.
+func PowInt(a *big.Int, b int64) Object {
+	if b < 0 {
+		f, _ := new(big.Float).SetInt(a).Float64()
+		return &Float{Value: math.Pow(f, float64(b))}
+	}
+	return NewInteger(new(big.Int).Exp(a, big.NewInt(b), nil))
+}
+
+func ShlInt64(a int64, n uint64) Object {
+	if n < 63 {
+		shifted := a << n
+		if shifted>>n == a {
+			return &Integer{Value: shifted}
+		}
+	}
+	return NewInteger(new(big.Int).Lsh(big.NewInt(a), uint(n)))
+}
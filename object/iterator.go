@@ -0,0 +1,142 @@
+package object
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Range is the lazy sequence of integers produced by the range builtin. It
+// runs from Start up to, but not including, End in increments of Step.
+type Range struct {
+	Start int64
+	End   int64
+	Step  int64
+}
+
+func (r *Range) Type() ObjectType { return RANGE_OBJ }
+
+func (r *Range) Inspect() string {
+	if r.Step == 1 {
+		return fmt.Sprintf("range(%d, %d)", r.Start, r.End)
+	}
+	return fmt.Sprintf("range(%d, %d, %d)", r.Start, r.End, r.Step)
+}
+
+// Len returns the number of integers in the range. Ranges spanning most of
+// the int64 values hold more integers than an int64 can count.
+func (r *Range) Len() uint64 {
+	// the span is at least 1 and at most math.MaxUint64
+	switch {
+	case r.Step > 0 && r.Start < r.End:
+		return (uint64(r.End-r.Start)-1)/uint64(r.Step) + 1
+	case r.Step < 0 && r.Start > r.End:
+		return (uint64(r.Start-r.End)-1)/uint64(-r.Step) + 1
+	}
+	return 0
+}
+
+// Iterator walks over the elements of an array, hash, string or range. It is
+// what for-in loops are built on in both the evaluator and the VM.
+type Iterator struct {
+	next     func() (key, value Object, ok bool)
+	bindsKey bool
+}
+
+func (it *Iterator) Type() ObjectType { return ITERATOR_OBJ }
+
+func (it *Iterator) Inspect() string { return "iterator" }
+
+// Next returns the key and the value of the next element. Keys are indices
+// for arrays, strings and ranges. ok is false once the iterable is exhausted.
+func (it *Iterator) Next() (key, value Object, ok bool) {
+	return it.next()
+}
+
+// NextElement returns what a loop with a single variable binds: the key of
+// a hash and the value of every other iterable.
+func (it *Iterator) NextElement() (Object, bool) {
+	key, value, ok := it.next()
+	if it.bindsKey {
+		return key, ok
+	}
+	return value, ok
+}
+
+// NewIterator returns an iterator over obj or false if obj is not iterable.
+func NewIterator(obj Object) (*Iterator, bool) {
+	i := 0
+	switch obj := obj.(type) {
+	case *Array:
+		return &Iterator{next: func() (Object, Object, bool) {
+			if i >= len(obj.Elements) {
+				return nil, nil, false
+			}
+			i++
+			return &Integer{Value: int64(i - 1)}, obj.Elements[i-1], true
+		}}, true
+	case *String:
+		runes := []rune(obj.Value)
+		return &Iterator{next: func() (Object, Object, bool) {
+			if i >= len(runes) {
+				return nil, nil, false
+			}
+			i++
+			return &Integer{Value: int64(i - 1)}, &String{Value: string(runes[i-1])}, true
+		}}, true
+	case *Range:
+		n := obj.Len()
+		var j uint64
+		return &Iterator{next: func() (Object, Object, bool) {
+			if j >= n {
+				return nil, nil, false
+			}
+			j++
+			// the element fits into an int64, overflows on the way to it
+			// wrap around
+			value := obj.Start + int64(j-1)*obj.Step
+			return &Integer{Value: int64(j - 1)}, &Integer{Value: value}, true
+		}}, true
+	case *Hash:
+		pairs := obj.SortedPairs()
+		return &Iterator{bindsKey: true, next: func() (Object, Object, bool) {
+			if i >= len(pairs) {
+				return nil, nil, false
+			}
+			i++
+			return pairs[i-1].Key, pairs[i-1].Value, true
+		}}, true
+	}
+	return nil, false
+}
+
+// SortedPairs returns the pairs of the hash ordered by key, so that
+// iteration does not depend on Go's map order. Keys are grouped by type;
+// integers and floats compare by value, strings lexically and false sorts
+// before true.
+func (h *Hash) SortedPairs() []HashPair {
+	pairs := make([]HashPair, 0, len(h.Pairs))
+	for _, pair := range h.Pairs {
+		pairs = append(pairs, pair)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return lessKey(pairs[i].Key, pairs[j].Key)
+	})
+	return pairs
+}
+
+func lessKey(a, b Object) bool {
+	if a.Type() != b.Type() {
+		return a.Type() < b.Type()
+	}
+	switch a := a.(type) {
+	case *Integer, *BigInteger:
+		return ToBigInt(a).Cmp(ToBigInt(b)) < 0
+	case *Float:
+		return a.Value < b.(*Float).Value
+	case *String:
+		return a.Value < b.(*String).Value
+	case *Boolean:
+		return !a.Value && b.(*Boolean).Value
+	}
+	return false
+}
@@ -6,6 +6,10 @@ import (
 	"hash/fnv"
 	"interpreter/ast"
 	"interpreter/code"
+	"interpreter/token"
+	"math"
+	"math/big"
+	"strconv"
 	"strings"
 )
 
@@ -13,15 +17,25 @@ type ObjectType string
 
 const (
 	INTEGER_OBJ       = "INTEGER"
+	FLOAT_OBJ         = "FLOAT"
 	BOOLEAN_OBJ       = "BOOLEAN"
 	NULL_OBJ          = "NULL"
 	RETURN_VALUE_OBJ  = "RETURN_VALUE"
+	BREAK_OBJ         = "BREAK"
+	CONTINUE_OBJ      = "CONTINUE"
+	TAIL_CALL_OBJ     = "TAIL_CALL"
 	ERROR_OBJ         = "ERROR"
+	EXCEPTION_OBJ     = "EXCEPTION"
 	FUNCTION_OBJ      = "FUNCTION"
 	STRING_OBJ        = "STRING"
 	BUILTIN_OBJ       = "BUILTIN"
 	ARRAY_OBJ         = "ARRAY"
 	HASH_OBJ          = "HASH"
+	RANGE_OBJ         = "RANGE"
+	ITERATOR_OBJ      = "ITERATOR"
+	UPVALUE_OBJ       = "UPVALUE"
+	QUOTE_OBJ         = "QUOTE"
+	MACRO_OBJ         = "MACRO"
 	COMPILED_FUNCTION = "COMPILED_FUNCTION"
 	CLOUSURE_OBJ      = "CLOUSURE_OBJ"
 )
@@ -43,6 +57,22 @@ func (i *Integer) Type() ObjectType {
 	return INTEGER_OBJ
 }
 
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+
+func (f *Float) Type() ObjectType {
+	return FLOAT_OBJ
+}
+
 type Boolean struct {
 	Value bool
 }
@@ -73,14 +103,84 @@ func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 
 func (rv *ReturnValue) Inspect() string { return rv.Value.Inspect() }
 
+// Break and Continue are produced by the evaluator for break and continue
+// statements and unwind the enclosing blocks up to the innermost loop.
+type Break struct{}
+
+func (b *Break) Type() ObjectType { return BREAK_OBJ }
+
+func (b *Break) Inspect() string { return "break" }
+
+type Continue struct{}
+
+func (c *Continue) Type() ObjectType { return CONTINUE_OBJ }
+
+func (c *Continue) Inspect() string { return "continue" }
+
+// TailCall is a call in tail position the evaluator has not made yet. It is
+// returned to the applyFunction of the calling function, which makes the
+// call in its place, so that tail recursion does not grow the Go stack.
+type TailCall struct {
+	Fn   *Function
+	Args []Object
+	Pos  token.Position // of the call expression
+}
+
+func (tc *TailCall) Type() ObjectType { return TAIL_CALL_OBJ }
+
+func (tc *TailCall) Inspect() string { return "tail call" }
+
+// Error is a failure, or a thrown value, on its way to a catch block or the
+// end of the program. Stack holds the source positions it passed, the
+// innermost first.
 type Error struct {
 	Message string
+	Stack   []string
+	// Value is the value thrown by a throw statement, nil for the errors
+	// of the runtime
+	Value Object
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
 
 func (e *Error) Inspect() string { return "ERROR: " + e.Message }
 
+// Exception is an error caught by a catch block. Monkey code reads its
+// message, stack trace and thrown value as e["message"], e["stack"] and
+// e["value"]. The stack trace holds the positions of the calls the error
+// passed through, except for calls in tail position, whose callers were
+// gone by the time the error occurred.
+type Exception struct {
+	Message string
+	Stack   []string
+	// Value is the value thrown by a throw statement, nil for the errors
+	// of the runtime
+	Value Object
+}
+
+func (e *Exception) Type() ObjectType { return EXCEPTION_OBJ }
+
+func (e *Exception) Inspect() string { return "exception: " + e.Message }
+
+// Field returns the member of e named by key: the message as a String for
+// "message", the stack trace as an Array of Strings for "stack" and the
+// value thrown for "value", if any.
+func (e *Exception) Field(key string) (Object, bool) {
+	switch key {
+	case "message":
+		return &String{Value: e.Message}, true
+	case "value":
+		return e.Value, e.Value != nil
+	case "stack":
+		elements := make([]Object, len(e.Stack))
+		for i, pos := range e.Stack {
+			elements[i] = &String{Value: pos}
+		}
+		return &Array{Elements: elements}, true
+	}
+	return nil, false
+}
+
 func NewEnclosedEnvironment(outer *Environment) *Environment {
 	env := NewEnvironment()
 	env.outer = outer
@@ -110,6 +210,19 @@ func (e *Environment) Set(name string, val Object) Object {
 	return val
 }
 
+// Assign updates an existing binding in the innermost environment that
+// defines name. It returns false if name is not bound anywhere.
+func (e *Environment) Assign(name string, val Object) bool {
+	if _, ok := e.store[name]; ok {
+		e.store[name] = val
+		return true
+	}
+	if e.outer != nil {
+		return e.outer.Assign(name, val)
+	}
+	return false
+}
+
 type Function struct {
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
@@ -132,6 +245,38 @@ func (f *Function) Inspect() string {
 	return out.String()
 }
 
+// Quote wraps an unevaluated AST node, see the quote builtin.
+type Quote struct {
+	Node ast.Node
+}
+
+func (q *Quote) Type() ObjectType { return QUOTE_OBJ }
+func (q *Quote) Inspect() string {
+	return "QUOTE(" + q.Node.String() + ")"
+}
+
+type Macro struct {
+	Parameters []*ast.Identifier
+	Body       *ast.BlockStatement
+	Env        *Environment
+}
+
+func (m *Macro) Type() ObjectType { return MACRO_OBJ }
+func (m *Macro) Inspect() string {
+	var out bytes.Buffer
+	params := []string{}
+	for _, p := range m.Parameters {
+		params = append(params, p.String())
+	}
+	out.WriteString("macro")
+	out.WriteString("(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(") {\n")
+	out.WriteString(m.Body.String())
+	out.WriteString("\n}")
+	return out.String()
+}
+
 type String struct {
 	Value string
 }
@@ -215,6 +360,19 @@ func (i *Integer) HashKey() HashKey {
 	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
 }
 
+// HashKey gives floats equal to an integer, like 1.0 or -0.0, the key of
+// that integer, so that keys comparing equal find the same pair.
+func (f *Float) HashKey() HashKey {
+	if f.Value == math.Trunc(f.Value) && !math.IsInf(f.Value, 0) {
+		if f.Value >= math.MinInt64 && f.Value < math.MaxInt64 {
+			return (&Integer{Value: int64(f.Value)}).HashKey()
+		}
+		i, _ := big.NewFloat(f.Value).Int(nil)
+		return (&BigInteger{Value: i}).HashKey()
+	}
+	return HashKey{Type: f.Type(), Value: math.Float64bits(f.Value)}
+}
+
 func (s *String) HashKey() HashKey {
 	h := fnv.New64a()
 	h.Write([]byte(s.Value))
@@ -225,6 +383,8 @@ type CompiledFunction struct {
 	Instructions  code.Instructions
 	NumLocals     int
 	NumParameters int
+	SourceMap     code.SourceMap
+	Handlers      []code.Handler
 }
 
 func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION }
@@ -235,7 +395,7 @@ func (cf *CompiledFunction) Inspect() string {
 
 type Closure struct {
 	Fn   *CompiledFunction
-	Free []Object
+	Free []*Upvalue
 }
 
 func (c *Closure) Type() ObjectType { return CLOUSURE_OBJ }
@@ -243,3 +403,35 @@ func (c *Closure) Type() ObjectType { return CLOUSURE_OBJ }
 func (c *Closure) Inspect() string {
 	return fmt.Sprintf("Closure[%p]", c)
 }
+
+// Upvalue is a variable captured by a closure. While the function defining
+// the variable runs, the upvalue is open and points at the variable's stack
+// slot, so that the function and all closures capturing the variable share
+// it. Close moves the value into the upvalue once the slot goes away.
+type Upvalue struct {
+	Location *Object
+	Closed   Object
+}
+
+// NewClosedUpvalue returns an upvalue holding value that is not backed by a
+// stack slot.
+func NewClosedUpvalue(value Object) *Upvalue {
+	u := &Upvalue{Closed: value}
+	u.Location = &u.Closed
+	return u
+}
+
+func (u *Upvalue) Type() ObjectType { return UPVALUE_OBJ }
+
+func (u *Upvalue) Inspect() string {
+	return fmt.Sprintf("Upvalue[%p]", u)
+}
+
+func (u *Upvalue) Get() Object { return *u.Location }
+
+func (u *Upvalue) Set(value Object) { *u.Location = value }
+
+func (u *Upvalue) Close() {
+	u.Closed = *u.Location
+	u.Location = &u.Closed
+}
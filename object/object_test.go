@@ -1,6 +1,9 @@
 package object
 
 import (
+	"math"
+	"math/big"
+	"strings"
 	"testing"
 )
 
@@ -19,3 +22,94 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("strings with different content have same hash keys")
 	}
 }
+
+func TestFloatHashKey(t *testing.T) {
+	tests := []struct {
+		float   float64
+		integer Object
+	}{
+		{1.0, &Integer{Value: 1}},
+		{-0.0, &Integer{Value: 0}},
+		{math.Copysign(0, -1), &Integer{Value: 0}},
+		{-3.0, &Integer{Value: -3}},
+		{math.MinInt64, &Integer{Value: math.MinInt64}},
+		{1 << 63, NewInteger(new(big.Int).Lsh(big.NewInt(1), 63))},
+		{1e20, NewInteger(new(big.Int).Exp(big.NewInt(10), big.NewInt(20), nil))},
+	}
+	for _, tt := range tests {
+		key := (&Float{Value: tt.float}).HashKey()
+		if key != tt.integer.(Hashable).HashKey() {
+			t.Errorf("float %g has a different hash key than integer %s", tt.float, tt.integer.Inspect())
+		}
+	}
+
+	half := (&Float{Value: 0.5}).HashKey()
+	if half == (&Integer{Value: 0}).HashKey() || half == (&Float{Value: 1.5}).HashKey() {
+		t.Errorf("float 0.5 shares its hash key")
+	}
+	if (&Float{Value: math.Inf(1)}).HashKey() == (&Float{Value: math.Inf(-1)}).HashKey() {
+		t.Errorf("infinities share their hash key")
+	}
+}
+
+func TestIntegerOverflowPromotion(t *testing.T) {
+	tests := []struct {
+		result   Object
+		expected string
+		big      bool
+	}{
+		{AddInt64(1, 2), "3", false},
+		{AddInt64(math.MaxInt64, 1), "9223372036854775808", true},
+		{AddInt64(math.MinInt64, -1), "-9223372036854775809", true},
+		{SubInt64(math.MinInt64, 1), "-9223372036854775809", true},
+		{SubInt64(0, math.MinInt64), "9223372036854775808", true},
+		{MulInt64(math.MaxInt64, 2), "18446744073709551614", true},
+		{MulInt64(-1, math.MinInt64), "9223372036854775808", true},
+		{MulInt64(-3, 7), "-21", false},
+		{DivInt64(math.MinInt64, -1), "9223372036854775808", true},
+		{NegInt64(math.MinInt64), "9223372036854775808", true},
+		{NewInteger(big.NewInt(42)), "42", false},
+	}
+	for i, tt := range tests {
+		if tt.result.Inspect() != tt.expected {
+			t.Errorf("tests[%d] wrong value. want=%s, got=%s", i, tt.expected, tt.result.Inspect())
+		}
+		if _, ok := tt.result.(*BigInteger); ok != tt.big {
+			t.Errorf("tests[%d] wrong representation. got=%T", i, tt.result)
+		}
+		if tt.result.Type() != INTEGER_OBJ {
+			t.Errorf("tests[%d] wrong type. got=%s", i, tt.result.Type())
+		}
+	}
+}
+
+func TestRangeLen(t *testing.T) {
+	tests := []struct {
+		r        Range
+		expected uint64
+	}{
+		{Range{Start: 0, End: 10, Step: 3}, 4},
+		{Range{Start: 0, End: 9, Step: 3}, 3},
+		{Range{Start: 5, End: 0, Step: 1}, 0},
+		{Range{Start: 10, End: 0, Step: -3}, 4},
+		{Range{Start: math.MinInt64, End: math.MaxInt64, Step: 1}, math.MaxUint64},
+		{Range{Start: math.MinInt64, End: math.MaxInt64, Step: 2}, 1 << 63},
+		{Range{Start: math.MinInt64, End: math.MaxInt64, Step: math.MaxInt64}, 3},
+		{Range{Start: math.MaxInt64, End: math.MinInt64, Step: math.MinInt64}, 2},
+		{Range{Start: math.MaxInt64, End: math.MinInt64, Step: -1}, math.MaxUint64},
+	}
+	for _, tt := range tests {
+		if got := tt.r.Len(); got != tt.expected {
+			t.Errorf("%s: wrong length. want=%d, got=%d", tt.r.Inspect(), tt.expected, got)
+		}
+	}
+
+	it, _ := NewIterator(&Range{Start: math.MaxInt64, End: math.MinInt64, Step: math.MinInt64})
+	var values []string
+	for value, ok := it.NextElement(); ok; value, ok = it.NextElement() {
+		values = append(values, value.Inspect())
+	}
+	if got := strings.Join(values, " "); got != "9223372036854775807 -1" {
+		t.Errorf("wrong elements. got=%s", got)
+	}
+}
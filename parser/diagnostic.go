@@ -0,0 +1,102 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"interpreter/token"
+	"strings"
+)
+
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityWarning:
+		return "warning"
+	default:
+		return "error"
+	}
+}
+
+const (
+	ErrUnexpectedToken = "P001" // a specific token was expected but another one was found
+	ErrMissingOperand  = "P002" // a token cannot start an expression
+	ErrInvalidLiteral  = "P003" // a literal could not be converted to a value
+	ErrIllegalToken    = "P004" // the lexer produced an ILLEGAL token
+	ErrInvalidTarget   = "P005" // the left side of an assignment cannot be assigned to
+)
+
+type Span struct {
+	Start token.Position
+	End   token.Position
+}
+
+type Diagnostic struct {
+	Severity Severity
+	Span     Span
+	Code     string
+	Message  string
+	Hint     string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s[%s]: %s", d.Span.Start, d.Severity, d.Code, d.Message)
+}
+
+// Render formats the diagnostic together with the source line it points at
+// and a caret marking the offending span. source must be the input the
+// parser was fed.
+func (d Diagnostic) Render(source string) string {
+	var out bytes.Buffer
+	out.WriteString(d.String())
+	out.WriteString("\n")
+
+	start := d.Span.Start
+	if start.IsValid() && start.Offset <= len(source) {
+		lineStart := strings.LastIndexByte(source[:start.Offset], '\n') + 1
+		lineEnd := strings.IndexByte(source[lineStart:], '\n')
+		if lineEnd < 0 {
+			lineEnd = len(source)
+		} else {
+			lineEnd += lineStart
+		}
+		line := source[lineStart:lineEnd]
+
+		width := 1
+		if d.Span.End.Line == start.Line && d.Span.End.Offset > start.Offset {
+			width = d.Span.End.Offset - start.Offset
+		}
+		if start.Offset+width > lineEnd {
+			width = lineEnd - start.Offset
+		}
+		if width < 1 {
+			width = 1
+		}
+
+		// keep tabs so the caret lines up with the source as displayed
+		var indent bytes.Buffer
+		for _, ch := range []byte(line[:start.Offset-lineStart]) {
+			if ch == '\t' {
+				indent.WriteByte('\t')
+			} else {
+				indent.WriteByte(' ')
+			}
+		}
+		out.WriteString(line)
+		out.WriteString("\n")
+		out.WriteString(indent.String())
+		out.WriteString(strings.Repeat("^", width))
+		out.WriteString("\n")
+	}
+	if d.Hint != "" {
+		out.WriteString("hint: ")
+		out.WriteString(d.Hint)
+		out.WriteString("\n")
+	}
+	return out.String()
+}
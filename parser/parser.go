@@ -1,21 +1,26 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
 	"interpreter/ast"
 	"interpreter/lexer"
 	"interpreter/token"
+	"math/big"
 	"strconv"
 )
 
 const (
 	_ int = iota
 	LOWEST
+	LOGICAL_OR  // ||
+	LOGICAL_AND // &&
 	EQUALS      // ==
 	LESSGREATER // > or <
-	SUM         // +
-	PRODUCT     // *
-	PREFIX      // -X or !X
+	SUM         // + - | ^
+	PRODUCT     // * / % << >> &
+	PREFIX      // -X or !X or ~X
+	POWER       // **
 	CALL        // myFunction(X)
 	INDEX
 )
@@ -25,14 +30,34 @@ var precedences = map[token.TokenType]int{
 	token.NOT_EQ:   EQUALS,
 	token.LT:       LESSGREATER,
 	token.GT:       LESSGREATER,
+	token.LT_EQ:    LESSGREATER,
+	token.GT_EQ:    LESSGREATER,
+	token.AND:      LOGICAL_AND,
+	token.OR:       LOGICAL_OR,
 	token.PLUS:     SUM,
 	token.MINUS:    SUM,
 	token.SLASH:    PRODUCT,
 	token.ASTERISK: PRODUCT,
+	token.PERCENT:  PRODUCT,
+	token.SHL:      PRODUCT,
+	token.SHR:      PRODUCT,
+	token.BIT_AND:  PRODUCT,
+	token.BIT_OR:   SUM,
+	token.BIT_XOR:  SUM,
+	token.POWER:    POWER,
 	token.LPAREN:   CALL,
 	token.LBRACKET: INDEX,
 }
 
+// Precedence returns the binding power of the infix operator op, or LOWEST
+// if op is not an infix operator.
+func Precedence(op string) int {
+	if p, ok := precedences[token.TokenType(op)]; ok {
+		return p
+	}
+	return LOWEST
+}
+
 type prefixParseFn func() ast.Expression
 type infixParseFn func(ast.Expression) ast.Expression
 
@@ -45,33 +70,56 @@ type Parser struct {
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
 
-	errors []string
+	errors []Diagnostic
+	// recovering is set after an error has been reported and cleared once
+	// the parser has synchronized on the next statement, so that a single
+	// mistake does not produce a cascade of follow-on errors.
+	recovering bool
+	// depth is the number of unclosed braces at curToken.
+	depth int
+	// comments collects the comments of all tokens read so far.
+	comments []token.Comment
 }
 
 func New(l *lexer.Lexer) *Parser {
-	p := &Parser{l: l, errors: []string{}}
+	p := &Parser{l: l, errors: []Diagnostic{}}
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
 	p.registerPrefix(token.LBRACE, p.parseMapLiteral)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
+	p.registerPrefix(token.RAW_STRING, p.parseStringLiteral)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
+	p.registerPrefix(token.MACRO, p.parseMacroLiteral)
 	p.registerPrefix(token.IF, p.parseIfExpression)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.TRUE, p.parseBoolLiteral)
 	p.registerPrefix(token.FALSE, p.parseBoolLiteral)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
+	p.registerPrefix(token.BIT_NOT, p.parsePrefixExpression)
 	p.registerInfix(token.PLUS, p.parseInfixExpression)
 	p.registerInfix(token.MINUS, p.parseInfixExpression)
 	p.registerInfix(token.SLASH, p.parseInfixExpression)
 	p.registerInfix(token.ASTERISK, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
+	p.registerInfix(token.POWER, p.parseInfixExpression)
+	p.registerInfix(token.BIT_AND, p.parseInfixExpression)
+	p.registerInfix(token.BIT_OR, p.parseInfixExpression)
+	p.registerInfix(token.BIT_XOR, p.parseInfixExpression)
+	p.registerInfix(token.SHL, p.parseInfixExpression)
+	p.registerInfix(token.SHR, p.parseInfixExpression)
 	p.registerInfix(token.EQ, p.parseInfixExpression)
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.LT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.GT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.AND, p.parseInfixExpression)
+	p.registerInfix(token.OR, p.parseInfixExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
 	p.nextToken()
@@ -103,13 +151,93 @@ func (p *Parser) parsePrefixExpression() ast.Expression {
 	return exp
 }
 
-func (p *Parser) Errors() []string {
+func (p *Parser) Errors() []Diagnostic {
 	return p.errors
 }
 
+func (p *Parser) addError(tok token.Token, code, hint, format string, a ...interface{}) {
+	if p.recovering {
+		return
+	}
+	p.recovering = true
+	p.errors = append(p.errors, Diagnostic{
+		Severity: SeverityError,
+		Span:     Span{Start: tok.Pos, End: tok.End},
+		Code:     code,
+		Message:  fmt.Sprintf(format, a...),
+		Hint:     hint,
+	})
+}
+
 func (p *Parser) peekError(tp token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s but got %s", tp, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	if p.peekTokenIs(token.ILLEGAL) {
+		p.illegalTokenError(p.peekToken)
+		return
+	}
+	hint := ""
+	switch tp {
+	case token.RPAREN, token.RBRACKET, token.RBRACE:
+		hint = fmt.Sprintf("add the missing %s", closingLiterals[tp])
+	}
+	p.addError(p.peekToken, ErrUnexpectedToken, hint,
+		"expected next token to be %s but got %s", tp, describeToken(p.peekToken))
+}
+
+func (p *Parser) illegalTokenError(tok token.Token) {
+	p.addError(tok, ErrIllegalToken, "", "%s", tok.Literal)
+}
+
+var closingLiterals = map[token.TokenType]string{
+	token.RPAREN:   "')'",
+	token.RBRACKET: "']'",
+	token.RBRACE:   "'}'",
+}
+
+func describeToken(tok token.Token) string {
+	if tok.Type == token.EOF {
+		return "end of input"
+	}
+	return fmt.Sprintf("%s %q", tok.Type, tok.Literal)
+}
+
+// parseStatementWithRecovery parses a single statement and, if it contained
+// an error, skips the rest of it so that parsing can continue with the next
+// one. It reports whether curToken is the '}' closing the enclosing block,
+// in which case the caller must not advance past it.
+func (p *Parser) parseStatementWithRecovery() (ast.Statement, bool) {
+	base := p.depth
+	if p.curTokenIs(token.LBRACE) {
+		base--
+	}
+	stmt := p.parseStatement()
+	if !p.recovering {
+		return stmt, false
+	}
+	p.synchronize(base)
+	p.recovering = false
+	return nil, p.curTokenIs(token.RBRACE) && p.depth < base
+}
+
+// synchronize skips tokens until curToken is the last token of the broken
+// statement: a semicolon, the token before a new statement or the token
+// before the '}' closing the enclosing block. base is the brace depth the
+// statement started at.
+func (p *Parser) synchronize(base int) {
+	for !p.curTokenIs(token.EOF) {
+		if p.depth < base {
+			return
+		}
+		if p.depth == base {
+			if p.curTokenIs(token.SEMICOLON) {
+				return
+			}
+			switch p.peekToken.Type {
+			case token.LET, token.RETURN, token.WHILE, token.FOR, token.BREAK, token.CONTINUE, token.TRY, token.THROW, token.RBRACE, token.EOF:
+				return
+			}
+		}
+		p.nextToken()
+	}
 }
 
 func (p *Parser) parseStatement() ast.Statement {
@@ -118,18 +246,77 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.WHILE:
+		return p.parseWhileStatement()
+	case token.FOR:
+		return p.parseForStatement()
+	case token.TRY:
+		return p.parseTryStatement()
+	case token.THROW:
+		return p.parseThrowStatement()
+	case token.BREAK:
+		stmt := &ast.BreakStatement{Token: p.curToken}
+		if p.peekTokenIs(token.SEMICOLON) {
+			p.nextToken()
+		}
+		return stmt
+	case token.CONTINUE:
+		stmt := &ast.ContinueStatement{Token: p.curToken}
+		if p.peekTokenIs(token.SEMICOLON) {
+			p.nextToken()
+		}
+		return stmt
 	default:
 		return p.parseExpressionStatement()
 	}
 }
 
-func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
+var assignOperators = map[token.TokenType]bool{
+	token.ASSIGN:          true,
+	token.PLUS_ASSIGN:     true,
+	token.MINUS_ASSIGN:    true,
+	token.ASTERISK_ASSIGN: true,
+	token.SLASH_ASSIGN:    true,
+}
+
+func (p *Parser) parseExpressionStatement() ast.Statement {
 	stmt := &ast.ExpressionStatement{
 		Token:      p.curToken,
 		Expression: nil,
 	}
 	stmt.Expression = p.parseExpression(LOWEST)
 
+	if assignOperators[p.peekToken.Type] {
+		p.nextToken()
+		return p.parseAssignStatement(stmt.Expression)
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+func (p *Parser) parseAssignStatement(target ast.Expression) ast.Statement {
+	stmt := &ast.AssignStatement{
+		Token:    p.curToken,
+		Target:   target,
+		Operator: p.curToken.Literal,
+	}
+	switch target.(type) {
+	case *ast.Identifier, *ast.IndexExpression:
+	case nil:
+		return nil
+	default:
+		tok := token.Token{Pos: target.Pos(), End: target.End()}
+		p.addError(tok, ErrInvalidTarget, "only variables and index expressions can be assigned to",
+			"cannot assign to %s", target)
+		return nil
+	}
+
+	p.nextToken()
+	stmt.Value = p.parseExpression(LOWEST)
+
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
@@ -169,8 +356,12 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 	return leftExp
 }
 
-func (p *Parser) noPrefixParseFnError(token token.Token) {
-	p.errors = append(p.errors, fmt.Sprintf("no prefix fn for '%s'", token.Literal))
+func (p *Parser) noPrefixParseFnError(tok token.Token) {
+	if tok.Type == token.ILLEGAL {
+		p.illegalTokenError(tok)
+		return
+	}
+	p.addError(tok, ErrMissingOperand, "", "expected an expression but got %s", describeToken(tok))
 }
 
 func (p *Parser) parseLetStatement() ast.Statement {
@@ -218,6 +409,13 @@ func (p *Parser) expectedPeek(tp token.TokenType) bool {
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
+	p.comments = append(p.comments, p.peekToken.Comments...)
+	switch p.curToken.Type {
+	case token.LBRACE:
+		p.depth++
+	case token.RBRACE:
+		p.depth--
+	}
 }
 
 func (p *Parser) ParseProgram() *ast.Program {
@@ -226,7 +424,7 @@ func (p *Parser) ParseProgram() *ast.Program {
 
 	for p.curToken.Type != token.EOF {
 
-		stmt := p.parseStatement()
+		stmt, _ := p.parseStatementWithRecovery()
 
 		if stmt != nil {
 			program.Statements = append(program.Statements, stmt)
@@ -234,6 +432,7 @@ func (p *Parser) ParseProgram() *ast.Program {
 
 		p.nextToken()
 	}
+	program.Comments = p.comments
 
 	return program
 }
@@ -249,14 +448,154 @@ func (p *Parser) parseReturnStatement() ast.Statement {
 	return stmt
 }
 
+func (p *Parser) parseWhileStatement() ast.Statement {
+	stmt := &ast.WhileStatement{Token: p.curToken}
+
+	if !p.expectedPeek(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+
+	stmt.Condition = p.parseExpression(LOWEST)
+
+	if !p.expectedPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectedPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseBlockStatement()
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+func (p *Parser) parseForStatement() ast.Statement {
+	stmt := &ast.ForStatement{Token: p.curToken}
+
+	if !p.expectedPeek(token.LPAREN) {
+		return nil
+	}
+	if !p.expectedPeek(token.IDENT) {
+		return nil
+	}
+	stmt.Value = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		if !p.expectedPeek(token.IDENT) {
+			return nil
+		}
+		stmt.Key = stmt.Value
+		stmt.Value = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	}
+
+	if !p.expectedPeek(token.IN) {
+		return nil
+	}
+	p.nextToken()
+
+	stmt.Iterable = p.parseExpression(LOWEST)
+
+	if !p.expectedPeek(token.RPAREN) {
+		return nil
+	}
+	if !p.expectedPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseBlockStatement()
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+func (p *Parser) parseTryStatement() ast.Statement {
+	stmt := &ast.TryStatement{Token: p.curToken}
+
+	if !p.expectedPeek(token.LBRACE) {
+		return nil
+	}
+	stmt.Body = p.parseBlockStatement()
+
+	if p.peekTokenIs(token.CATCH) {
+		p.nextToken()
+		if !p.expectedPeek(token.LPAREN) {
+			return nil
+		}
+		if !p.expectedPeek(token.IDENT) {
+			return nil
+		}
+		stmt.Param = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		if !p.expectedPeek(token.RPAREN) {
+			return nil
+		}
+		if !p.expectedPeek(token.LBRACE) {
+			return nil
+		}
+		stmt.Catch = p.parseBlockStatement()
+	}
+
+	if p.peekTokenIs(token.FINALLY) {
+		p.nextToken()
+		if !p.expectedPeek(token.LBRACE) {
+			return nil
+		}
+		stmt.Finally = p.parseBlockStatement()
+	}
+
+	if stmt.Catch == nil && stmt.Finally == nil {
+		p.addError(p.peekToken, ErrUnexpectedToken, "add a catch or a finally block",
+			"expected next token to be %s or %s but got %s", token.CATCH, token.FINALLY, describeToken(p.peekToken))
+		return nil
+	}
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+func (p *Parser) parseThrowStatement() ast.Statement {
+	stmt := &ast.ThrowStatement{Token: p.curToken}
+
+	p.nextToken()
+	stmt.Value = p.parseExpression(LOWEST)
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
 func (p *Parser) parseIntegerLiteral() ast.Expression {
 	lit := &ast.IntegerLiteral{Token: p.curToken}
 
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		// too large for an int64, the literal becomes a big integer
+		if value, ok := new(big.Int).SetString(p.curToken.Literal, 0); ok {
+			lit.Big = value
+			return lit
+		}
+	}
 
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.addError(p.curToken, ErrInvalidLiteral, "", "could not parse %q as integer", p.curToken.Literal)
+		return nil
+	}
+	lit.Value = value
+	return lit
+}
+
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	lit := &ast.FloatLiteral{Token: p.curToken}
+
+	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
+
+	if err != nil {
+		p.addError(p.curToken, ErrInvalidLiteral, "", "could not parse %q as float", p.curToken.Literal)
 		return nil
 	}
 	lit.Value = value
@@ -269,8 +608,7 @@ func (p *Parser) parseBoolLiteral() ast.Expression {
 	value, err := strconv.ParseBool(p.curToken.Literal)
 
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.addError(p.curToken, ErrInvalidLiteral, "", "could not parse %q as boolean", p.curToken.Literal)
 		return nil
 	}
 	lit.Value = value
@@ -298,6 +636,10 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 		Left:     left,
 	}
 	precedence := p.curPrecedence()
+	if p.curTokenIs(token.POWER) {
+		// ** is right-associative
+		precedence--
+	}
 	p.nextToken()
 	exp.Right = p.parseExpression(precedence)
 	return exp
@@ -337,20 +679,24 @@ func (p *Parser) parseIfExpression() ast.Expression {
 }
 
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
-	block := &ast.BlockStatement{}
+	block := &ast.BlockStatement{Token: p.curToken}
 
 	block.Statements = []ast.Statement{}
 
 	p.nextToken()
 
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
-		stmt := p.parseStatement()
+		stmt, closed := p.parseStatementWithRecovery()
 
 		if stmt != nil {
 			block.Statements = append(block.Statements, stmt)
 		}
+		if closed {
+			break
+		}
 		p.nextToken()
 	}
+	block.Rbrace = p.curToken
 
 	return block
 }
@@ -368,6 +714,19 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	return lit
 }
 
+func (p *Parser) parseMacroLiteral() ast.Expression {
+	lit := &ast.MacroLiteral{Token: p.curToken}
+	if !p.expectedPeek(token.LPAREN) {
+		return nil
+	}
+	lit.Parameters = p.parseFunctionParameters()
+	if !p.expectedPeek(token.LBRACE) {
+		return nil
+	}
+	lit.Body = p.parseBlockStatement()
+	return lit
+}
+
 func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 	parameters := []*ast.Identifier{}
 	if p.peekTokenIs(token.RPAREN) {
@@ -394,6 +753,7 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	exp := &ast.CallExpression{Token: p.curToken, Function: function}
 	exp.Arguments = p.parseExpressionList(token.RPAREN)
+	exp.Rparen = p.curToken
 	return exp
 }
 
@@ -427,6 +787,7 @@ func (p *Parser) parseStringLiteral() ast.Expression {
 func (p *Parser) parseArrayLiteral() ast.Expression {
 	result := &ast.ArrayLiteral{Token: p.curToken, Elements: []ast.Expression{}}
 	result.Elements = p.parseExpressionList(token.RBRACKET)
+	result.Rbracket = p.curToken
 	return result
 }
 
@@ -437,15 +798,17 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	if !p.expectedPeek(token.RBRACKET) {
 		return nil
 	}
+	exp.Rbracket = p.curToken
 	return exp
 }
 
 func (p *Parser) parseMapLiteral() ast.Expression {
+	start := p.curToken
 	end := token.TokenType(token.RBRACE)
 	entries := make(map[ast.Expression]ast.Expression)
 	if p.peekTokenIs(end) {
 		p.nextToken()
-		return &ast.HashLiteral{Pairs: entries}
+		return &ast.HashLiteral{Token: start, Pairs: entries, Rbrace: p.curToken}
 	}
 	p.nextToken()
 	key := p.parseExpression(LOWEST)
@@ -471,5 +834,5 @@ func (p *Parser) parseMapLiteral() ast.Expression {
 	if !p.expectedPeek(end) {
 		return nil
 	}
-	return &ast.HashLiteral{Pairs: entries}
+	return &ast.HashLiteral{Token: start, Pairs: entries, Rbrace: p.curToken}
 }
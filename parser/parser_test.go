@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"interpreter/ast"
 	"interpreter/lexer"
+	"strconv"
 	"testing"
 )
 
@@ -102,6 +103,74 @@ func TestIntegerLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestBigIntegerLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"9223372036854775807", "9223372036854775807"},
+		{"9223372036854775808", "9223372036854775808"},
+		{"18446744073709551616", "18446744073709551616"},
+		{"-9223372036854775808", "9223372036854775808"},
+	}
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		var exp ast.Expression = program.Statements[0].(*ast.ExpressionStatement).Expression
+		if prefix, ok := exp.(*ast.PrefixExpression); ok {
+			exp = prefix.Right
+		}
+		literal, ok := exp.(*ast.IntegerLiteral)
+		if !ok {
+			t.Fatalf("exp not *ast.IntegerLiteral. got=%T", exp)
+		}
+		got := strconv.FormatInt(literal.Value, 10)
+		if literal.Big != nil {
+			got = literal.Big.String()
+		}
+		if got != tt.expected {
+			t.Errorf("wrong value for %q. want=%s, got=%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestFloatLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"1.5;", 1.5},
+		{"0.125", 0.125},
+		{"2e3", 2000},
+		{"1.5e-1", 0.15},
+	}
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if len(program.Statements) != 1 {
+			t.Fatalf("program has not enough statements. got=%d",
+				len(program.Statements))
+		}
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T",
+				program.Statements[0])
+		}
+		literal, ok := stmt.Expression.(*ast.FloatLiteral)
+		if !ok {
+			t.Fatalf("exp not *ast.FloatLiteral. got=%T", stmt.Expression)
+		}
+		if literal.Value != tt.expected {
+			t.Errorf("literal.Value not %g. got=%g", tt.expected, literal.Value)
+		}
+	}
+}
+
 func TestParsingPrefixExpressions(t *testing.T) {
 	prefixTests := []struct {
 		input        string
@@ -110,6 +179,7 @@ func TestParsingPrefixExpressions(t *testing.T) {
 	}{
 		{"!5;", "!", 5},
 		{"-15;", "-", 15},
+		{"~15;", "~", 15},
 	}
 	for _, tt := range prefixTests {
 		l := lexer.New(tt.input)
@@ -173,6 +243,17 @@ func TestParsingInfixExpressions(t *testing.T) {
 		{"5 < 5;", 5, "<", 5},
 		{"5 == 5;", 5, "==", 5},
 		{"5 != 5;", 5, "!=", 5},
+		{"5 <= 5;", 5, "<=", 5},
+		{"5 >= 5;", 5, ">=", 5},
+		{"5 % 5;", 5, "%", 5},
+		{"5 ** 5;", 5, "**", 5},
+		{"5 & 5;", 5, "&", 5},
+		{"5 | 5;", 5, "|", 5},
+		{"5 ^ 5;", 5, "^", 5},
+		{"5 << 5;", 5, "<<", 5},
+		{"5 >> 5;", 5, ">>", 5},
+		{"true && false", true, "&&", false},
+		{"true || false", true, "||", false},
 		{"true == true", true, "==", true},
 		{"true != false", true, "!=", false},
 		{"false == false", false, "==", false},
@@ -265,6 +346,50 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"3 + 4 * 5 == 3 * 1 + 4 * 5",
 			"((3 + (4 * 5)) == ((3 * 1) + (4 * 5)))",
 		},
+		{
+			"a <= b == c >= d",
+			"((a <= b) == (c >= d))",
+		},
+		{
+			"a || b && c",
+			"(a || (b && c))",
+		},
+		{
+			"a && b || c && d",
+			"((a && b) || (c && d))",
+		},
+		{
+			"a == b && c < d || !e",
+			"(((a == b) && (c < d)) || (!e))",
+		},
+		{
+			"a ** b ** c",
+			"(a ** (b ** c))",
+		},
+		{
+			"-a ** b * c",
+			"((-(a ** b)) * c)",
+		},
+		{
+			"a * b ** -c",
+			"(a * (b ** (-c)))",
+		},
+		{
+			"a + b % c",
+			"(a + (b % c))",
+		},
+		{
+			"a | b & c ^ d",
+			"((a | (b & c)) ^ d)",
+		},
+		{
+			"a << b + c >> d",
+			"((a << b) + (c >> d))",
+		},
+		{
+			"~a & b == c",
+			"(((~a) & b) == c)",
+		},
 		// bool
 		{
 			"true",
@@ -459,6 +584,173 @@ func TestIfExpression(t *testing.T) {
 	}
 }
 
+func TestAssignStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		operator string
+		expected string
+	}{
+		{"x = 5;", "=", "x = 5;"},
+		{"x += y * 2", "+=", "x += (y * 2);"},
+		{"a[0] -= 1;", "-=", "(a[0]) -= 1;"},
+		{"h[\"k\"] *= 2", "*=", "(h[k]) *= 2;"},
+		{"x /= fn() { 2 }()", "/=", "x /= fn() 2();"},
+	}
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statement. got=%d",
+				len(program.Statements))
+		}
+		stmt, ok := program.Statements[0].(*ast.AssignStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.AssignStatement. got=%T",
+				program.Statements[0])
+		}
+		if stmt.Operator != tt.operator {
+			t.Errorf("stmt.Operator is not %q. got=%q", tt.operator, stmt.Operator)
+		}
+		if got := program.String(); got != tt.expected {
+			t.Errorf("program.String() wrong. want=%q, got=%q", tt.expected, got)
+		}
+	}
+}
+
+func TestWhileStatement(t *testing.T) {
+	input := `while (x < y) { break; continue }`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Body does not contain %d statements. got=%d\n",
+			1, len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.WhileStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.WhileStatement. got=%T",
+			program.Statements[0])
+	}
+	if !testInfixExpression(t, stmt.Condition, "x", "<", "y") {
+		return
+	}
+	if len(stmt.Body.Statements) != 2 {
+		t.Fatalf("body is not 2 statements. got=%d\n",
+			len(stmt.Body.Statements))
+	}
+	if _, ok := stmt.Body.Statements[0].(*ast.BreakStatement); !ok {
+		t.Errorf("Statements[0] is not ast.BreakStatement. got=%T",
+			stmt.Body.Statements[0])
+	}
+	if _, ok := stmt.Body.Statements[1].(*ast.ContinueStatement); !ok {
+		t.Errorf("Statements[1] is not ast.ContinueStatement. got=%T",
+			stmt.Body.Statements[1])
+	}
+	if got := program.String(); got != "while(x < y) break;continue;" {
+		t.Errorf("program.String() wrong. got=%q", got)
+	}
+}
+
+func TestForStatement(t *testing.T) {
+	tests := []struct {
+		input    string
+		key      string
+		value    string
+		expected string
+	}{
+		{"for (x in xs) { x }", "", "x", "for(x in xs) x"},
+		{"for (k, v in {1: 2}) { v; }", "k", "v", "for(k, v in {1: 2, }) v"},
+		{"for (c in \"abc\") { continue; };", "", "c", "for(c in abc) continue;"},
+	}
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Body does not contain %d statements. got=%d\n",
+				1, len(program.Statements))
+		}
+		stmt, ok := program.Statements[0].(*ast.ForStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.ForStatement. got=%T",
+				program.Statements[0])
+		}
+		if tt.key == "" && stmt.Key != nil {
+			t.Errorf("stmt.Key is not nil. got=%s", stmt.Key)
+		}
+		if tt.key != "" && !testIdentifier(t, stmt.Key, tt.key) {
+			return
+		}
+		if !testIdentifier(t, stmt.Value, tt.value) {
+			return
+		}
+		if got := program.String(); got != tt.expected {
+			t.Errorf("program.String() wrong. want=%q, got=%q", tt.expected, got)
+		}
+	}
+}
+
+func TestTryStatement(t *testing.T) {
+	tests := []struct {
+		input      string
+		param      string
+		hasCatch   bool
+		hasFinally bool
+		expected   string
+	}{
+		{"try { f() } catch (e) { e }", "e", true, false, "try f()catch(e) e"},
+		{"try { f() } finally { g(); }", "", false, true, "try f()finally g()"},
+		{"try { f() } catch (err) { } finally { g() };", "err", true, true, "try f()catch(err) finally g()"},
+	}
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Body does not contain %d statements. got=%d\n",
+				1, len(program.Statements))
+		}
+		stmt, ok := program.Statements[0].(*ast.TryStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ast.TryStatement. got=%T",
+				program.Statements[0])
+		}
+		if tt.hasCatch != (stmt.Catch != nil) || tt.hasFinally != (stmt.Finally != nil) {
+			t.Errorf("wrong blocks. catch=%v, finally=%v", stmt.Catch != nil, stmt.Finally != nil)
+		}
+		if tt.param != "" && !testIdentifier(t, stmt.Param, tt.param) {
+			return
+		}
+		if got := program.String(); got != tt.expected {
+			t.Errorf("program.String() wrong. want=%q, got=%q", tt.expected, got)
+		}
+	}
+}
+
+func TestThrowStatement(t *testing.T) {
+	l := lexer.New(`throw "oops"; throw x + 1`)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Body does not contain %d statements. got=%d\n",
+			2, len(program.Statements))
+	}
+	for _, s := range program.Statements {
+		if _, ok := s.(*ast.ThrowStatement); !ok {
+			t.Fatalf("statement is not ast.ThrowStatement. got=%T", s)
+		}
+	}
+	if got := program.String(); got != "throw oops;throw (x + 1);" {
+		t.Errorf("program.String() wrong. got=%q", got)
+	}
+}
+
 func TestFunctionLiteralParsing(t *testing.T) {
 	input := `fn(x, y) { x + y; }`
 	l := lexer.New(input)
@@ -784,3 +1076,202 @@ func TestFunctionLiteralWithName(t *testing.T) {
 			function.Name)
 	}
 }
+
+func TestMacroLiteralParsing(t *testing.T) {
+	input := `macro(x, y) { x + y; }`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d\n",
+			1, len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T",
+			program.Statements[0])
+	}
+	macro, ok := stmt.Expression.(*ast.MacroLiteral)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.MacroLiteral. got=%T",
+			stmt.Expression)
+	}
+	if len(macro.Parameters) != 2 {
+		t.Fatalf("macro literal parameters wrong. want 2, got=%d\n",
+			len(macro.Parameters))
+	}
+	testLiteralExpression(t, macro.Parameters[0], "x")
+	testLiteralExpression(t, macro.Parameters[1], "y")
+	if len(macro.Body.Statements) != 1 {
+		t.Fatalf("macro.Body.Statements has not 1 statements. got=%d\n",
+			len(macro.Body.Statements))
+	}
+	bodyStmt, ok := macro.Body.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("macro body stmt is not ast.ExpressionStatement. got=%T",
+			macro.Body.Statements[0])
+	}
+	testInfixExpression(t, bodyStmt.Expression, "x", "+", "y")
+}
+
+func TestProgramComments(t *testing.T) {
+	input := `// one
+let x = 1; /* two */
+fn() { // three
+}
+// four`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	expected := []string{"// one", "/* two */", "// three", "// four"}
+	if len(program.Comments) != len(expected) {
+		t.Fatalf("wrong number of comments. want=%d, got=%d", len(expected), len(program.Comments))
+	}
+	for i, c := range program.Comments {
+		if c.Text != expected[i] {
+			t.Errorf("comments[%d] wrong. want=%q, got=%q", i, expected[i], c.Text)
+		}
+	}
+}
+
+func TestNodeSpans(t *testing.T) {
+	input := `let add = fn(a, b) {
+  a + b
+};
+add(1, [2, 3][0]);
+{"k": -x}`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	tests := []struct {
+		node  ast.Node
+		start string
+		end   string
+	}{
+		{program, "1:1", "5:10"},
+		{program.Statements[0], "1:1", "3:2"},
+		{program.Statements[0].(*ast.LetStatement).Value, "1:11", "3:2"},
+		{program.Statements[0].(*ast.LetStatement).Value.(*ast.FunctionLiteral).Body, "1:20", "3:2"},
+		{program.Statements[1], "4:1", "4:18"},
+		{program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.CallExpression).Arguments[1], "4:8", "4:17"},
+		{program.Statements[2], "5:1", "5:10"},
+	}
+	for i, tt := range tests {
+		if got := tt.node.Pos().String(); got != tt.start {
+			t.Errorf("tests[%d] %q - wrong start. want=%s, got=%s", i, tt.node, tt.start, got)
+		}
+		if got := tt.node.End().String(); got != tt.end {
+			t.Errorf("tests[%d] %q - wrong end. want=%s, got=%s", i, tt.node, tt.end, got)
+		}
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"let = 5;", []string{
+			`1:5: error[P001]: expected next token to be IDENT but got = "="`,
+		}},
+		{"let x 5; let y = 10;", []string{
+			`1:7: error[P001]: expected next token to be = but got INT "5"`,
+		}},
+		{"let x = ;\nlet y = 1;\nlet z 3;", []string{
+			`1:9: error[P002]: expected an expression but got SEMICOLON ";"`,
+			`3:7: error[P001]: expected next token to be = but got INT "3"`,
+		}},
+		{"if (x { y } let a = 1;", []string{
+			`1:7: error[P001]: expected next token to be RPAREN but got LBRACE "{"`,
+		}},
+		{"fn() { let = 1; let y = ; y }; let z 2;", []string{
+			`1:12: error[P001]: expected next token to be IDENT but got = "="`,
+			`1:25: error[P002]: expected an expression but got SEMICOLON ";"`,
+			`1:38: error[P001]: expected next token to be = but got INT "2"`,
+		}},
+		{"fn() { let x = }; 1 +", []string{
+			`1:16: error[P002]: expected an expression but got RBRACE "}"`,
+			`1:22: error[P002]: expected an expression but got end of input`,
+		}},
+		{"add(1, 2", []string{
+			`1:9: error[P001]: expected next token to be RPAREN but got end of input`,
+		}},
+		{"let x = 1 @ 2;", []string{
+			`1:11: error[P004]: illegal character '@'`,
+		}},
+		{"try { f() } let x = 1;", []string{
+			`1:13: error[P001]: expected next token to be CATCH or FINALLY but got LET "let"`,
+		}},
+		{"try { f() } catch { g() }", []string{
+			`1:19: error[P001]: expected next token to be LPAREN but got LBRACE "{"`,
+		}},
+		{"f() = 1; x = 2; 1 + 2 += 3", []string{
+			`1:1: error[P005]: cannot assign to f()`,
+			`1:17: error[P005]: cannot assign to (1 + 2)`,
+		}},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		errors := p.Errors()
+		if len(errors) != len(tt.expected) {
+			t.Errorf("%q: wrong number of errors. want=%d, got=%d: %v",
+				tt.input, len(tt.expected), len(errors), errors)
+			continue
+		}
+		for i, want := range tt.expected {
+			if got := errors[i].String(); got != want {
+				t.Errorf("%q: errors[%d] wrong.\nwant=%s\ngot =%s", tt.input, i, want, got)
+			}
+		}
+	}
+}
+
+func TestRecoveredProgram(t *testing.T) {
+	input := `let a = ;
+let b = 2;
+let f = fn() { let = 1; b };
+f();`
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 2 {
+		t.Fatalf("wrong number of errors. want=2, got=%d: %v", len(p.Errors()), p.Errors())
+	}
+	if len(program.Statements) != 3 {
+		t.Fatalf("program has wrong number of statements. want=3, got=%d: %s",
+			len(program.Statements), program)
+	}
+	want := "let b = 2;let f = fn<f>() b;f()"
+	if program.String() != want {
+		t.Errorf("program wrong. want=%q, got=%q", want, program.String())
+	}
+}
+
+func TestDiagnosticRender(t *testing.T) {
+	input := "let x = 1;\n\tlet y 2;"
+	p := New(lexer.New(input))
+	p.ParseProgram()
+	if len(p.Errors()) != 1 {
+		t.Fatalf("wrong number of errors. want=1, got=%d", len(p.Errors()))
+	}
+	expected := "2:8: error[P001]: expected next token to be = but got INT \"2\"\n" +
+		"\tlet y 2;\n" +
+		"\t      ^\n"
+	if got := p.Errors()[0].Render(input); got != expected {
+		t.Errorf("render wrong.\nwant=%q\ngot =%q", expected, got)
+	}
+
+	p = New(lexer.New("foo(1, 2"))
+	p.ParseProgram()
+	expected = "1:9: error[P001]: expected next token to be RPAREN but got end of input\n" +
+		"foo(1, 2\n" +
+		"        ^\n" +
+		"hint: add the missing ')'\n"
+	if got := p.Errors()[0].Render("foo(1, 2"); got != expected {
+		t.Errorf("render wrong.\nwant=%q\ngot =%q", expected, got)
+	}
+}
@@ -19,6 +19,7 @@ const (
 func StartInterpreter(in io.Reader, out io.Writer) {
 	scanner := bufio.NewScanner(in)
 	env := object.NewEnvironment()
+	macroEnv := object.NewEnvironment()
 
 	for {
 		fmt.Print(PROMPT)
@@ -35,10 +36,16 @@ func StartInterpreter(in io.Reader, out io.Writer) {
 
 		program := p.ParseProgram()
 		if len(p.Errors()) != 0 {
-			printParserErrors(out, p.Errors())
+			printParserErrors(out, line, p.Errors())
 			continue
 		}
-		evaluated := evaluator.Eval(program, env)
+		evaluator.DefineMacros(program, macroEnv)
+		expanded, err := evaluator.ExpandMacros(program, macroEnv)
+		if err != nil {
+			fmt.Fprintf(out, "Woops! Macro expansion failed:\n %s\n", err)
+			continue
+		}
+		evaluated := evaluator.Eval(expanded, env)
 		if evaluated != nil {
 			io.WriteString(out, evaluated.Inspect())
 			io.WriteString(out, "\n")
@@ -48,9 +55,9 @@ func StartInterpreter(in io.Reader, out io.Writer) {
 
 }
 
-func printParserErrors(out io.Writer, s []string) {
-	for _, msg := range s {
-		io.WriteString(out, "\t"+msg+"\n")
+func printParserErrors(out io.Writer, source string, diagnostics []parser.Diagnostic) {
+	for _, d := range diagnostics {
+		io.WriteString(out, d.Render(source))
 	}
 }
 
@@ -59,6 +66,7 @@ func Start(in io.Reader, out io.Writer) {
 	constants := []object.Object{}
 	globals := make([]object.Object, vm.GlobalsSize)
 	symbolTable := compiler.NewSymbolTable()
+	macroEnv := object.NewEnvironment()
 	for i, v := range object.Builtins {
 		symbolTable.DefineBuiltin(i, v.Name)
 	}
@@ -73,11 +81,17 @@ func Start(in io.Reader, out io.Writer) {
 		p := parser.New(l)
 		program := p.ParseProgram()
 		if len(p.Errors()) != 0 {
-			printParserErrors(out, p.Errors())
+			printParserErrors(out, line, p.Errors())
+			continue
+		}
+		evaluator.DefineMacros(program, macroEnv)
+		expanded, err := evaluator.ExpandMacros(program, macroEnv)
+		if err != nil {
+			fmt.Fprintf(out, "Woops! Macro expansion failed:\n %s\n", err)
 			continue
 		}
 		comp := compiler.NewWithState(symbolTable, constants)
-		err := comp.Compile(program)
+		err = comp.Compile(expanded)
 		if err != nil {
 			fmt.Fprintf(out, "Woops! Compilation failed:\n %s\n", err)
 			continue
@@ -89,7 +103,9 @@ func Start(in io.Reader, out io.Writer) {
 			continue
 		}
 		stackTop := machine.LastPoppedStackElem()
-		io.WriteString(out, stackTop.Inspect())
-		io.WriteString(out, "\n")
+		if stackTop != nil {
+			io.WriteString(out, stackTop.Inspect())
+			io.WriteString(out, "\n")
+		}
 	}
 }
@@ -1,10 +1,13 @@
 package token
 
+import "fmt"
+
 const (
 	ILLEGAL   = "ILLEGAL"
 	EOF       = "EOF"
 	IDENT     = "IDENT"
 	INT       = "INT"
+	FLOAT     = "FLOAT"
 	COMMA     = "COMMA"
 	SEMICOLON = "SEMICOLON"
 	LPAREN    = "LPAREN"
@@ -16,16 +19,32 @@ const (
 	COLON     = ":"
 
 	// Operators
-	ASSIGN   = "="
-	PLUS     = "+"
-	MINUS    = "-"
-	BANG     = "!"
-	ASTERISK = "*"
-	SLASH    = "/"
-	LT       = "<"
-	GT       = ">"
-	EQ       = "=="
-	NOT_EQ   = "!="
+	ASSIGN          = "="
+	PLUS_ASSIGN     = "+="
+	MINUS_ASSIGN    = "-="
+	ASTERISK_ASSIGN = "*="
+	SLASH_ASSIGN    = "/="
+	PLUS            = "+"
+	MINUS           = "-"
+	BANG            = "!"
+	ASTERISK        = "*"
+	SLASH           = "/"
+	PERCENT         = "%"
+	POWER           = "**"
+	BIT_AND         = "&"
+	BIT_OR          = "|"
+	BIT_XOR         = "^"
+	BIT_NOT         = "~"
+	SHL             = "<<"
+	SHR             = ">>"
+	LT              = "<"
+	GT              = ">"
+	EQ              = "=="
+	NOT_EQ          = "!="
+	LT_EQ           = "<="
+	GT_EQ           = ">="
+	AND             = "&&"
+	OR              = "||"
 
 	// Keywords
 	FUNCTION = "FUNCTION"
@@ -35,25 +54,86 @@ const (
 	IF       = "IF"
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
+	WHILE    = "WHILE"
+	BREAK    = "BREAK"
+	CONTINUE = "CONTINUE"
+	FOR      = "FOR"
+	IN       = "IN"
+	MACRO    = "MACRO"
+	TRY      = "TRY"
+	CATCH    = "CATCH"
+	FINALLY  = "FINALLY"
+	THROW    = "THROW"
 
-	STRING = "STRING"
+	STRING     = "STRING"
+	RAW_STRING = "RAW_STRING"
 )
 
 type TokenType string
 
+// Position describes a location in the source. Line and Column start at 1,
+// Offset is the byte offset from the beginning of the input.
+type Position struct {
+	Filename string
+	Offset   int
+	Line     int
+	Column   int
+}
+
+func (p Position) IsValid() bool { return p.Line > 0 }
+
+func (p Position) String() string {
+	s := p.Filename
+	if p.IsValid() {
+		if s != "" {
+			s += ":"
+		}
+		s += fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	if s == "" {
+		s = "-"
+	}
+	return s
+}
+
+// Comment is a line or block comment as it appears in the source,
+// delimiters included.
+type Comment struct {
+	Text string
+	Pos  Position
+	End  Position
+}
+
+// Token is a lexical token. Pos points at the first character of the token
+// and End at the character immediately after it. Comments holds the comments
+// found between the previous token and this one. For ILLEGAL tokens Literal
+// describes the problem.
 type Token struct {
-	Type    TokenType
-	Literal string
+	Type     TokenType
+	Literal  string
+	Pos      Position
+	End      Position
+	Comments []Comment
 }
 
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"true":   TRUE,
-	"false":  FALSE,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
+	"fn":       FUNCTION,
+	"let":      LET,
+	"true":     TRUE,
+	"false":    FALSE,
+	"if":       IF,
+	"else":     ELSE,
+	"return":   RETURN,
+	"while":    WHILE,
+	"break":    BREAK,
+	"continue": CONTINUE,
+	"for":      FOR,
+	"in":       IN,
+	"macro":    MACRO,
+	"try":      TRY,
+	"catch":    CATCH,
+	"finally":  FINALLY,
+	"throw":    THROW,
 }
 
 func LookupIdent(ident string) TokenType {
@@ -0,0 +1,186 @@
+package vm
+
+import (
+	"fmt"
+	"interpreter/code"
+	"interpreter/object"
+	"interpreter/token"
+	"strings"
+)
+
+// RuntimeError is the error Run returns when the program fails, e.g. on a
+// division by zero or a call with the wrong number of arguments. Op and IP
+// locate the failing instruction in the innermost function.
+type RuntimeError struct {
+	Err error
+	Op  code.Opcode
+	IP  int
+	// Stack holds the functions being executed, the innermost first and
+	// the main program last.
+	Stack []StackFrame
+}
+
+// StackFrame is a function being executed when a RuntimeError occurred.
+type StackFrame struct {
+	Fn *object.CompiledFunction
+	// Constant is the index of Fn in the constants, -1 for the main
+	// program.
+	Constant int
+	// IP is the offset of the instruction being executed, the failing one
+	// or a call.
+	IP  int
+	Op  code.Opcode
+	Pos token.Position
+}
+
+func (e *RuntimeError) Error() string { return e.Err.Error() }
+
+func (e *RuntimeError) Unwrap() error { return e.Err }
+
+// StackTrace describes the functions in e.Stack, one per line. Runs of
+// the same call, as left by deep recursion, are shortened to one line.
+//
+//	function 3: 0007 OpDiv (main.mk:2:14)
+//	main program: 0012 OpCall (main.mk:4:1)
+func (e *RuntimeError) StackTrace() string {
+	var out strings.Builder
+	for i := 0; i < len(e.Stack); i++ {
+		f := e.Stack[i]
+		name := "main program"
+		if f.Constant >= 0 {
+			name = fmt.Sprintf("function %d", f.Constant)
+		}
+		fmt.Fprintf(&out, "\t%s: %04d %s", name, f.IP, opName(f.Op))
+		if f.Pos.IsValid() {
+			fmt.Fprintf(&out, " (%s)", f.Pos)
+		}
+		out.WriteString("\n")
+
+		repeated := 0
+		for i+1 < len(e.Stack) && e.Stack[i+1].Fn == f.Fn && e.Stack[i+1].IP == f.IP {
+			repeated++
+			i++
+		}
+		if repeated > 0 {
+			fmt.Fprintf(&out, "\t... repeated %d more times\n", repeated)
+		}
+	}
+	return out.String()
+}
+
+func opName(op code.Opcode) string {
+	def, err := code.Lookup(byte(op))
+	if err != nil {
+		return fmt.Sprintf("opcode %d", op)
+	}
+	return def.Name
+}
+
+// runtimeError wraps err, returned by the instruction op at offset ip of the
+// current frame.
+func (vm *VM) runtimeError(err error, op code.Opcode, ip int) *RuntimeError {
+	e := &RuntimeError{Err: err, Op: op, IP: ip}
+	for i := vm.framesIndex - 1; i >= 0; i-- {
+		frame := vm.frames[i]
+		if i < vm.framesIndex-1 {
+			// callers wait on the last operand byte of their call
+			ip = frame.ip - 2
+			op = code.Opcode(frame.Instructions()[ip])
+		}
+		fn := frame.cl.Fn
+		e.Stack = append(e.Stack, StackFrame{
+			Fn:       fn,
+			Constant: vm.constantIndex(fn),
+			IP:       ip,
+			Op:       op,
+			Pos:      fn.SourceMap.Pos(ip),
+		})
+	}
+	return e
+}
+
+func (vm *VM) constantIndex(fn *object.CompiledFunction) int {
+	for i, c := range vm.constants {
+		if c == fn {
+			return i
+		}
+	}
+	return -1
+}
+
+// rethrown is the error of an OpThrow throwing an exception caught before,
+// the exception keeps its stack trace.
+type rethrown struct {
+	exception *object.Exception
+}
+
+func (e *rethrown) Error() string { return e.exception.Message }
+
+// thrownValue is the error of an OpThrow throwing any other value, which
+// the exception catching it keeps.
+type thrownValue struct {
+	value object.Object
+}
+
+// Error returns the string thrown or the inspected value.
+func (e *thrownValue) Error() string {
+	if s, ok := e.value.(*object.String); ok {
+		return s.Value
+	}
+	return e.value.Inspect()
+}
+
+// thrown returns the error OpThrow fails with for value.
+func thrown(value object.Object) error {
+	if exception, ok := value.(*object.Exception); ok {
+		return &rethrown{exception}
+	}
+	return &thrownValue{value}
+}
+
+// catch looks for a handler covering the failing instruction or, going up
+// the stack, one of the calls leading to it. If there is one, the frames
+// above the handler's function are dropped and execution continues at the
+// handler with an exception holding the message of err and the positions of
+// the dropped calls on the stack.
+func (vm *VM) catch(err error) bool {
+	rerr, ok := err.(*RuntimeError)
+	if !ok {
+		return false
+	}
+	for i, f := range rerr.Stack {
+		h, ok := code.FindHandler(f.Fn.Handlers, f.IP)
+		if !ok {
+			continue
+		}
+		exception := &object.Exception{Message: rerr.Error()}
+		passed := rerr.Stack[:i+1]
+		switch e := rerr.Err.(type) {
+		case *thrownValue:
+			exception.Value = e.value
+		case *rethrown:
+			// continued from the OpThrow on
+			exception.Stack = append(exception.Stack, e.exception.Stack...)
+			exception.Value = e.exception.Value
+			passed = passed[1:]
+		}
+		for _, f := range passed {
+			if f.Pos.IsValid() {
+				exception.Stack = append(exception.Stack, f.Pos.String())
+			}
+		}
+
+		frame := vm.frames[vm.framesIndex-1-i]
+		sp := frame.basePointer + frame.cl.Fn.NumLocals + h.Height
+		if sp >= StackSize {
+			return false
+		}
+		vm.framesIndex -= i
+		frame.ip = h.Target - 1
+		vm.closeUpvalues(sp)
+		vm.stack[sp] = exception
+		vm.sp = sp + 1
+		return true
+	}
+	return false
+}
@@ -0,0 +1,221 @@
+package vm
+
+import (
+	"fmt"
+	"interpreter/code"
+	"interpreter/compiler"
+	"interpreter/object"
+)
+
+// Verify checks bytecode before it is run, so that malformed bytecode, e.g.
+// loaded from a corrupt file, is reported as an error instead of crashing
+// the VM. Besides the checks of code.Verify for the main program and every
+// compiled function it checks that
+//
+//   - constant, local, free variable and builtin indexes are in range,
+//   - OpClosure refers to a compiled function,
+//   - every instruction is reached with the same stack height on all paths
+//     and never pops more values than there are on the stack,
+//   - the instructions covered by a handler keep at least the handler's
+//     height on the stack,
+//   - functions return instead of running off their end.
+//
+// The errors are *code.VerifyError wrapped with the name of the function.
+func Verify(bytecode *compiler.Bytecode) error {
+	v := &verifier{constants: bytecode.Constants, numFree: map[int]int{}}
+
+	main := &object.CompiledFunction{Instructions: bytecode.Instructions, Handlers: bytecode.Handlers}
+	if err := v.structure("main program", main); err != nil {
+		return err
+	}
+	for i, c := range v.constants {
+		if fn, ok := c.(*object.CompiledFunction); ok {
+			if err := v.structure(fmt.Sprintf("function %d", i), fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := v.function("main program", main, 0, true); err != nil {
+		return err
+	}
+	for i, c := range v.constants {
+		fn, ok := c.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+		// functions that are never turned into closures cannot be called
+		numFree, ok := v.numFree[i]
+		if !ok {
+			continue
+		}
+		if err := v.function(fmt.Sprintf("function %d", i), fn, numFree, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type verifier struct {
+	constants []object.Object
+	// numFree maps the constant index of each function to the smallest
+	// number of free variables it is turned into a closure with.
+	numFree map[int]int
+}
+
+type instruction struct {
+	offset   int
+	op       code.Opcode
+	def      *code.Definition
+	operands []int
+	next     int
+}
+
+func decode(ins code.Instructions, offset int) instruction {
+	def, _ := code.Lookup(ins[offset])
+	operands, read := code.ReadOperands(def, ins[offset+1:])
+	return instruction{offset, code.Opcode(ins[offset]), def, operands, offset + 1 + read}
+}
+
+func verifyError(where string, offset int, format string, a ...interface{}) error {
+	return fmt.Errorf("%s: %w", where, &code.VerifyError{Offset: offset, Msg: fmt.Sprintf(format, a...)})
+}
+
+// structure runs code.Verify on fn and checks the operands that do not
+// depend on the function being run, recording the closures it creates.
+func (v *verifier) structure(where string, fn *object.CompiledFunction) error {
+	if err := code.Verify(fn.Instructions); err != nil {
+		return fmt.Errorf("%s: %w", where, err)
+	}
+	if err := code.VerifyHandlers(fn.Instructions, fn.Handlers); err != nil {
+		return fmt.Errorf("%s: %w", where, err)
+	}
+	if fn.NumParameters > fn.NumLocals {
+		return fmt.Errorf("%s: %d parameters exceed %d locals", where, fn.NumParameters, fn.NumLocals)
+	}
+	for i := 0; i < len(fn.Instructions); {
+		in := decode(fn.Instructions, i)
+		switch in.op {
+		case code.OpConstant:
+			if in.operands[0] >= len(v.constants) {
+				return verifyError(where, i, "constant index %d out of range, there are %d constants", in.operands[0], len(v.constants))
+			}
+		case code.OpClosure:
+			index, numFree := in.operands[0], in.operands[1]
+			if index >= len(v.constants) {
+				return verifyError(where, i, "constant index %d out of range, there are %d constants", index, len(v.constants))
+			}
+			if _, ok := v.constants[index].(*object.CompiledFunction); !ok {
+				return verifyError(where, i, "constant %d is %T, not a compiled function", index, v.constants[index])
+			}
+			if n, ok := v.numFree[index]; !ok || numFree < n {
+				v.numFree[index] = numFree
+			}
+		case code.OpGetBuiltin:
+			if in.operands[0] >= len(object.Builtins) {
+				return verifyError(where, i, "builtin index %d out of range, there are %d builtins", in.operands[0], len(object.Builtins))
+			}
+		}
+		i = in.next
+	}
+	return nil
+}
+
+// function checks the operands that depend on fn and follows all paths
+// through fn to check the stack heights. A handler is entered from every
+// instruction it covers.
+func (v *verifier) function(where string, fn *object.CompiledFunction, numFree int, isMain bool) error {
+	ins := fn.Instructions
+	// heights[i] is the stack height before the instruction at i, or -1
+	// if it has not been reached yet
+	heights := make([]int, len(ins)+1)
+	for i := range heights {
+		heights[i] = -1
+	}
+	heights[0] = 0
+	work := []int{0}
+	// covering[i] holds the indexes of the handlers covering offset i
+	covering := make([][]int, len(ins))
+	for i, h := range fn.Handlers {
+		for offset := h.Start; offset < h.End; offset++ {
+			covering[offset] = append(covering[offset], i)
+		}
+	}
+
+	reach := func(from, target, height int) error {
+		if target == len(ins) && !isMain {
+			return verifyError(where, from, "execution runs off the end of the function")
+		}
+		switch heights[target] {
+		case -1:
+			heights[target] = height
+			work = append(work, target)
+		case height:
+		default:
+			return verifyError(where, target, "inconsistent stack height, %d or %d", heights[target], height)
+		}
+		return nil
+	}
+
+	for len(work) > 0 {
+		offset := work[len(work)-1]
+		work = work[:len(work)-1]
+		if offset == len(ins) {
+			continue
+		}
+		in := decode(ins, offset)
+		height := heights[offset]
+
+		switch in.op {
+		case code.OpGetLocal, code.OpSetLocal, code.OpTeeLocal, code.OpCaptureLocal:
+			if in.operands[0] >= fn.NumLocals {
+				return verifyError(where, offset, "local index %d out of range, there are %d locals", in.operands[0], fn.NumLocals)
+			}
+		case code.OpGetFree, code.OpSetFree, code.OpCaptureFree:
+			if in.operands[0] >= numFree {
+				return verifyError(where, offset, "free variable index %d out of range, there are %d free variables", in.operands[0], numFree)
+			}
+		}
+
+		pops, pushes := code.StackEffect(in.op, in.operands)
+		if pops > height {
+			return verifyError(where, offset, "%s pops %d values from a stack of height %d", in.def.Name, pops, height)
+		}
+		after := height - pops + pushes
+		if after > StackSize {
+			return verifyError(where, offset, "stack overflow")
+		}
+
+		for _, i := range covering[offset] {
+			h := fn.Handlers[i]
+			if height < h.Height {
+				return verifyError(where, offset, "stack height %d is below the height %d of handler %d", height, h.Height, i)
+			}
+			if err := reach(offset, h.Target, h.Height+1); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		switch in.op {
+		case code.OpReturnValue, code.OpReturn, code.OpThrow:
+		case code.OpJump:
+			err = reach(offset, in.operands[0], after)
+		case code.OpJumpNotTruthy, code.OpJumpTruthy:
+			if err = reach(offset, in.operands[0], after); err == nil {
+				err = reach(offset, in.next, after)
+			}
+		case code.OpIterNext:
+			// an exhausted iterator is popped and execution jumps
+			if err = reach(offset, in.operands[0], height-1); err == nil {
+				err = reach(offset, in.next, after)
+			}
+		default:
+			err = reach(offset, in.next, after)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
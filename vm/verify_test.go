@@ -0,0 +1,180 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"interpreter/code"
+	"interpreter/compiler"
+	"interpreter/object"
+	"testing"
+)
+
+func concat(list ...[]byte) code.Instructions {
+	ins := code.Instructions{}
+	for _, b := range list {
+		ins = append(ins, b...)
+	}
+	return ins
+}
+
+func TestVerify(t *testing.T) {
+	fn := func(numLocals, numParameters int, list ...[]byte) *object.CompiledFunction {
+		return &object.CompiledFunction{Instructions: concat(list...), NumLocals: numLocals, NumParameters: numParameters}
+	}
+	one := &object.Integer{Value: 1}
+
+	tests := []struct {
+		main      code.Instructions
+		constants []object.Object
+		expected  string
+	}{
+		{
+			concat(code.Make(code.OpConstant, 0), code.Make(code.OpPop)),
+			[]object.Object{one},
+			"",
+		},
+		{
+			concat(code.Make(code.OpConstant, 1)),
+			[]object.Object{one},
+			"main program: 0000: constant index 1 out of range, there are 1 constants",
+		},
+		{
+			concat(code.Make(code.OpClosure, 0, 0)),
+			[]object.Object{one},
+			"main program: 0000: constant 0 is *object.Integer, not a compiled function",
+		},
+		{
+			concat(code.Make(code.OpGetBuiltin, 1000)),
+			nil,
+			fmt.Sprintf("main program: 0000: builtin index 1000 out of range, there are %d builtins", len(object.Builtins)),
+		},
+		{
+			concat(code.Make(code.OpGetLocal, 0)),
+			nil,
+			"main program: 0000: local index 0 out of range, there are 0 locals",
+		},
+		{
+			concat(code.Make(code.OpAdd)),
+			nil,
+			"main program: 0000: OpAdd pops 2 values from a stack of height 0",
+		},
+		{
+			// if (true) { 1 } without a value for the missing else branch
+			concat(
+				code.Make(code.OpTrue),
+				code.Make(code.OpJumpNotTruthy, 10),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpJump, 10),
+				code.Make(code.OpPop),
+			),
+			[]object.Object{one},
+			"main program: 0010: inconsistent stack height, 0 or 1",
+		},
+		{
+			concat(code.Make(code.OpJump, 1)),
+			nil,
+			"main program: 0000: OpJump target 1 is not the start of an instruction",
+		},
+		{
+			concat(code.Make(code.OpClosure, 0, 0)),
+			[]object.Object{fn(1, 0, code.Make(code.OpGetLocal, 0), code.Make(code.OpPop))},
+			"function 0: 0003: execution runs off the end of the function",
+		},
+		{
+			concat(code.Make(code.OpClosure, 0, 0)),
+			[]object.Object{fn(0, 0, code.Make(code.OpGetFree, 0), code.Make(code.OpReturnValue))},
+			"function 0: 0000: free variable index 0 out of range, there are 0 free variables",
+		},
+		{
+			concat(code.Make(code.OpClosure, 0, 0)),
+			[]object.Object{fn(1, 2, code.Make(code.OpReturn))},
+			"function 0: 2 parameters exceed 1 locals",
+		},
+		{
+			// functions that are never turned into closures are not run
+			nil,
+			[]object.Object{fn(0, 0, code.Make(code.OpPop))},
+			"",
+		},
+	}
+
+	for _, tt := range tests {
+		err := Verify(&compiler.Bytecode{Instructions: tt.main, Constants: tt.constants})
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error.\nwant=%q\ngot =%v", tt.expected, err)
+		}
+	}
+}
+
+func TestVerifyErrorType(t *testing.T) {
+	err := Verify(&compiler.Bytecode{Instructions: code.Instructions{255}})
+	var verifyErr *code.VerifyError
+	if !errors.As(err, &verifyErr) {
+		t.Fatalf("expected *code.VerifyError. got=%T (%v)", err, err)
+	}
+	if verifyErr.Offset != 0 {
+		t.Errorf("wrong offset. want=0, got=%d", verifyErr.Offset)
+	}
+}
+
+func TestVerifyHandlers(t *testing.T) {
+	// null; throw; pop
+	main := concat(code.Make(code.OpNull), code.Make(code.OpThrow), code.Make(code.OpPop))
+	tests := []struct {
+		handlers []code.Handler
+		expected string
+	}{
+		{[]code.Handler{{Start: 0, End: 2, Target: 2, Height: 0}}, ""},
+		{nil, ""},
+		{
+			[]code.Handler{{Start: 0, End: 4, Target: 2, Height: 0}},
+			"main program: 0000: handler 0 range 0-4 is out of range",
+		},
+		{
+			[]code.Handler{{Start: 0, End: 2, Target: 3, Height: 0}},
+			"main program: 0000: handler 0 target 3 is not the start of an instruction",
+		},
+		{
+			[]code.Handler{{Start: 0, End: 2, Target: 2, Height: 1}},
+			"main program: 0000: stack height 0 is below the height 1 of handler 0",
+		},
+	}
+
+	for _, tt := range tests {
+		err := Verify(&compiler.Bytecode{Instructions: main, Handlers: tt.handlers})
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error.\nwant=%q\ngot =%v", tt.expected, err)
+		}
+	}
+}
+
+func TestRunVerifiedBytecode(t *testing.T) {
+	// the verifier checks the stack heights but not the types of the
+	// values, the VM must fail instead of panicking on the wrong ones
+	main := concat(
+		code.Make(code.OpTrue),
+		code.Make(code.OpIterNext, 10, 1),
+		code.Make(code.OpPop),
+		code.Make(code.OpJump, 1),
+	)
+	bytecode := &compiler.Bytecode{Instructions: main}
+	if err := Verify(bytecode); err != nil {
+		t.Fatalf("unexpected verify error: %s", err)
+	}
+	err := New(bytecode).Run()
+	if err == nil || err.Error() != "not an iterator: BOOLEAN" {
+		t.Errorf("wrong error. want=%q, got=%v", "not an iterator: BOOLEAN", err)
+	}
+}
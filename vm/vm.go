@@ -1,10 +1,13 @@
 package vm
 
 import (
+	"errors"
 	"fmt"
 	"interpreter/code"
 	"interpreter/compiler"
 	"interpreter/object"
+	"math"
+	"math/big"
 )
 
 const StackSize = 2048
@@ -22,11 +25,23 @@ type VM struct {
 	globals     []object.Object
 	frames      []*Frame
 	framesIndex int
+
+	openUpvalues []openUpvalue
+}
+
+// openUpvalue is an upvalue that still points into the stack.
+type openUpvalue struct {
+	slot    int
+	upvalue *object.Upvalue
 }
 
 func New(bytecode *compiler.Bytecode) *VM {
 	frames := make([]*Frame, MaxFrames)
-	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions}
+	mainFn := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		SourceMap:    bytecode.SourceMap,
+		Handlers:     bytecode.Handlers,
+	}
 	mainClosure := &object.Closure{Fn: mainFn}
 	mainFrame := NewFrame(mainClosure, 0)
 	frames[0] = mainFrame
@@ -60,13 +75,32 @@ func (vm *VM) popFrame() *Frame {
 	return vm.frames[vm.framesIndex]
 }
 
+// Run executes the program. Errors thrown inside try blocks are caught, see
+// catch. If the program fails, the error is a *RuntimeError.
 func (vm *VM) Run() error {
+	for {
+		err := vm.run()
+		if err == nil || !vm.catch(err) {
+			return err
+		}
+	}
+}
+
+// run executes instructions until the program ends or fails.
+func (vm *VM) run() (err error) {
 	var ins code.Instructions
 	var op code.Opcode
+	var ip int
+	defer func() {
+		if err != nil {
+			err = vm.runtimeError(err, op, ip)
+		}
+	}()
 	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
 		vm.currentFrame().ip++
+		ip = vm.currentFrame().ip
 		ins = vm.currentFrame().Instructions()
-		op = code.Opcode(ins[vm.currentFrame().ip])
+		op = code.Opcode(ins[ip])
 		// opstring, _ := code.Lookup(ins[vm.currentFrame().ip])
 		// fmt.Printf("operation: %s\n", opstring.Name)
 		switch op {
@@ -79,20 +113,18 @@ func (vm *VM) Run() error {
 			}
 		case code.OpPop:
 			vm.pop()
-		case code.OpAdd:
-			vm.executeBinaryOperation(op)
-		case code.OpSub:
-			vm.executeBinaryOperation(op)
-		case code.OpDiv:
-			vm.executeBinaryOperation(op)
-		case code.OpMul:
-			vm.executeBinaryOperation(op)
-		case code.OpEqual:
-			vm.executeBinaryOperation(op)
-		case code.OpNotEqual:
-			vm.executeBinaryOperation(op)
-		case code.OpGreaterThan:
-			vm.executeBinaryOperation(op)
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod, code.OpPow,
+			code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan, code.OpLessEqual, code.OpGreaterEqual,
+			code.OpBitAnd, code.OpBitOr, code.OpBitXor, code.OpShiftLeft, code.OpShiftRight:
+			err := vm.executeBinaryOperation(op)
+			if err != nil {
+				return err
+			}
+		case code.OpBitNot:
+			err := vm.executeBitNotOperator()
+			if err != nil {
+				return err
+			}
 		case code.OpTrue:
 			err := vm.push(True)
 			if err != nil {
@@ -130,6 +162,37 @@ func (vm *VM) Run() error {
 			if !isTruthy(condition) {
 				vm.currentFrame().ip = pos - 1
 			}
+		case code.OpJumpTruthy:
+			pos := code.ReadUint16(ins[vm.currentFrame().ip+1:])
+			vm.currentFrame().ip += 2
+
+			condition := vm.pop()
+
+			if isTruthy(condition) {
+				vm.currentFrame().ip = pos - 1
+			}
+		case code.OpIter:
+			iterable := vm.pop()
+			it, ok := object.NewIterator(iterable)
+			if !ok {
+				return fmt.Errorf("not iterable: %s", iterable.Type())
+			}
+			err := vm.push(it)
+			if err != nil {
+				return err
+			}
+		case code.OpIterNext:
+			pos := code.ReadUint16(ins[vm.currentFrame().ip+1:])
+			numValues := code.ReadUint16(ins[vm.currentFrame().ip+3:])
+			vm.currentFrame().ip += 4
+
+			done, err := vm.executeIterNext(numValues)
+			if err != nil {
+				return err
+			}
+			if done {
+				vm.currentFrame().ip = pos - 1
+			}
 		case code.OpSetGlobal:
 			index := code.ReadUint16(ins[vm.currentFrame().ip+1:])
 			vm.currentFrame().ip += 2
@@ -172,31 +235,26 @@ func (vm *VM) Run() error {
 		case code.OpIndex:
 			indexObj := vm.pop()
 			left := vm.pop()
-			switch left := left.(type) {
-			case *object.Array:
-				index, ok := indexObj.(*object.Integer)
-				if !ok {
-					return fmt.Errorf("index must be integer: %s", indexObj.Type())
-				}
-				idx := int(index.Value)
-				if idx >= 0 && idx < len(left.Elements) {
-					vm.push(left.Elements[index.Value])
-				} else {
-					vm.push(Null)
-				}
-			case *object.Hash:
-				key, ok := indexObj.(object.Hashable)
-				if !ok {
-					return fmt.Errorf("unusebale as hashkey: %s", indexObj.Type())
-				}
-				pair, ok := left.Pairs[key.HashKey()]
-				if ok {
-					vm.push(pair.Value)
-				} else {
-					vm.push(Null)
-				}
-			default:
-				return fmt.Errorf("index operator not supported %s", left.Type())
+			err := vm.executeIndexExpression(left, indexObj)
+			if err != nil {
+				return err
+			}
+		case code.OpSetIndex:
+			value := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
+			err := vm.executeSetIndex(left, index, value)
+			if err != nil {
+				return err
+			}
+		case code.OpDup2:
+			err := vm.push(vm.stack[vm.sp-2])
+			if err != nil {
+				return err
+			}
+			err = vm.push(vm.stack[vm.sp-2])
+			if err != nil {
+				return err
 			}
 		case code.OpClosure:
 			index := code.ReadUint16(ins[vm.currentFrame().ip+1:])
@@ -209,30 +267,26 @@ func (vm *VM) Run() error {
 		case code.OpCall:
 			numArgs := code.ReadUint16(ins[vm.currentFrame().ip+1:])
 			vm.currentFrame().ip += 2
-			obj := vm.stack[vm.sp-1-numArgs]
-			switch fn := obj.(type) {
-			case *object.Closure:
-				if numArgs != fn.Fn.NumParameters {
-					return fmt.Errorf("wrong number of arguments: want=%d, got=%d", fn.Fn.NumParameters, numArgs)
-				}
-				frame := NewFrame(fn, vm.sp-numArgs)
-				vm.pushFrame(frame)
-				vm.sp = frame.basePointer + fn.Fn.NumLocals
-			case *object.Builtin:
-				args := vm.stack[vm.sp-numArgs : vm.sp]
-				result := fn.Fn(args...)
-				vm.sp = vm.sp - numArgs - 1
-				if result != nil {
-					vm.push(result)
-				} else {
-					vm.push(Null)
-				}
-			default:
-				return fmt.Errorf("calling non-closure and non-builtin")
+			err := vm.callFunction(numArgs)
+			if err != nil {
+				return err
+			}
+		case code.OpTailCall:
+			numArgs := code.ReadUint16(ins[vm.currentFrame().ip+1:])
+			vm.currentFrame().ip += 2
+			err := vm.tailCall(numArgs)
+			if err != nil {
+				return err
 			}
 		case code.OpReturnValue:
 			value := vm.pop()
+			if vm.framesIndex == 1 {
+				// a return statement in the main program ends it, leaving
+				// value as the last popped element
+				return nil
+			}
 			frame := vm.popFrame()
+			vm.closeUpvalues(frame.basePointer)
 			vm.sp = frame.basePointer - 1
 
 			err := vm.push(value)
@@ -240,18 +294,29 @@ func (vm *VM) Run() error {
 				return err
 			}
 		case code.OpReturn:
+			if vm.framesIndex == 1 {
+				return nil
+			}
 			frame := vm.popFrame()
+			vm.closeUpvalues(frame.basePointer)
 			vm.sp = frame.basePointer - 1
 
 			err := vm.push(Null)
 			if err != nil {
 				return err
 			}
+		case code.OpThrow:
+			return thrown(vm.pop())
 		case code.OpSetLocal:
 			localIndex := code.ReadUint16(ins[vm.currentFrame().ip+1:])
 			vm.currentFrame().ip += 2
 			frame := vm.currentFrame()
 			vm.stack[frame.basePointer+localIndex] = vm.pop()
+		case code.OpTeeLocal:
+			localIndex := code.ReadUint16(ins[vm.currentFrame().ip+1:])
+			vm.currentFrame().ip += 2
+			frame := vm.currentFrame()
+			vm.stack[frame.basePointer+localIndex] = vm.stack[vm.sp-1]
 		case code.OpGetLocal:
 			localIndex := code.ReadUint16(ins[vm.currentFrame().ip+1:])
 			vm.currentFrame().ip += 2
@@ -274,6 +339,30 @@ func (vm *VM) Run() error {
 
 			currentClosure := vm.currentFrame().cl
 
+			err := vm.push(currentClosure.Free[index].Get())
+			if err != nil {
+				return err
+			}
+		case code.OpSetFree:
+			index := code.ReadUint16(ins[vm.currentFrame().ip+1:])
+			vm.currentFrame().ip += 2
+
+			currentClosure := vm.currentFrame().cl
+			currentClosure.Free[index].Set(vm.pop())
+		case code.OpCaptureLocal:
+			localIndex := code.ReadUint16(ins[vm.currentFrame().ip+1:])
+			vm.currentFrame().ip += 2
+
+			upvalue := vm.captureUpvalue(vm.currentFrame().basePointer + localIndex)
+			err := vm.push(upvalue)
+			if err != nil {
+				return err
+			}
+		case code.OpCaptureFree:
+			index := code.ReadUint16(ins[vm.currentFrame().ip+1:])
+			vm.currentFrame().ip += 2
+
+			currentClosure := vm.currentFrame().cl
 			err := vm.push(currentClosure.Free[index])
 			if err != nil {
 				return err
@@ -289,15 +378,120 @@ func (vm *VM) Run() error {
 	return nil
 }
 
+func (vm *VM) executeIndexExpression(left, indexObj object.Object) error {
+	switch left := left.(type) {
+	case *object.Array:
+		if indexObj.Type() != object.INTEGER_OBJ {
+			return fmt.Errorf("index must be integer: %s", indexObj.Type())
+		}
+		index, ok := indexObj.(*object.Integer)
+		if ok && index.Value >= 0 && index.Value < int64(len(left.Elements)) {
+			return vm.push(left.Elements[index.Value])
+		}
+		return vm.push(Null)
+	case *object.Hash:
+		key, ok := indexObj.(object.Hashable)
+		if !ok {
+			return fmt.Errorf("unusebale as hashkey: %s", indexObj.Type())
+		}
+		if pair, ok := left.Pairs[key.HashKey()]; ok {
+			return vm.push(pair.Value)
+		}
+		return vm.push(Null)
+	case *object.Exception:
+		key, ok := indexObj.(*object.String)
+		if !ok {
+			return fmt.Errorf("index must be string: %s", indexObj.Type())
+		}
+		if field, ok := left.Field(key.Value); ok {
+			return vm.push(field)
+		}
+		return vm.push(Null)
+	default:
+		return fmt.Errorf("index operator not supported %s", left.Type())
+	}
+}
+
+func (vm *VM) executeSetIndex(left, index, value object.Object) error {
+	switch left := left.(type) {
+	case *object.Array:
+		if big, ok := index.(*object.BigInteger); ok {
+			return fmt.Errorf("index out of range: %s", big.Inspect())
+		}
+		i, ok := index.(*object.Integer)
+		if !ok {
+			return fmt.Errorf("index must be integer: %s", index.Type())
+		}
+		if i.Value < 0 || i.Value >= int64(len(left.Elements)) {
+			return fmt.Errorf("index out of range: %d", i.Value)
+		}
+		left.Elements[i.Value] = value
+		return nil
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return fmt.Errorf("unusebale as hashkey: %s", index.Type())
+		}
+		left.Pairs[key.HashKey()] = object.HashPair{Key: index, Value: value}
+		return nil
+	}
+	return fmt.Errorf("index assignment not supported: %s", left.Type())
+}
+
+// executeIterNext advances the iterator on top of the stack, leaving it in
+// place, and pushes the key and the value or, if numValues is 1, only the
+// element. Once the iterator is exhausted it is popped and true is returned.
+func (vm *VM) executeIterNext(numValues int) (bool, error) {
+	// the verifier does not track types, hand-made bytecode may iterate
+	// over anything
+	it, ok := vm.stack[vm.sp-1].(*object.Iterator)
+	if !ok {
+		return false, fmt.Errorf("not an iterator: %s", vm.stack[vm.sp-1].Type())
+	}
+	if numValues == 1 {
+		element, ok := it.NextElement()
+		if !ok {
+			vm.pop()
+			return true, nil
+		}
+		return false, vm.push(element)
+	}
+	key, value, ok := it.Next()
+	if !ok {
+		vm.pop()
+		return true, nil
+	}
+	err := vm.push(key)
+	if err != nil {
+		return false, err
+	}
+	return false, vm.push(value)
+}
+
 func (vm *VM) executeMinusOperator() error {
 	operand := vm.pop()
-	if operand.Type() == object.INTEGER_OBJ {
-		rv := operand.(*object.Integer).Value
-		return vm.push(&object.Integer{Value: -rv})
+	switch operand := operand.(type) {
+	case *object.Integer:
+		return vm.push(object.NegInt64(operand.Value))
+	case *object.BigInteger:
+		return vm.push(object.NewInteger(new(big.Int).Neg(operand.Value)))
+	case *object.Float:
+		return vm.push(&object.Float{Value: -operand.Value})
 	}
 	return fmt.Errorf("unsupported type for minus operation: %s", operand.Type())
 }
 
+func (vm *VM) executeBitNotOperator() error {
+	operand := vm.pop()
+	switch operand := operand.(type) {
+	case *object.Integer:
+		return vm.push(&object.Integer{Value: ^operand.Value})
+	case *object.BigInteger:
+		return vm.push(object.NewInteger(new(big.Int).Not(operand.Value)))
+	}
+	return fmt.Errorf("unsupported type for bitwise not operation: %s", operand.Type())
+}
+
 func (vm *VM) executeBangOperator() error {
 	operand := vm.pop()
 	switch operand {
@@ -319,6 +513,9 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 	if left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ {
 		return vm.executeBinaryIntegerOperation(left, right, op)
 	}
+	if isNumber(left) && isNumber(right) {
+		return vm.executeBinaryFloatOperation(left, right, op)
+	}
 	if left.Type() == object.BOOLEAN_OBJ && right.Type() == object.BOOLEAN_OBJ {
 		return vm.executeBinaryBooleanOperation(left, right, op)
 	}
@@ -329,28 +526,168 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 }
 
 func (vm *VM) executeBinaryIntegerOperation(left, right object.Object, op code.Opcode) error {
-	lv := left.(*object.Integer).Value
-	rv := right.(*object.Integer).Value
+	l, lok := left.(*object.Integer)
+	r, rok := right.(*object.Integer)
+	if !lok || !rok {
+		return vm.executeBinaryBigIntegerOperation(left, right, op)
+	}
+	lv := l.Value
+	rv := r.Value
 	switch op {
 	case code.OpAdd:
-		return vm.push(&object.Integer{Value: lv + rv})
+		return vm.push(object.AddInt64(lv, rv))
 	case code.OpSub:
-		return vm.push(&object.Integer{Value: lv - rv})
+		return vm.push(object.SubInt64(lv, rv))
 	case code.OpMul:
-		return vm.push(&object.Integer{Value: lv * rv})
+		return vm.push(object.MulInt64(lv, rv))
 	case code.OpDiv:
-		return vm.push(&object.Integer{Value: lv / rv})
+		if rv == 0 {
+			return fmt.Errorf("division by zero")
+		}
+		return vm.push(object.DivInt64(lv, rv))
+	case code.OpMod:
+		if rv == 0 {
+			return fmt.Errorf("modulo by zero")
+		}
+		return vm.push(&object.Integer{Value: lv % rv})
+	case code.OpPow:
+		if object.PowTooLarge(big.NewInt(lv), rv) {
+			return fmt.Errorf("exponent too large: %d", rv)
+		}
+		return vm.push(object.PowInt(big.NewInt(lv), rv))
+	case code.OpBitAnd:
+		return vm.push(&object.Integer{Value: lv & rv})
+	case code.OpBitOr:
+		return vm.push(&object.Integer{Value: lv | rv})
+	case code.OpBitXor:
+		return vm.push(&object.Integer{Value: lv ^ rv})
+	case code.OpShiftLeft:
+		if rv < 0 {
+			return fmt.Errorf("negative shift count: %d", rv)
+		}
+		if rv > math.MaxUint32 || object.ShlTooLarge(big.NewInt(lv), uint64(rv)) {
+			return fmt.Errorf("shift count too large: %d", rv)
+		}
+		return vm.push(object.ShlInt64(lv, uint64(rv)))
+	case code.OpShiftRight:
+		if rv < 0 {
+			return fmt.Errorf("negative shift count: %d", rv)
+		}
+		return vm.push(&object.Integer{Value: lv >> uint64(rv)})
 	case code.OpEqual:
 		return vm.push(nativeBoolToBooleanObject(lv == rv))
 	case code.OpNotEqual:
 		return vm.push(nativeBoolToBooleanObject(lv != rv))
 	case code.OpGreaterThan:
 		return vm.push(nativeBoolToBooleanObject(lv > rv))
+	case code.OpLessThan:
+		return vm.push(nativeBoolToBooleanObject(lv < rv))
+	case code.OpLessEqual:
+		return vm.push(nativeBoolToBooleanObject(lv <= rv))
+	case code.OpGreaterEqual:
+		return vm.push(nativeBoolToBooleanObject(lv >= rv))
 	default:
 		return fmt.Errorf("unknown integer operator: %d", op)
 	}
 }
 
+func (vm *VM) executeBinaryBigIntegerOperation(left, right object.Object, op code.Opcode) error {
+	lv := object.ToBigInt(left)
+	rv := object.ToBigInt(right)
+	switch op {
+	case code.OpAdd:
+		return vm.push(object.NewInteger(new(big.Int).Add(lv, rv)))
+	case code.OpSub:
+		return vm.push(object.NewInteger(new(big.Int).Sub(lv, rv)))
+	case code.OpMul:
+		return vm.push(object.NewInteger(new(big.Int).Mul(lv, rv)))
+	case code.OpDiv:
+		if rv.Sign() == 0 {
+			return fmt.Errorf("division by zero")
+		}
+		return vm.push(object.NewInteger(new(big.Int).Quo(lv, rv)))
+	case code.OpMod:
+		if rv.Sign() == 0 {
+			return fmt.Errorf("modulo by zero")
+		}
+		return vm.push(object.NewInteger(new(big.Int).Rem(lv, rv)))
+	case code.OpPow:
+		if !rv.IsInt64() || object.PowTooLarge(lv, rv.Int64()) {
+			return fmt.Errorf("exponent too large: %s", rv)
+		}
+		return vm.push(object.PowInt(lv, rv.Int64()))
+	case code.OpBitAnd:
+		return vm.push(object.NewInteger(new(big.Int).And(lv, rv)))
+	case code.OpBitOr:
+		return vm.push(object.NewInteger(new(big.Int).Or(lv, rv)))
+	case code.OpBitXor:
+		return vm.push(object.NewInteger(new(big.Int).Xor(lv, rv)))
+	case code.OpShiftLeft, code.OpShiftRight:
+		if rv.Sign() < 0 {
+			return fmt.Errorf("negative shift count: %s", rv)
+		}
+		if !rv.IsUint64() || rv.Uint64() > math.MaxUint32 {
+			return fmt.Errorf("shift count too large: %s", rv)
+		}
+		if op == code.OpShiftLeft && object.ShlTooLarge(lv, rv.Uint64()) {
+			return fmt.Errorf("shift count too large: %s", rv)
+		}
+		if op == code.OpShiftLeft {
+			return vm.push(object.NewInteger(new(big.Int).Lsh(lv, uint(rv.Uint64()))))
+		}
+		return vm.push(object.NewInteger(new(big.Int).Rsh(lv, uint(rv.Uint64()))))
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(lv.Cmp(rv) == 0))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(lv.Cmp(rv) != 0))
+	case code.OpGreaterThan:
+		return vm.push(nativeBoolToBooleanObject(lv.Cmp(rv) > 0))
+	case code.OpLessThan:
+		return vm.push(nativeBoolToBooleanObject(lv.Cmp(rv) < 0))
+	case code.OpLessEqual:
+		return vm.push(nativeBoolToBooleanObject(lv.Cmp(rv) <= 0))
+	case code.OpGreaterEqual:
+		return vm.push(nativeBoolToBooleanObject(lv.Cmp(rv) >= 0))
+	default:
+		return fmt.Errorf("unknown integer operator: %d", op)
+	}
+}
+
+// executeBinaryFloatOperation handles float operands as well as a float
+// mixed with an integer, in which case the integer is promoted to a float.
+func (vm *VM) executeBinaryFloatOperation(left, right object.Object, op code.Opcode) error {
+	lv := object.ToFloat(left)
+	rv := object.ToFloat(right)
+	switch op {
+	case code.OpAdd:
+		return vm.push(&object.Float{Value: lv + rv})
+	case code.OpSub:
+		return vm.push(&object.Float{Value: lv - rv})
+	case code.OpMul:
+		return vm.push(&object.Float{Value: lv * rv})
+	case code.OpDiv:
+		return vm.push(&object.Float{Value: lv / rv})
+	case code.OpMod:
+		return vm.push(&object.Float{Value: math.Mod(lv, rv)})
+	case code.OpPow:
+		return vm.push(&object.Float{Value: math.Pow(lv, rv)})
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(lv == rv))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(lv != rv))
+	case code.OpGreaterThan:
+		return vm.push(nativeBoolToBooleanObject(lv > rv))
+	case code.OpLessThan:
+		return vm.push(nativeBoolToBooleanObject(lv < rv))
+	case code.OpLessEqual:
+		return vm.push(nativeBoolToBooleanObject(lv <= rv))
+	case code.OpGreaterEqual:
+		return vm.push(nativeBoolToBooleanObject(lv >= rv))
+	default:
+		return fmt.Errorf("unknown float operator: %d", op)
+	}
+}
+
 func (vm *VM) executeBinaryBooleanOperation(left, right object.Object, op code.Opcode) error {
 	lv := left.(*object.Boolean).Value
 	rv := right.(*object.Boolean).Value
@@ -376,8 +713,8 @@ func (vm *VM) executeBinaryStringOperation(left, right object.Object, op code.Op
 }
 
 func (vm *VM) push(c object.Object) error {
-	if vm.sp > StackSize {
-		return fmt.Errorf("stack oveflow")
+	if vm.sp >= StackSize {
+		return fmt.Errorf("stack overflow")
 	}
 	vm.stack[vm.sp] = c
 	vm.sp++
@@ -410,9 +747,15 @@ func (vm *VM) pushClosure(index, numFree int) error {
 	if !ok {
 		return fmt.Errorf("not a function: %+v", constant)
 	}
-	free := make([]object.Object, numFree)
+	free := make([]*object.Upvalue, numFree)
 	for i := 0; i < numFree; i++ {
-		free[i] = vm.stack[vm.sp-numFree+i]
+		switch captured := vm.stack[vm.sp-numFree+i].(type) {
+		case *object.Upvalue:
+			free[i] = captured
+		default:
+			// a closure capturing the function it is defined in
+			free[i] = object.NewClosedUpvalue(captured)
+		}
 	}
 	vm.sp = vm.sp - numFree
 
@@ -420,6 +763,94 @@ func (vm *VM) pushClosure(index, numFree int) error {
 	return vm.push(closure)
 }
 
+// captureUpvalue returns the open upvalue for the stack slot, creating it if
+// the slot has not been captured yet, so that all closures share it.
+func (vm *VM) captureUpvalue(slot int) *object.Upvalue {
+	for _, open := range vm.openUpvalues {
+		if open.slot == slot {
+			return open.upvalue
+		}
+	}
+	upvalue := &object.Upvalue{Location: &vm.stack[slot]}
+	vm.openUpvalues = append(vm.openUpvalues, openUpvalue{slot: slot, upvalue: upvalue})
+	return upvalue
+}
+
+// callFunction calls the function below the numArgs arguments on top of the
+// stack.
+func (vm *VM) callFunction(numArgs int) error {
+	obj := vm.stack[vm.sp-1-numArgs]
+	switch fn := obj.(type) {
+	case *object.Closure:
+		if numArgs != fn.Fn.NumParameters {
+			return fmt.Errorf("wrong number of arguments: want=%d, got=%d", fn.Fn.NumParameters, numArgs)
+		}
+		if vm.framesIndex == MaxFrames || vm.sp-numArgs+fn.Fn.NumLocals >= StackSize {
+			return fmt.Errorf("stack overflow")
+		}
+		frame := NewFrame(fn, vm.sp-numArgs)
+		vm.pushFrame(frame)
+		vm.sp = frame.basePointer + fn.Fn.NumLocals
+	case *object.Builtin:
+		args := vm.stack[vm.sp-numArgs : vm.sp]
+		result := fn.Fn(args...)
+		vm.sp = vm.sp - numArgs - 1
+		if err, ok := result.(*object.Error); ok {
+			return errors.New(err.Message)
+		}
+		if result != nil {
+			vm.push(result)
+		} else {
+			vm.push(Null)
+		}
+	default:
+		return fmt.Errorf("calling non-closure and non-builtin")
+	}
+	return nil
+}
+
+// tailCall makes a call whose result the current function returns. A
+// closure replaces the function in the current frame, so that tail
+// recursion runs in constant space, everything else is called like by
+// OpCall.
+func (vm *VM) tailCall(numArgs int) error {
+	fn, ok := vm.stack[vm.sp-1-numArgs].(*object.Closure)
+	if !ok || vm.framesIndex == 1 {
+		return vm.callFunction(numArgs)
+	}
+	if numArgs != fn.Fn.NumParameters {
+		return fmt.Errorf("wrong number of arguments: want=%d, got=%d", fn.Fn.NumParameters, numArgs)
+	}
+	frame := vm.currentFrame()
+	if frame.basePointer+fn.Fn.NumLocals >= StackSize {
+		return fmt.Errorf("stack overflow")
+	}
+	vm.closeUpvalues(frame.basePointer)
+	// move the closure and the arguments to where the caller put ours
+	copy(vm.stack[frame.basePointer-1:], vm.stack[vm.sp-1-numArgs:vm.sp])
+	frame.cl = fn
+	frame.ip = -1
+	vm.sp = frame.basePointer + fn.Fn.NumLocals
+	return nil
+}
+
+// closeUpvalues closes all open upvalues pointing at or above the given
+// stack slot. It must be called before a frame's slots are reused.
+func (vm *VM) closeUpvalues(base int) {
+	if len(vm.openUpvalues) == 0 {
+		return
+	}
+	open := vm.openUpvalues[:0]
+	for _, o := range vm.openUpvalues {
+		if o.slot >= base {
+			o.upvalue.Close()
+		} else {
+			open = append(open, o)
+		}
+	}
+	vm.openUpvalues = open
+}
+
 func nativeBoolToBooleanObject(v bool) *object.Boolean {
 	if v {
 		return True
@@ -427,6 +858,10 @@ func nativeBoolToBooleanObject(v bool) *object.Boolean {
 	return False
 }
 
+func isNumber(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
+
 func isTruthy(condition object.Object) bool {
 	switch condition {
 	case True:
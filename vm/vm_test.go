@@ -3,10 +3,14 @@ package vm
 import (
 	"fmt"
 	"interpreter/ast"
+	"interpreter/code"
 	"interpreter/compiler"
+	"interpreter/evaluator"
 	"interpreter/lexer"
 	"interpreter/object"
 	"interpreter/parser"
+	"math"
+	"strings"
 	"testing"
 )
 
@@ -34,6 +38,56 @@ type vmTestCase struct {
 	expected interface{}
 }
 
+// vmInspectTestCase compares the Inspect output of the result, which is
+// convenient for big integers, floats and collections.
+type vmInspectTestCase struct {
+	input    string
+	expected string
+}
+
+func runVmInspectTests(t *testing.T, tests []vmInspectTestCase) {
+	t.Helper()
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("'%s' compiler error: %s", tt.input, err)
+		}
+		vm := New(comp.Bytecode())
+		if err := vm.Run(); err != nil {
+			t.Fatalf("'%s' vm error: %s", tt.input, err)
+		}
+		if got := vm.LastPoppedStackElem().Inspect(); got != tt.expected {
+			t.Errorf("'%s' wrong result. want=%s, got=%s", tt.input, tt.expected, got)
+		}
+	}
+}
+
+// runEngineComparisons checks that the VM leaves the value the evaluator
+// returns for each input, running the bytecode through Verify first.
+func runEngineComparisons(t *testing.T, inputs []string) {
+	t.Helper()
+
+	for _, input := range inputs {
+		evaluated := evaluator.Eval(parse(input), object.NewEnvironment())
+
+		comp := compiler.New()
+		if err := comp.Compile(parse(input)); err != nil {
+			t.Fatalf("%q: compiler error: %s", input, err)
+		}
+		if err := Verify(comp.Bytecode()); err != nil {
+			t.Fatalf("%q: verify error: %s", input, err)
+		}
+		vm := New(comp.Bytecode())
+		if err := vm.Run(); err != nil {
+			t.Fatalf("%q: vm error: %s", input, err)
+		}
+		if actual := vm.LastPoppedStackElem(); actual.Inspect() != evaluated.Inspect() {
+			t.Errorf("%q: engines disagree. vm=%s, evaluator=%s", input, actual.Inspect(), evaluated.Inspect())
+		}
+	}
+}
+
 func runVmTests(t *testing.T, tests []vmTestCase) {
 	t.Helper()
 	for _, tt := range tests {
@@ -54,6 +108,10 @@ func runVmTests(t *testing.T, tests []vmTestCase) {
 			fmt.Printf("\n")
 		}
 
+		err = Verify(comp.Bytecode())
+		if err != nil {
+			t.Fatalf("'%s' verify error: %s", tt.input, err)
+		}
 		vm := New(comp.Bytecode())
 		err = vm.Run()
 		if err != nil {
@@ -77,6 +135,11 @@ func testExpectedObject(
 		if err != nil {
 			t.Errorf("'%s' testIntegerObject failed: %s", name, err)
 		}
+	case float64:
+		err := testFloatObject(expected, actual)
+		if err != nil {
+			t.Errorf("'%s' testFloatObject failed: %s", name, err)
+		}
 	case bool:
 		err := testBooleanObject(bool(expected), actual)
 		if err != nil {
@@ -142,6 +205,19 @@ func testExpectedObject(
 	}
 }
 
+func testFloatObject(expected float64, actual object.Object) error {
+	result, ok := actual.(*object.Float)
+	if !ok {
+		return fmt.Errorf("object is not Float. got=%T (%+v)",
+			actual, actual)
+	}
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. got=%g, want=%g",
+			result.Value, expected)
+	}
+	return nil
+}
+
 func testBooleanObject(expected bool, actual object.Object) error {
 	result, ok := actual.(*object.Boolean)
 	if !ok {
@@ -207,6 +283,53 @@ func TestIntegerArithmetic(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestBigIntegers(t *testing.T) {
+	tests := []vmInspectTestCase{
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		{"-9223372036854775807 - 2", "-9223372036854775809"},
+		{"4611686018427387904 * 4", "18446744073709551616"},
+		{"let f = fn(n) { if (n == 0) { 1 } else { n * f(n - 1) } }; f(25)", "15511210043330985984000000"},
+		{"let f = fn(n) { if (n == 0) { 1 } else { n * f(n - 1) } }; f(25) / f(23)", "600"},
+		{"-(9223372036854775807 + 1)", "-9223372036854775808"},
+		{"(9223372036854775807 + 1) > 9223372036854775807", "true"},
+		{"9223372036854775807 < (9223372036854775807 + 1)", "true"},
+		{"(9223372036854775807 + 1) == (9223372036854775807 + 1)", "true"},
+		{"(9223372036854775807 + 1) * 0.5", "4.611686018427388e+18"},
+		{"[1, 2][9223372036854775807 + 1]", "null"},
+		{"9223372036854775808", "9223372036854775808"},
+		{"-9223372036854775808", "-9223372036854775808"},
+		{"18446744073709551616 - 1", "18446744073709551615"},
+		{"let x = 100000000000000000000; x / 10", "10000000000000000000"},
+	}
+	runVmInspectTests(t, tests)
+	runVmTests(t, []vmTestCase{
+		{"(9223372036854775807 + 1) - 1", 9223372036854775807},
+		{"-9223372036854775808", math.MinInt64},
+	})
+}
+
+func TestFloatArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"1.5", 1.5},
+		{"-2.5", -2.5},
+		{"1.5 + 1.5", 3.0},
+		{"0.25 * 3", 0.75},
+		{"1 + 0.5", 1.5},
+		{"0.5 + 1", 1.5},
+		{"7 / 2.0", 3.5},
+		{"2 * (1.25 - 1)", 0.5},
+		{"1.5 < 2", true},
+		{"2 > 1.5", true},
+		{"1 == 1.0", true},
+		{"1.5 != 1.5", false},
+		{"float(3) / 2", 1.5},
+		{"int(2.9)", 2},
+		{"round(2.5)", 3},
+		{"round(2.345, 2)", 2.35},
+	}
+	runVmTests(t, tests)
+}
+
 func TestBooleanExpressions(t *testing.T) {
 	tests := []vmTestCase{
 		{"true", true},
@@ -222,6 +345,179 @@ func TestBooleanExpressions(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestLogicalAndComparisonOperators(t *testing.T) {
+	tests := []vmTestCase{
+		{"1 <= 2", true},
+		{"2 <= 2", true},
+		{"3 <= 2", false},
+		{"1 >= 2", false},
+		{"2 >= 2", true},
+		{"1.5 >= 1", true},
+		{"1 <= 0.5", false},
+		{"true && true", true},
+		{"true && false", false},
+		{"false && true", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 && 2", true},
+		{"if (false) { 1 } || 0", true},
+		{"1 < 2 && 2 < 3", true},
+		{"1 > 2 || 2 > 3", false},
+		{"if (1 < 2 && 3 >= 3) { 10 } else { 20 }", 10},
+		{"let f = fn() { [][0][0] }; false && f()", false},
+		{"let f = fn() { [][0][0] }; true || f()", true},
+		// not folded by the compiler
+		{"let a = 1; let b = 2; a < b", true},
+		{"let a = 2; let b = 2; a < b", false},
+		{"let a = 2; let b = 2; a <= b", true},
+		{"let a = 3; let b = 2; a <= b", false},
+		{"let a = 2; let b = 2; a >= b", true},
+		{"let a = 1; let b = 2; a >= b", false},
+		{"let a = 9223372036854775807 + 1; let b = 1; b < a", true},
+		{"let a = 9223372036854775807 + 1; a <= a", true},
+		{"let a = 9223372036854775807 + 1; let b = 1; b >= a", false},
+		{"let a = 0.5; let b = 1; a < b", true},
+		{"let a = 0.5; let b = 1; b <= a", false},
+		{"let a = 0.5; a >= a", true},
+	}
+	runVmTests(t, tests)
+}
+
+func TestArithmeticAndBitwiseOperators(t *testing.T) {
+	tests := []vmInspectTestCase{
+		{"7 % 3", "1"},
+		{"-7 % 3", "-1"},
+		{"7.5 % 2", "1.5"},
+		{"2 ** 10", "1024"},
+		{"2 ** 3 ** 2", "512"},
+		{"-2 ** 2", "-4"},
+		{"2 ** -1", "0.5"},
+		{"2 ** 0.5 * 2 ** 0.5", "2.0000000000000004"},
+		{"2 ** 64", "18446744073709551616"},
+		{"(2 ** 64) % 10", "6"},
+		{"12 & 10", "8"},
+		{"12 | 10", "14"},
+		{"12 ^ 10", "6"},
+		{"~5", "-6"},
+		{"~(2 ** 64)", "-18446744073709551617"},
+		{"(2 ** 64) & (2 ** 64 + 1)", "18446744073709551616"},
+		{"1 << 4", "16"},
+		{"1 << 64", "18446744073709551616"},
+		{"(1 << 64) >> 60", "16"},
+		{"-16 >> 2", "-4"},
+		{"1 >> 70", "0"},
+		{"1 + 2 * 3 % 4", "3"},
+		{"1 ** 99999999999", "1"},
+		{"(1 << 16777215) >> 16777215", "1"},
+		{"((1 << 64) << 16777151) >> 16777214", "2"},
+		{"0 << 4294967295", "0"},
+	}
+	runVmInspectTests(t, tests)
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{"5 % 0", "modulo by zero"},
+		{"(2 ** 64) % 0", "modulo by zero"},
+		{"1 << -1", "negative shift count: -1"},
+		{"3 ** 99999999999", "exponent too large: 99999999999"},
+		{"(2 ** 64) ** 99999999", "exponent too large: 99999999"},
+		{"1 << 16777216", "shift count too large: 16777216"},
+		{"(1 << 64) << 16777152", "shift count too large: 16777152"},
+		{"-1 << 4294967295", "shift count too large: 4294967295"},
+		{"~1.5", "unsupported type for bitwise not operation: FLOAT"},
+		{"for (x in 5) { x }", "not iterable: INTEGER"},
+		{"let a = [1]; a[1] = 2", "index out of range: 1"},
+		{"let a = [1]; a[2 ** 64] = 2", "index out of range: 18446744073709551616"},
+		{"range(2 ** 63)", "argument to `range` out of range: 9223372036854775808"},
+		{"range(1, 2.5)", "argument to `range` must be INTEGER, got FLOAT"},
+		{"round(1.5, -(2 ** 63) - 1)", "second argument to `round` out of range: -9223372036854775809"},
+		{"let s = \"ab\"; s[0] = \"c\"", "index assignment not supported: STRING"},
+		{"let a = 0; 5 / a", "division by zero"},
+		{"(2 ** 64) / 0", "division by zero"},
+		{"let t = true; 1 + t", "unsupported types for binary operation: INTEGER BOOLEAN"},
+		{"let t = true; t < 1", "unsupported types for binary operation: BOOLEAN INTEGER"},
+		{"[1][true]", "index must be integer: BOOLEAN"},
+		{"let f = fn(n) { f(n + 1) + 1 }; f(0)", "stack overflow"},
+		{"len(1)", "argument to `len` not supported, got INTEGER"},
+		{`len("one", "two")`, "wrong number of arguments. got=2, want=1"},
+		{"first(1)", "argument to `first` must be ARRAY, got INTEGER"},
+		{"last(1)", "argument to `last` must be ARRAY, got INTEGER"},
+		{"push(1, 1)", "argument to `push` must be ARRAY, got INTEGER"},
+		{`throw "oops"`, "oops"},
+		{"throw [1, 2]", "[1, 2]"},
+		{"let f = fn() { throw 1 }; try { f() } finally { 2 }", "1"},
+		{`try { 1 / 0 } catch (e) { throw e["message"] + "!" }`, "division by zero!"},
+	}
+	for _, tt := range tests {
+		program := parse(tt.input)
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		err := vm.Run()
+		if err == nil {
+			t.Fatalf("'%s' expected VM error but resulted in none.", tt.input)
+		}
+		if err.Error() != tt.expected {
+			t.Fatalf("wrong VM error: want=%q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func TestRuntimeErrorStack(t *testing.T) {
+	input := `let div = fn(a, b) {
+  a / b
+};
+let f = fn(x) { div(x, 0) + 1 };
+f(3);
+`
+	comp := compiler.New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	err := New(comp.Bytecode()).Run()
+	rerr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("error is not *RuntimeError. got=%T (%v)", err, err)
+	}
+	if rerr.Op != code.OpDiv {
+		t.Errorf("wrong opcode. want=OpDiv, got=%d", rerr.Op)
+	}
+
+	expected := []struct {
+		constant int
+		op       code.Opcode
+		line     int
+		column   int
+	}{
+		{0, code.OpDiv, 2, 3},
+		{3, code.OpCall, 4, 17},
+		{-1, code.OpCall, 5, 1},
+	}
+	if len(rerr.Stack) != len(expected) {
+		t.Fatalf("wrong stack depth. want=%d, got=%d\n%s", len(expected), len(rerr.Stack), rerr.StackTrace())
+	}
+	for i, want := range expected {
+		f := rerr.Stack[i]
+		if f.Constant != want.constant || f.Op != want.op || f.Pos.Line != want.line || f.Pos.Column != want.column {
+			t.Errorf("wrong stack frame %d. got=%+v", i, f)
+		}
+		if code.Opcode(f.Fn.Instructions[f.IP]) != f.Op {
+			t.Errorf("stack frame %d: IP %d is not at %d", i, f.IP, f.Op)
+		}
+	}
+	if rerr.IP != rerr.Stack[0].IP {
+		t.Errorf("wrong IP. want=%d, got=%d", rerr.Stack[0].IP, rerr.IP)
+	}
+
+	trace := rerr.StackTrace()
+	if !strings.HasPrefix(trace, "\tfunction 0: 0006 OpDiv (2:3)\n") {
+		t.Errorf("wrong stack trace:\n%s", trace)
+	}
+}
+
 func TestConditionals(t *testing.T) {
 	tests := []vmTestCase{
 		{"if (true) { 10 }", 10},
@@ -238,6 +534,201 @@ func TestConditionals(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestAssignStatements(t *testing.T) {
+	tests := []vmInspectTestCase{
+		{"let x = 1; x = 2; x", "2"},
+		{"let x = 10; x -= 3; x *= 2; x /= 7; x", "2"},
+		{"let x = \"a\"; x += \"b\"; x", "ab"},
+		{"let i = 0; while (true) { i += 1; if (i == 5) { break; } } i", "5"},
+		{"let i = 0; let sum = 0; while (i < 10) { i += 1; if (i % 2 == 0) { continue; } sum += i; } sum", "25"},
+		{"let sum = 0; for (x in range(101)) { sum += x; } sum", "5050"},
+		{"let c = 0; for (i in range(3)) { for (j in range(3)) { if (j == i) { continue; } c += 1; } } c", "6"},
+		{"let f = fn() { let n = 0; for (x in [1, 2, 3]) { n += x; } n }; f()", "6"},
+		{"let f = fn(n) { n = n * 2; n }; f(21)", "42"},
+		{"let g = 1; let f = fn() { g += 1; }; f(); f(); g", "3"},
+		{"let a = [1, 2, 3]; a[1] = 20; a[2] += 10; a", "[1, 20, 13]"},
+		{"let h = {\"a\": 1}; h[\"b\"] = 2; h[\"a\"] *= 5; [h[\"a\"], h[\"b\"]]", "[5, 2]"},
+		{"let a = [1]; let b = a; b[0] = 9; a[0]", "9"},
+		{"let m = [[0, 0], [0, 0]]; m[1][0] = 7; m", "[[0, 0], [7, 0]]"},
+		{"let calls = 0; let i = fn() { calls += 1; 0 }; let a = [5]; a[i()] += 1; [a[0], calls]", "[6, 1]"},
+	}
+	runVmInspectTests(t, tests)
+}
+
+func TestAssignmentValues(t *testing.T) {
+	tests := []string{
+		"let f = fn() { let x = 1; x = 5 }; f()",
+		"let x = 1; if (true) { x = 5 }",
+		"let a = [1]; fn() { a[0] += 1 }()",
+		"let f = fn() { f = 1; }; f(); f",
+		"let f = fn(n) { if (n == 0) { f = 5; return f; } f(n - 1) }; f(3)",
+		"fn() { let f = fn() { fn() { f = 3; }() }; f(); f }()",
+		"fn() { let f = fn() { f = 2; }; f(); f }()",
+	}
+	runEngineComparisons(t, tests)
+}
+
+func TestMutableClosures(t *testing.T) {
+	tests := []vmInspectTestCase{
+		{"let mk = fn() { let n = 0; fn() { n += 1; n } }; let c = mk(); c(); c(); c()", "3"},
+		{"let mk = fn() { let n = 0; fn() { n += 1; n } }; let a = mk(); let b = mk(); a(); a(); b(); [a(), b()]", "[3, 2]"},
+		{"let pair = fn() { let n = 0; [fn() { n += 1; }, fn() { n }] }; let p = pair(); p[0](); p[0](); p[1]()", "2"},
+		{"fn() { let n = 0; let inc = fn() { n += 1; }; inc(); inc(); n }()", "2"},
+		{"fn() { let n = 1; let get = fn() { n }; n = 5; get() }()", "5"},
+		{"fn() { let n = 0; let f = fn() { fn() { n += 10; } }; f()(); f()(); n }()", "20"},
+		{"fn(n) { let add = fn(x) { n += x; }; add(2); add(3); n }(1)", "6"},
+		{"let fs = []; fn() { for (i in range(3)) { fs = push(fs, fn() { i }); } }(); [fs[0](), fs[2]()]", "[2, 2]"},
+		{"let gen = fn() { let i = 0; fn() { i += 1; i * i } }; let g = gen(); g(); g(); g()", "9"},
+		{"let memo = fn(f) { let cache = {}; let calls = 0; [fn(n) { let v = cache[n]; if (!v) { calls += 1; v = f(n); cache[n] = v; } v }, fn() { calls }] }; let m = memo(fn(x) { x * 2 }); m[0](2); m[0](2); [m[0](3), m[1]()]", "[6, 2]"},
+	}
+	runVmInspectTests(t, tests)
+}
+
+func TestWhileStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{"fn() { while (false) { 1 } 5 }()", 5},
+		{"fn() { while (true) { break; } 5 }()", 5},
+		{"fn() { while (true) { return 3; } }()", 3},
+		{"fn() { while (true) { if (true) { break; } return 1; } 2 }()", 2},
+		{"fn() { while (true) { while (true) { break; } return 4; } }()", 4},
+		{"fn() { while (false) { } }()", Null},
+		{"let f = fn(n) { while (n > 0) { return f(n - 1) + 1; } 0 }; f(10)", 10},
+		{"let x = 7; while (true) { let y = x * 2; break; } y", 14},
+	}
+	runVmTests(t, tests)
+}
+
+func TestLoopValues(t *testing.T) {
+	// the REPL prints the value of the last statement
+	tests := []string{
+		"let x = 1; while (x < 3) { x += 1 }",
+		"while (true) { break }",
+		"while (false) { 1 }",
+		"if (true) { while (false) { } }",
+		"fn() { let x = 0; while (x < 3) { x += 1 } }()",
+		"for (x in [1, 2]) { x }",
+		"for (x in [1]) { break }",
+		"fn() { for (x in []) { } }()",
+	}
+	runEngineComparisons(t, tests)
+}
+
+func TestLoopExitsInExpressions(t *testing.T) {
+	// each iteration leaves operands behind unless the jump drops them,
+	// more than the stack holds in total
+	tests := []string{
+		"let i = 0; while (i < 3000) { i += 1; let y = [1, if (i > 0) { continue; } else { 2 }]; } i",
+		"let i = 0; while (true) { i += 1; let y = 1 + if (i == 3000) { break; } else { 0 }; } i",
+		"let n = 0; for (x in range(3000)) { n += 1; puts(1, 2 + if (x >= 0) { continue; }); } n",
+		"let n = 0; for (x in range(3000)) { let y = [n, if (x == 2999) { break; } else { n += 1; }]; } n",
+		"let n = 0; for (x in range(3000)) { [1, if (true) { try { continue; } finally { n += 1; } }]; } n",
+		"let n = 0; for (x in range(3000)) { [1, if (true) { try { throw x; } catch (e) { n += 1; continue; } }]; } n",
+	}
+	runEngineComparisons(t, tests)
+}
+
+func TestPeepholeOptimizations(t *testing.T) {
+	tests := []vmTestCase{
+		{"let f = fn(x) { if (!x) { 1 } else { 2 } }; [f(true), f(false), f(0)]", []int{2, 1, 2}},
+		{"let f = fn(n) { let i = 0; while (!(i == n)) { i += 1; } i }; f(5)", 5},
+		{"fn() { let a = 3; let b = a; a = 4; b }()", 3},
+		{"fn() { let a = 1; let g = fn() { a }; a = 2; g() }()", 2},
+		{"fn() { while (true) { if (false) { return 1; } else { return 2; } } }()", 2},
+	}
+	runVmTests(t, tests)
+}
+
+// BenchmarkPeephole runs a loop with and without the optimizations of the
+// compiler. The loop spends most of its time on the condition and on locals
+// stored and loaded right away, the code the peephole pass rewrites.
+func BenchmarkPeephole(b *testing.B) {
+	input := `
+let sum = fn(n) {
+	let i = 0;
+	let total = 0;
+	while (true) {
+		if (!(i < n)) {
+			break;
+		}
+		let square = i * i;
+		total = total + square;
+		i += 1;
+	}
+	total
+};
+sum(100000);
+`
+	for _, optimize := range []bool{true, false} {
+		b.Run(fmt.Sprintf("optimize=%t", optimize), func(b *testing.B) {
+			comp := compiler.New()
+			comp.SetOptimizations(optimize)
+			if err := comp.Compile(parse(input)); err != nil {
+				b.Fatalf("compiler error: %s", err)
+			}
+			bytecode := comp.Bytecode()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				vm := New(bytecode)
+				if err := vm.Run(); err != nil {
+					b.Fatalf("vm error: %s", err)
+				}
+			}
+		})
+	}
+}
+
+func TestForStatements(t *testing.T) {
+	tests := []vmInspectTestCase{
+		{"fn() { for (x in [1, 2, 3]) { if (x > 1) { return x; } } }()", "2"},
+		{"fn() { for (i, x in [5, 6, 7]) { if (x == 6) { return i; } } }()", "1"},
+		{"fn() { for (x in []) { return 1; } 0 }()", "0"},
+		{"fn() { for (k in {\"b\": 2, \"a\": 1}) { return k; } }()", "a"},
+		{"fn() { for (k, v in {3: \"c\", -1: \"a\", 2: \"b\"}) { return [k, v]; } }()", "[-1, a]"},
+		{"fn() { for (i, c in \"h\\u{e9}llo\") { if (i == 1) { return c; } } }()", "\u00e9"},
+		{"fn() { for (x in range(10, 0, -3)) { if (x < 5) { return x; } } }()", "4"},
+		{"fn() { for (i, x in range(3)) { if (i == 2) { return x; } } }()", "2"},
+		{"fn() { for (x in range(3)) { if (x == 0) { continue; } return x; } }()", "1"},
+		{"fn() { for (x in [1, 2]) { for (y in [3, 4]) { break; } return x; } }()", "1"},
+		{"fn() { for (x in [1, 2]) { break; } 9 }()", "9"},
+		{"for (x in [1, 2, 3]) { } x", "3"},
+		{"len(range(0, 10, 3))", "4"},
+		{"len(range(5, 0))", "0"},
+		{"len(range(-9223372036854775808, 9223372036854775807))", "18446744073709551615"},
+		{"len(range(-9223372036854775808, 9223372036854775807, 2))", "9223372036854775808"},
+		{"range(1, 5)", "range(1, 5)"},
+		{"range(5, 1, -2)", "range(5, 1, -2)"},
+	}
+	runVmInspectTests(t, tests)
+}
+
+func TestMacros(t *testing.T) {
+	tests := []vmTestCase{
+		{"let double = macro(x) { quote(unquote(x) * 2) }; double(1 + 2)", 6},
+		{"let unless = macro(c, a, b) { quote(if (!(unquote(c))) { unquote(a) } else { unquote(b) }) }; unless(10 > 5, 1, 2)", 2},
+		{"let swap = macro(a, b) { quote(unquote(b) - unquote(a)) }; let f = fn(x) { swap(x, 10) }; f(3)", 7},
+		{"let inc = macro(x) { quote(unquote(x) + 1) }; let n = 1; inc(inc(n))", 3},
+		{"let sq = macro(x) { let y = quote(unquote(x) * unquote(x)); y }; sq(3) + sq(4)", 25},
+	}
+	for _, tt := range tests {
+		program := parse(tt.input)
+		macros := object.NewEnvironment()
+		evaluator.DefineMacros(program, macros)
+		expanded, err := evaluator.ExpandMacros(program, macros)
+		if err != nil {
+			t.Fatalf("'%s' macro expansion error: %s", tt.input, err)
+		}
+		comp := compiler.New()
+		if err := comp.Compile(expanded); err != nil {
+			t.Fatalf("'%s' compiler error: %s", tt.input, err)
+		}
+		vm := New(comp.Bytecode())
+		if err := vm.Run(); err != nil {
+			t.Fatalf("'%s' vm error: %s", tt.input, err)
+		}
+		testExpectedObject(t, tt.input, tt.expected, vm.LastPoppedStackElem())
+	}
+}
+
 func TestGlobalLetStatements(t *testing.T) {
 	tests := []vmTestCase{
 		{"let one = 1; one", 1},
@@ -300,6 +791,11 @@ func TestIndexExpressions(t *testing.T) {
 		{"{1: 1, 2: 2}[2]", 2},
 		{"{1: 1}[0]", Null},
 		{"{}[0]", Null},
+		{"{1: 1}[1.0]", 1},
+		{"{0.0: 1}[-0.0]", 1},
+		{"{1.5: 1}[1.5]", 1},
+		{"{1: 1}[1.5]", Null},
+		{"{2 ** 64: 1}[18446744073709551616.0]", 1},
 	}
 	runVmTests(t, tests)
 }
@@ -359,6 +855,14 @@ noReturnTwo();
 	runVmTests(t, tests)
 }
 
+func TestTopLevelReturn(t *testing.T) {
+	tests := []vmTestCase{
+		{"1; return 5 * 2; 3;", 10},
+		{"let x = 1; if (x > 0) { return x; } 99", 1},
+	}
+	runVmTests(t, tests)
+}
+
 func TestFirstClassFunctions(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -507,6 +1011,10 @@ func TestCallingFunctionsWithWrongArguments(t *testing.T) {
 			input:    `fn(a, b) { a + b; }(1);`,
 			expected: `wrong number of arguments: want=2, got=1`,
 		},
+		{
+			input:    `let f = fn(a) { a; }; fn() { f(1, 2) }();`,
+			expected: `wrong number of arguments: want=1, got=2`,
+		},
 	}
 	for _, tt := range tests {
 		program := parse(tt.input)
@@ -531,43 +1039,17 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`len("")`, 0},
 		{`len("four")`, 4},
 		{`len("hello world")`, 11},
-		{
-			`len(1)`,
-			&object.Error{
-				Message: "argument to `len` not supported, got INTEGER",
-			},
-		},
-		{`len("one", "two")`,
-			&object.Error{
-				Message: "wrong number of arguments. got=2, want=1",
-			},
-		},
 		{`len([1, 2, 3])`, 3},
 		{`len([])`, 0},
 		{`puts("hello", "world!")`, Null},
 		{`first([1, 2, 3])`, 1},
 		{`first([])`, Null},
-		{`first(1)`,
-			&object.Error{
-				Message: "argument to `first` must be ARRAY, got INTEGER",
-			},
-		},
 		{`last([1, 2, 3])`, 3},
 		{`last([])`, Null},
-		{`last(1)`,
-			&object.Error{
-				Message: "argument to `last` must be ARRAY, got INTEGER",
-			},
-		},
 		{`rest([1, 2, 3])`, []int{2, 3}},
 		{`rest([])`, Null},
 		{`push([], 1)`, []int{1}},
 		{`push([], 1, 2, 3)`, []int{1, 2, 3}},
-		{`push(1, 1)`,
-			&object.Error{
-				Message: "argument to `push` must be ARRAY, got INTEGER",
-			},
-		},
 	}
 	runVmTests(t, tests)
 }
@@ -660,6 +1142,146 @@ wrapper();
 	runVmTests(t, tests)
 }
 
+// TestEvaluationOrder runs programs logging the order in which operands are
+// evaluated on the VM and on the evaluator, which must agree.
+func TestEvaluationOrder(t *testing.T) {
+	prelude := "let log = []; let f = fn(x) { log = push(log, x); x }; "
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"f(1) < f(2)", "[1, 2]"},
+		{"f(1) <= f(2)", "[1, 2]"},
+		{"f(1) > f(2)", "[1, 2]"},
+		{"f(1) >= f(2)", "[1, 2]"},
+		{"f(1) == f(2)", "[1, 2]"},
+		{"f(1) - f(2) * f(3)", "[1, 2, 3]"},
+		{"f(3) < f(2) == f(false)", "[3, 2, false]"},
+		{"f(1.5) >= f(1)", "[1.5, 1]"},
+		{"f(true) && f(false) || f(3)", "[true, false, 3]"},
+		{"-f(1) < ~f(2)", "[1, 2]"},
+		{"[f(1), f(2)][f(0)]", "[1, 2, 0]"},
+		{"fn(a, b) { f(3) }(f(1), f(2))", "[1, 2, 3]"},
+		{"let x = f(1) < f(2); f(x)", "[1, 2, true]"},
+	}
+	for _, tt := range tests {
+		input := prelude + tt.input + "; log"
+		evaluated := evaluator.Eval(parse(input), object.NewEnvironment())
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q: wrong evaluator order. want=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+
+		comp := compiler.New()
+		if err := comp.Compile(parse(input)); err != nil {
+			t.Fatalf("%q: compiler error: %s", tt.input, err)
+		}
+		vm := New(comp.Bytecode())
+		if err := vm.Run(); err != nil {
+			t.Fatalf("%q: vm error: %s", tt.input, err)
+		}
+		if actual := vm.LastPoppedStackElem(); actual.Inspect() != evaluated.Inspect() {
+			t.Errorf("%q: engines disagree. vm=%s, evaluator=%s", tt.input, actual.Inspect(), evaluated.Inspect())
+		}
+	}
+}
+
+func TestExceptions(t *testing.T) {
+	prelude := "let log = []; let say = fn(x) { log = push(log, x) }; "
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`try { say(1); throw "a"; say(2) } catch (e) { say(e["message"]) }`, "[1, a]"},
+		// thrown values
+		{`try { throw {"code": 1} } catch (e) { say(e["value"]["code"]) }`, "[1]"},
+		{`try { throw [1, 2] } catch (e) { say(e["value"][1]); say(e["message"]) }`, "[2, [1, 2]]"},
+		{`try { throw "s" } catch (e) { say(e["value"]) }`, "[s]"},
+		{`try { 1 / 0 } catch (e) { say(e["value"]) }`, "[null]"},
+		{`try { try { throw {"a": 1} } catch (e) { throw e } } catch (e) { say(e["value"]["a"]) }`, "[1]"},
+		{`let f = fn() { throw 7 }; try { f() } catch (e) { say(e["value"] * 6) }`, "[42]"},
+		{`try { say(1) } catch (e) { say(2) }`, "[1]"},
+		{`try { say(1) } finally { say(2) }`, "[1, 2]"},
+		{`try { say(1); 1 / 0 } catch (e) { say(e["message"]) } finally { say(3) }`, "[1, division by zero, 3]"},
+		{`try { len(1) } catch (e) { say(e["message"]) }`, "[argument to `len` not supported, got INTEGER]"},
+		{`try { throw {"a": 1}["b"] } catch (e) { say(e["message"]) }`, "[null]"},
+		{`try { throw 1 } catch (e) { say(e["nothing"]) }`, "[null]"},
+		// unwinding frames
+		{`let g = fn(n) { if (n == 0) { throw "deep" } g(n - 1) + 1 }; try { g(5) } catch (e) { say(e["message"]) }`, "[deep]"},
+		{`let t = fn() { throw "t" }; let u = fn() { try { return t() } catch (e) { return e["message"] } }; say(u())`, "[t]"},
+		{`let cs = []; let m = fn(n) { let v = n; cs = push(cs, fn() { v }); throw "m" }; try { m(7) } catch (e) { say(cs[0]()) }`, "[7]"},
+		// finally blocks run on the way out
+		{`let h = fn() { try { return 1 } finally { say("f") } }; say(h())`, "[f, 1]"},
+		{`let k = fn() { try { throw "x" } finally { return 5 } }; say(k())`, "[5]"},
+		{`let i = 0; while (i < 3) { i += 1; try { if (i == 2) { continue } if (i == 3) { break } say(i) } finally { say(-i) } }`, "[1, -1, -2, -3]"},
+		{`for (x in [1, 2, 3]) { try { if (x == 2) { break } say(x) } finally { say(0) } }`, "[1, 0, 0]"},
+		{`try { try { throw "inner" } finally { say("f1") } } catch (e) { say(e["message"]) }`, "[f1, inner]"},
+		{`try { try { throw 1 } catch (e) { say("c"); throw 2 } finally { say("f") } } catch (e) { say(e["message"]) }`, "[c, f, 2]"},
+		{`let r = fn() { try { try { return 1 } finally { say(2) } } finally { say(3) } }; say(r())`, "[2, 3, 1]"},
+		{`try { throw 1 } catch (a) { try { throw 2 } catch (b) { say(a["message"] + b["message"]) } }`, "[12]"},
+		// values on the stack below the try statement
+		{`say([1, 2, if (true) { try { throw "x" } catch (e) { say(e["message"]) }; 3 }])`, "[x, [1, 2, 3]]"},
+		{`for (x in [1, 2]) { try { [1][x] + len(x) } catch (e) { say(x) } }`, "[1, 2]"},
+		{`for (x in [1]) { for (y in [2]) { try { throw x + y } catch (e) { say(e["message"]) } } }`, "[3]"},
+		{`for (x in [1, 2, 3]) { if (x == 2) { break } try { throw x } catch (e) { say(e["message"]) } }`, "[1]"},
+		{`say(true && if (true) { try { throw 1 } catch (e) { say(2) }; 3 })`, "[2, true]"},
+		{`say(false || [1, if (true) { try { throw 1 } catch (e) { say(2) }; 3 }][1] == 3)`, "[2, true]"},
+		// stack traces
+		{`let f = fn() { 1 / 0 }; try { f() } catch (e) { say(e["stack"]) }`, "[[1:70, 1:85]]"},
+		// g calls f in tail position, the position of that call is missing
+		{`let f = fn() { 1 / 0 }; let g = fn() { f() }; try { g() } catch (e) { say(e["stack"]) }`, "[[1:70, 1:107]]"},
+		{`let r = fn() { throw "r" }; let w = fn() { try { r() } catch (e) { throw e } }; try { w() } catch (e) { say(e["stack"]) }`, "[[1:70, 1:104, 1:141]]"},
+	}
+	for _, tt := range tests {
+		input := prelude + tt.input + "; log"
+		evaluated := evaluator.Eval(parse(input), object.NewEnvironment())
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%q: wrong evaluator result. want=%s, got=%s", tt.input, tt.expected, evaluated.Inspect())
+		}
+
+		for _, optimize := range []bool{true, false} {
+			comp := compiler.New()
+			comp.SetOptimizations(optimize)
+			if err := comp.Compile(parse(input)); err != nil {
+				t.Fatalf("%q: compiler error: %s", tt.input, err)
+			}
+			if err := Verify(comp.Bytecode()); err != nil {
+				t.Fatalf("%q: verify error: %s", tt.input, err)
+			}
+			vm := New(comp.Bytecode())
+			if err := vm.Run(); err != nil {
+				t.Fatalf("%q: vm error: %s", tt.input, err)
+			}
+			if actual := vm.LastPoppedStackElem(); actual.Inspect() != tt.expected {
+				t.Errorf("%q (optimize=%t): wrong vm result. want=%s, got=%s", tt.input, optimize, tt.expected, actual.Inspect())
+			}
+		}
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []vmTestCase{
+		{"let countdown = fn(n) { if (n == 0) { 0 } else { countdown(n - 1) } }; countdown(100000)", 0},
+		{"let countdown = fn(n) { if (n == 0) { return 0; } return countdown(n - 1); }; countdown(100000)", 0},
+		{
+			`let odd = 0;
+			let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } };
+			odd = fn(n) { if (n == 0) { false } else { even(n - 1) } };
+			even(100001)`,
+			false,
+		},
+		{"let sum = fn(n, acc) { if (n == 0) { acc } else { sum(n - 1, acc + n) } }; sum(100000, 0)", 5000050000},
+		// the upvalues of the replaced frame are closed first
+		{
+			`let f = fn(n, fs) { if (n == 0) { fs } else { f(n - 1, push(fs, fn() { n })) } };
+			let fs = f(3, []);
+			fs[0]() * 100 + fs[1]() * 10 + fs[2]()`,
+			321,
+		},
+		{"let f = fn() { len([1, 2]) }; f() + 1", 3},
+	}
+	runVmTests(t, tests)
+}
+
 func TestRecursiveFibonacci(t *testing.T) {
 	tests := []vmTestCase{
 		{